@@ -0,0 +1,110 @@
+package lockable_lru
+
+/*
+ * Adaptive Replacement Cache policy for the unlocked entries
+ *
+ * Adapted from hashicorp/golang-lru's arc package. Entries used once are kept in the recent segment (T1) and entries
+ * used more than once in the frequent segment (T2). The keys of entries evicted from each segment are remembered in a
+ * ghost list (B1 and B2), each holding at most as many keys as the cache size, or as the cache holds entries when it is
+ * unbounded. Adding a key found in a ghost list shifts the target size p of the recent segment towards the segment it
+ * was evicted from.
+ *
+ */
+import (
+	gmap "github.com/wk8/go-ordered-map/v2"
+)
+
+const (
+	arcRecent   = 0
+	arcFrequent = 1
+)
+
+type arcPolicy[K comparable, V any] struct {
+	p            int                           //target size of the recent segment
+	recentEvict  *gmap.OrderedMap[K, struct{}] //keys recently evicted from the recent segment (B1)
+	frequentEvict *gmap.OrderedMap[K, struct{}] //keys recently evicted from the frequent segment (B2)
+	addedFromFrequentEvict bool                 //whether the key being added was found in frequentEvict
+}
+
+func newARCPolicy[K comparable, V any]() *arcPolicy[K, V] {
+	return &arcPolicy[K, V]{
+		recentEvict:   gmap.New[K, struct{}](),
+		frequentEvict: gmap.New[K, struct{}](),
+	}
+}
+
+func (a *arcPolicy[K, V]) segmentCount() int {
+	return 2
+}
+
+func (a *arcPolicy[K, V]) onAdd(c *unlockedLRU[K, V], key K) int {
+	a.addedFromFrequentEvict = false
+
+	if _, ok := a.recentEvict.Get(key); ok {
+		delta := 1
+		if a.recentEvict.Len() < a.frequentEvict.Len() {
+			delta = a.frequentEvict.Len() / a.recentEvict.Len()
+		}
+		a.p = min(a.p+delta, c.size)
+		a.recentEvict.Delete(key)
+		return arcFrequent
+	}
+
+	if _, ok := a.frequentEvict.Get(key); ok {
+		delta := 1
+		if a.frequentEvict.Len() < a.recentEvict.Len() {
+			delta = a.recentEvict.Len() / a.frequentEvict.Len()
+		}
+		a.p = max(a.p-delta, 0)
+		a.frequentEvict.Delete(key)
+		a.addedFromFrequentEvict = true
+		return arcFrequent
+	}
+
+	return arcRecent
+}
+
+func (a *arcPolicy[K, V]) onHit(c *unlockedLRU[K, V], key K, segment int) int {
+	return arcFrequent
+}
+
+func (a *arcPolicy[K, V]) rebalance(c *unlockedLRU[K, V]) {
+	a.p = min(a.p, c.size)
+}
+
+func (a *arcPolicy[K, V]) victimSegment(c *unlockedLRU[K, V]) int {
+	recentLen := c.segments[arcRecent].Len()
+	if recentLen > 0 && (recentLen > a.p || (recentLen == a.p && a.addedFromFrequentEvict)) {
+		return arcRecent
+	}
+	return arcFrequent
+}
+
+func (a *arcPolicy[K, V]) onEvicted(c *unlockedLRU[K, V], key K, segment int) {
+	ghosts := a.frequentEvict
+	if segment == arcRecent {
+		ghosts = a.recentEvict
+	}
+	a.addedFromFrequentEvict = false
+	ghosts.Delete(key)
+	ghosts.Set(key, struct{}{})
+	for ghosts.Len() > c.share(1) {
+		ghosts.Delete(ghosts.Oldest().Key)
+	}
+}
+
+func (a *arcPolicy[K, V]) clone() segmentPolicy[K, V] {
+	clone := *a
+	clone.recentEvict = cloneGhosts(a.recentEvict)
+	clone.frequentEvict = cloneGhosts(a.frequentEvict)
+	return &clone
+}
+
+//returns a copy of a ghost list, keeping the order of its keys
+func cloneGhosts[K comparable](ghosts *gmap.OrderedMap[K, struct{}]) *gmap.OrderedMap[K, struct{}] {
+	clone := gmap.New[K, struct{}]()
+	for pair := ghosts.Oldest(); pair != nil; pair = pair.Next() {
+		clone.Set(pair.Key, struct{}{})
+	}
+	return clone
+}
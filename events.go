@@ -0,0 +1,135 @@
+package lockable_lru
+
+/*
+ * Events published by an LLRU or ThreadunsafeLLRU to its subscribers
+ *
+ * Unlike the callbacks set with SetOnAdd, SetOnLock and the like, of which there is only one of each, any number of
+ * subscribers can observe the same cache independently. Subscribers are called synchronously, in the order they
+ * subscribed, after the callbacks.
+ *
+ */
+
+// EventKind tells what happened to an entry
+type EventKind int
+
+const (
+	EventAdd    EventKind = iota //a key which did not exist was added
+	EventUpdate                  //the value of an existing entry was replaced
+	EventEvict                   //an entry was evicted to make room for another entry
+	EventExpire                  //an entry was removed because its lifetime elapsed
+	EventLock                    //an entry became locked
+	EventUnlock                  //an entry became unlocked
+	EventRemove                  //an entry was removed, see Event.Reason
+)
+
+func (kind EventKind) String() string {
+	switch kind {
+	case EventAdd:
+		return "add"
+	case EventUpdate:
+		return "update"
+	case EventEvict:
+		return "evict"
+	case EventExpire:
+		return "expire"
+	case EventLock:
+		return "lock"
+	case EventUnlock:
+		return "unlock"
+	case EventRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes something which happened to an entry
+type Event[K comparable, V any] struct {
+	Kind EventKind
+	Key K
+	Value V //value of the entry, the new value for EventUpdate
+	OldValue V //value which was replaced, for EventUpdate
+	Locked bool //whether the entry was added locked, for EventAdd, or was locked before, for EventUpdate
+	Reason EvictionReason //why the entry left the cache, for EventEvict, EventExpire and EventRemove
+	History LockHistory //lock history of the entry which left the cache, for EventEvict, EventExpire and EventRemove
+}
+
+// Subscription identifies a subscriber, to unsubscribe it
+type Subscription uint64
+
+// EventsOverflow tells what SubscribeChan does with an event, or the Evictions channel with an entry, when the buffer of
+// the channel is full
+type EventsOverflow int
+
+const (
+	EventsDropNewest EventsOverflow = iota //drops the event being sent, keeping the events already buffered
+	EventsDropOldest                       //drops the oldest buffered event to make room for the one being sent
+	EventsBlock                            //waits until the event can be sent, holding up the operation which caused it
+)
+
+type subscriber[K comparable, V any] struct {
+	id Subscription
+	kinds []EventKind //kinds of events the subscriber receives, every kind if empty
+	fn func(event Event[K, V])
+	events chan Event[K, V] //channel fn sends to, for subscribers added with SubscribeChan
+	dropped *uint64 //number of events fn could not send to events
+}
+
+//returns a subscriber function which sends events to a channel, handling a full buffer as told by `overflow`
+func sendEvents[K comparable, V any](events chan Event[K, V], overflow EventsOverflow, dropped *uint64) func(event Event[K, V]) {
+	return func(event Event[K, V]) {
+		sendOverflowing(events, event, overflow, dropped)
+	}
+}
+
+//sends a value to a channel, handling a full buffer as told by `overflow` and counting the dropped values in `dropped`
+func sendOverflowing[T any](values chan T, value T, overflow EventsOverflow, dropped *uint64) {
+	switch overflow {
+	case EventsBlock:
+		values <- value
+	case EventsDropOldest:
+		for {
+			select {
+			case values <- value:
+				return
+			default:
+			}
+			select {
+			case <-values:
+				*dropped++
+			default: //the receiver emptied the buffer in the meantime
+			}
+		}
+	default:
+		select {
+		case values <- value:
+		default:
+			*dropped++
+		}
+	}
+}
+
+//returns whether the subscriber receives events of the given kind
+func (s subscriber[K, V]) wants(kind EventKind) bool {
+	if len(s.kinds) == 0 {
+		return true
+	}
+	for _, wanted := range s.kinds {
+		if wanted == kind {
+			return true
+		}
+	}
+	return false
+}
+
+//returns the kind of event for an entry which left the cache for the given reason
+func eventKindOf(reason EvictionReason) EventKind {
+	switch reason {
+	case EvictionReasonCapacity:
+		return EventEvict
+	case EvictionReasonExpired:
+		return EventExpire
+	default:
+		return EventRemove
+	}
+}
@@ -2,10 +2,7 @@ module github.com/codebling/go-lockable_lru
 
 go 1.22
 
-require (
-	github.com/hashicorp/golang-lru/v2 v2.0.7
-	github.com/wk8/go-ordered-map/v2 v2.1.8
-)
+require github.com/wk8/go-ordered-map/v2 v2.1.8
 
 require (
 	github.com/bahlo/generic-list-go v0.2.0 // indirect
@@ -0,0 +1,44 @@
+package lockable_lru
+
+/*
+ * A lease holds one lock on an entry of an LLRU or ThreadunsafeLLRU until it is closed.
+ *
+ * Closing a lease more than once has no effect, so `defer lease.Close()` is always safe, even if the lease was
+ * already closed explicitly.
+ *
+ */
+import (
+	"sync"
+)
+
+type Lease[K comparable, V any] struct {
+	key    K
+	value  V
+	unlock func(key K) bool
+	once   sync.Once
+}
+
+func newLease[K comparable, V any](key K, value V, unlock func(key K) bool) *Lease[K, V] {
+	return &Lease[K, V]{
+		key:    key,
+		value:  value,
+		unlock: unlock,
+	}
+}
+
+// Returns the key of the leased entry
+func (lease *Lease[K, V]) Key() K {
+	return lease.key
+}
+
+// Returns the value of the leased entry at the time the lease was acquired
+func (lease *Lease[K, V]) Value() V {
+	return lease.value
+}
+
+// Releases the lock held by the lease. Only the first call has an effect
+func (lease *Lease[K, V]) Close() {
+	lease.once.Do(func() {
+		lease.unlock(lease.key)
+	})
+}
@@ -0,0 +1,31 @@
+package lockable_lru
+
+/*
+ * Metrics reported by an LLRU or ThreadunsafeLLRU
+ *
+ * The cache only calls into a MetricsSink, so that it can be bridged to any metrics system without this package
+ * depending on one.
+ *
+ */
+import (
+	"time"
+)
+
+// Operations whose latency is passed to MetricsSink.ObserveLatency
+const (
+	MetricsOpGet    = "get"    //Get, Get2, and the methods getting values like they do
+	MetricsOpAdd    = "add"    //AddOrUpdateUnlocked, AddOrUpdateLocked, and the methods adding or updating values
+	MetricsOpRemove = "remove" //Remove, and the methods removing, purging or evicting entries
+	MetricsOpLock   = "lock"   //Lock, and the methods locking or pinning entries
+	MetricsOpUnlock = "unlock" //Unlock, and the methods unlocking or unpinning entries
+)
+
+// MetricsSink receives the activity of a cache, see SetMetrics. Its methods are called synchronously, while the
+// operation is in progress, so they should be cheap
+type MetricsSink interface {
+	IncHit()                                   //Get found a key
+	IncMiss()                                  //Get did not find a key
+	IncEviction(reason EvictionReason)         //an entry was evicted or removed
+	ObserveLatency(op string, d time.Duration) //an operation took `d`, see MetricsOpGet and the other operations
+	SetLen(n int)                              //the number of entries after an operation which may have changed it
+}
@@ -0,0 +1,196 @@
+package lockable_lru
+
+/*
+ * Functional options for the constructors of an LLRU or a ShardedLLRU
+ *
+ * Each option configures the cache as the matching setter would, right after it is constructed, so that a cache can be
+ * built in one call instead of a constructor followed by a series of setters.
+ *
+ */
+import (
+	"time"
+)
+
+// Option configures a cache built with NewWithOptions, NewUnsafeWithOptions or NewShardedWithOptions.
+type Option[K comparable, V any] func(options *options[K, V])
+
+//configuration collected from the options, applied in the order the options were given
+type options[K comparable, V any] struct {
+	policy Policy
+	onEvicted func(key K, value V)
+	shards int //number of shards of a ShardedLLRU, or 0 if WithShards was not given
+	settings []func(llru *ThreadunsafeLLRU[K, V]) error
+}
+
+//collects the configuration of the given options
+func collectOptions[K comparable, V any](opts []Option[K, V]) options[K, V] {
+	options := options[K, V]{policy: LRUPolicy()}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+//adds a setting applied once the cache is constructed
+func (options *options[K, V]) set(setting func(llru *ThreadunsafeLLRU[K, V]) error) {
+	options.settings = append(options.settings, setting)
+}
+
+// WithEvict sets the eviction callback, as passed to NewWithEvict.
+func WithEvict[K comparable, V any](onEvicted func(key K, value V)) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.onEvicted = onEvicted
+	}
+}
+
+// WithEvictReason sets an eviction callback which is also given the reason of the eviction. See SetOnEvictedWithReason
+func WithEvictReason[K comparable, V any](onEvicted func(key K, value V, reason EvictionReason)) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetOnEvictedWithReason(onEvicted)
+			return nil
+		})
+	}
+}
+
+// WithPolicy sets the policy according to which unlocked entries are evicted, as passed to NewWithPolicy.
+func WithPolicy[K comparable, V any](policy Policy) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.policy = policy
+	}
+}
+
+// WithShards splits the cache into `shards` shards, see NewShardedWithOptions. The other constructors do not support it,
+// and return an error when it is given
+func WithShards[K comparable, V any](shards int) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.shards = shards
+	}
+}
+
+// WithTTL sets the lifetime of entries. See SetDefaultTTL
+func WithTTL[K comparable, V any](ttl time.Duration) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetDefaultTTL(ttl)
+			return nil
+		})
+	}
+}
+
+// WithTTLJitter makes every lifetime randomly shorter or longer by up to `jitter` times its duration. See SetTTLJitter
+func WithTTLJitter[K comparable, V any](jitter float64) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetTTLJitter(jitter)
+			return nil
+		})
+	}
+}
+
+// WithExpireAfterAccess makes the lifetime of entries restart whenever they are read. See SetExpireAfterAccess
+func WithExpireAfterAccess[K comparable, V any]() Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetExpireAfterAccess(true)
+			return nil
+		})
+	}
+}
+
+// WithClock sets the source of the current time. See SetClock
+func WithClock[K comparable, V any](clock Clock) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetClock(clock)
+			return nil
+		})
+	}
+}
+
+// WithWeigher limits the total cost of the entries. See SetWeigher
+func WithWeigher[K comparable, V any](weigher func(key K, value V) int, maxWeight int) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetWeigher(weigher, maxWeight)
+			return nil
+		})
+	}
+}
+
+// WithWatermarks makes adding entries evict unlocked entries in batches. See SetWatermarks
+func WithWatermarks[K comparable, V any](low, high int) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			return llru.SetWatermarks(low, high)
+		})
+	}
+}
+
+// WithMaxLocked limits the number of entries which can be locked at the same time. See SetMaxLocked
+func WithMaxLocked[K comparable, V any](maxLocked int) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetMaxLocked(maxLocked)
+			return nil
+		})
+	}
+}
+
+// WithUnlimitedLocked makes locked entries not count against the size of the cache. See SetUnlimitedLocked
+func WithUnlimitedLocked[K comparable, V any]() Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetUnlimitedLocked(true)
+			return nil
+		})
+	}
+}
+
+// WithLockCounting sets whether locks are counted. See SetLockCounting
+func WithLockCounting[K comparable, V any](enabled bool) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetLockCounting(enabled)
+			return nil
+		})
+	}
+}
+
+// WithKeepPositionOnUnlock makes unlocked entries go back to their position before they were locked. See
+// SetKeepPositionOnUnlock
+func WithKeepPositionOnUnlock[K comparable, V any]() Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetKeepPositionOnUnlock(true)
+			return nil
+		})
+	}
+}
+
+// WithSizeBounds lets the size of the cache be changed between `minSize` and `maxSize`. See SetSizeBounds
+func WithSizeBounds[K comparable, V any](minSize, maxSize int) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			return llru.SetSizeBounds(minSize, maxSize)
+		})
+	}
+}
+
+// WithMetrics sets a sink which the cache reports to. See SetMetrics
+func WithMetrics[K comparable, V any](metrics MetricsSink) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(func(llru *ThreadunsafeLLRU[K, V]) error {
+			llru.SetMetrics(metrics)
+			return nil
+		})
+	}
+}
+
+// WithSetting applies any other setting to the cache once it is constructed, for the setters which have no option of
+// their own. An error returned by `setting` is returned by the constructor
+func WithSetting[K comparable, V any](setting func(llru *ThreadunsafeLLRU[K, V]) error) Option[K, V] {
+	return func(options *options[K, V]) {
+		options.set(setting)
+	}
+}
@@ -0,0 +1,141 @@
+package lockable_lru
+
+/*
+ * Replacement policies for the unlocked entries of an LLRU
+ *
+ * A policy splits the unlocked entries into segments and decides which segment an entry goes to when it is added or
+ * used, and which segment the next victim is taken from. Locked entries are never subject to the policy.
+ *
+ */
+import (
+	"errors"
+)
+
+type policyKind int
+
+const (
+	policyLRU policyKind = iota
+	policyARC
+	policyTwoQueue
+	policySLRU
+	policyMRU
+)
+
+const (
+	// Default2QRecentRatio is the ratio of the 2Q cache dedicated to recently added entries that have only been used once.
+	Default2QRecentRatio = 0.25
+
+	// Default2QGhostEntries is the default ratio of keys evicted from the recent entries that the 2Q cache remembers.
+	Default2QGhostEntries = 0.50
+
+	// DefaultSLRUProtectedRatio is the ratio of the SLRU cache dedicated to entries that have been used more than once.
+	DefaultSLRUProtectedRatio = 0.80
+)
+
+// Policy selects how unlocked entries are chosen for eviction. The zero value is the LRU policy.
+type Policy struct {
+	kind           policyKind
+	recentRatio    float64
+	ghostRatio     float64
+	protectedRatio float64
+}
+
+// LRUPolicy evicts the least recently used unlocked entry. This is the default policy.
+func LRUPolicy() Policy {
+	return Policy{kind: policyLRU}
+}
+
+// MRUPolicy evicts the most recently used unlocked entry, other than the one being added. For workloads which scan the
+// same keys cyclically, and are larger than the cache, it keeps more hits than LRU, which would evict every entry just
+// before it is used again.
+func MRUPolicy() Policy {
+	return Policy{kind: policyMRU}
+}
+
+// ARCPolicy evicts unlocked entries following the Adaptive Replacement Cache algorithm, which balances recently and
+// frequently used entries, adapting to the workload without tuning. Entries used once and entries used more than once
+// are kept apart, and the keys of recently evicted entries are remembered to decide which of the two to favour.
+func ARCPolicy() Policy {
+	return Policy{kind: policyARC}
+}
+
+// TwoQueuePolicy evicts unlocked entries following the 2Q algorithm, with the default parameters. See
+// TwoQueuePolicyWithParams.
+func TwoQueuePolicy() Policy {
+	return TwoQueuePolicyWithParams(Default2QRecentRatio, Default2QGhostEntries)
+}
+
+// TwoQueuePolicyWithParams evicts unlocked entries following the 2Q algorithm. Newly added entries go through a
+// probation queue, taking up recentRatio of the size, and only enter the main LRU once they are used again, so that a
+// scan of entries used only once does not evict the frequently used ones. The keys of the entries evicted from the
+// probation queue are remembered, up to ghostRatio of the size, so that an entry added again soon after being evicted
+// goes straight to the main LRU. Both ratios must be between 0 and 1, otherwise creating the cache fails.
+func TwoQueuePolicyWithParams(recentRatio, ghostRatio float64) Policy {
+	return Policy{kind: policyTwoQueue, recentRatio: recentRatio, ghostRatio: ghostRatio}
+}
+
+// SLRUPolicy evicts unlocked entries following the segmented LRU algorithm, with the default parameters. See
+// SLRUPolicyWithParams.
+func SLRUPolicy() Policy {
+	return SLRUPolicyWithParams(DefaultSLRUProtectedRatio)
+}
+
+// SLRUPolicyWithParams evicts unlocked entries following the segmented LRU algorithm. Newly added entries go to the
+// probationary segment, and move to the protected segment, taking up protectedRatio of the size, when they are used
+// again. When the protected segment is full, its least recently used entry goes back to the probationary segment.
+// Entries are evicted from the probationary segment first, so entries used at least twice are much harder to evict.
+// The ratio must be between 0 and 1, otherwise creating the cache fails.
+func SLRUPolicyWithParams(protectedRatio float64) Policy {
+	return Policy{kind: policySLRU, protectedRatio: protectedRatio}
+}
+
+//decides where unlocked entries go and which one is evicted next
+type segmentPolicy[K comparable, V any] interface {
+	//number of segments the entries are split into
+	segmentCount() int
+	//returns the segment of a key which is not in the cache
+	onAdd(c *unlockedLRU[K, V], key K) (segment int)
+	//returns the segment a key moves to when it is used
+	onHit(c *unlockedLRU[K, V], key K, segment int) (newSegment int)
+	//moves entries between segments after they have changed
+	rebalance(c *unlockedLRU[K, V])
+	//returns the segment to evict from
+	victimSegment(c *unlockedLRU[K, V]) int
+	//called after an entry of the given segment is evicted for lack of room
+	onEvicted(c *unlockedLRU[K, V], key K, segment int)
+	//returns a copy of the policy and of its state, which can be used independently
+	clone() segmentPolicy[K, V]
+}
+
+func newSegmentPolicy[K comparable, V any](policy Policy) (segmentPolicy[K, V], error) {
+	switch policy.kind {
+	case policyARC:
+		return newARCPolicy[K, V](), nil
+	case policyTwoQueue:
+		if policy.recentRatio < 0.0 || policy.recentRatio > 1.0 {
+			return nil, errors.New("invalid recent ratio")
+		}
+		if policy.ghostRatio < 0.0 || policy.ghostRatio > 1.0 {
+			return nil, errors.New("invalid ghost ratio")
+		}
+		return newTwoQueuePolicy[K, V](policy.recentRatio, policy.ghostRatio), nil
+	case policySLRU:
+		if policy.protectedRatio < 0.0 || policy.protectedRatio > 1.0 {
+			return nil, errors.New("invalid protected ratio")
+		}
+		return slruPolicy[K, V]{protectedRatio: policy.protectedRatio}, nil
+	default:
+		return lruPolicy[K, V]{}, nil
+	}
+}
+
+//keeps every entry in a single segment
+type lruPolicy[K comparable, V any] struct{}
+
+func (lruPolicy[K, V]) segmentCount() int                                        { return 1 }
+func (lruPolicy[K, V]) onAdd(c *unlockedLRU[K, V], key K) int                    { return 0 }
+func (lruPolicy[K, V]) onHit(c *unlockedLRU[K, V], key K, segment int) int       { return 0 }
+func (lruPolicy[K, V]) rebalance(c *unlockedLRU[K, V])                           {}
+func (lruPolicy[K, V]) victimSegment(c *unlockedLRU[K, V]) int                   { return 0 }
+func (lruPolicy[K, V]) onEvicted(c *unlockedLRU[K, V], key K, segment int)       {}
+func (lruPolicy[K, V]) clone() segmentPolicy[K, V]                               { return lruPolicy[K, V]{} }
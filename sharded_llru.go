@@ -0,0 +1,295 @@
+package lockable_lru
+
+/*
+ * A thread-safe LLRU split into shards, for high concurrency
+ *
+ * Keys are partitioned across several LLRUs by hash, each with its own lock, so that operations on keys of different
+ * shards do not wait for each other. Each shard evicts independently, so the entry evicted for lack of room is the least
+ * recently used one of its shard, not necessarily of the whole cache.
+ *
+ */
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"reflect"
+	"runtime"
+)
+
+type ShardedLLRU[K comparable, V any] struct {
+	shards []*LLRU[K, V]
+	hash func(key K) uint64
+}
+
+// ShardedStats describes the shards of a ShardedLLRU, see ShardedLLRU.Stats
+type ShardedStats struct {
+	Shards int
+	Size int //total size of the shards, 0 if they are unbounded
+	Len int
+	LockedLen int
+	UnlockedLen int
+	MinShardLen int //number of entries of the emptiest shard
+	MaxShardLen int //number of entries of the fullest shard, which tells how evenly keys are spread along with MinShardLen
+}
+
+// NewShardedWithOptions constructs a cache of the given size, split into the number of shards given with WithShards, or
+// into as many shards as GOMAXPROCS otherwise, each configured with the other options. See NewSharded
+func NewShardedWithOptions[K comparable, V any](size int, opts ...Option[K, V]) (*ShardedLLRU[K, V], error) {
+	shards := collectOptions(opts).shards
+	if shards == 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	return NewShardedWithHash[K, V](shards, size, nil, opts...)
+}
+
+// NewSharded constructs a cache of the given size split into `shards` shards, each holding up to `size / shards`
+// entries, rounded up, and each configured with the given options. A size of 0 makes every shard unbounded. Keys are
+// spread across shards by hashing them: strings, numbers and pointers are hashed directly, and other keys are hashed
+// from their printed representation, which is slower. Use NewShardedWithHash to hash keys some other way.
+// The callbacks set by the options are shared by every shard, and may be called concurrently by different shards.
+// WithShards is ignored, `shards` takes precedence
+func NewSharded[K comparable, V any](shards int, size int, opts ...Option[K, V]) (*ShardedLLRU[K, V], error) {
+	return NewShardedWithHash[K, V](shards, size, nil, opts...)
+}
+
+// NewShardedWithHash is the same as NewSharded, but spreads keys across shards with the given hash function, which must
+// return the same hash for equal keys. A `nil` hash uses the default one
+func NewShardedWithHash[K comparable, V any](shards int, size int, hash func(key K) uint64, opts ...Option[K, V]) (*ShardedLLRU[K, V], error) {
+	if shards <= 0 {
+		return nil, errors.New("must provide a positive number of shards")
+	}
+	if size < 0 {
+		return nil, errors.New("must not provide a negative size")
+	}
+	if hash == nil {
+		hash = newKeyHash[K]()
+	}
+	sharded := &ShardedLLRU[K, V]{
+		shards: make([]*LLRU[K, V], shards),
+		hash: hash,
+	}
+	options := collectOptions(opts)
+	shardSize := (size + shards - 1) / shards
+	for i := range sharded.shards {
+		shard, err := newUnsafeWithOptions(shardSize, options)
+		if err != nil {
+			return nil, err
+		}
+		sharded.shards[i] = newLLRU(shard)
+	}
+	return sharded, nil
+}
+
+//returns the default hash function, which hashes strings, numbers and pointers directly, and other keys from their
+//printed representation
+func newKeyHash[K comparable]() func(key K) uint64 {
+	seed := maphash.MakeSeed()
+	return func(key K) uint64 {
+		switch k := any(key).(type) {
+		case string:
+			return maphash.String(seed, k)
+		case int:
+			return mixHash(uint64(k))
+		case int8:
+			return mixHash(uint64(k))
+		case int16:
+			return mixHash(uint64(k))
+		case int32:
+			return mixHash(uint64(k))
+		case int64:
+			return mixHash(uint64(k))
+		case uint:
+			return mixHash(uint64(k))
+		case uint8:
+			return mixHash(uint64(k))
+		case uint16:
+			return mixHash(uint64(k))
+		case uint32:
+			return mixHash(uint64(k))
+		case uint64:
+			return mixHash(k)
+		case uintptr:
+			return mixHash(uint64(k))
+		case float32:
+			return hashFloat(float64(k))
+		case float64:
+			return hashFloat(k)
+		case complex64:
+			return hashComplex(complex128(k))
+		case complex128:
+			return hashComplex(k)
+		}
+		value := reflect.ValueOf(key)
+		switch value.Kind() {
+		case reflect.Pointer, reflect.UnsafePointer, reflect.Chan:
+			return mixHash(uint64(value.Pointer())) //the pointed value may change, but not the address
+		case reflect.Float32, reflect.Float64: //named float types, which are printed like their value too
+			return hashFloat(value.Float())
+		case reflect.Complex64, reflect.Complex128:
+			return hashComplex(value.Complex())
+		}
+		return maphash.String(seed, fmt.Sprintf("%T:%v", key, key))
+	}
+}
+
+//hashes a float so that equal floats have the same hash: -0 is equal to 0 but has other bits. NaN is never equal to
+//itself, so a NaN key can never be found again anyway, and all NaNs get the same hash
+func hashFloat(f float64) uint64 {
+	if f == 0 {
+		f = 0
+	}
+	if math.IsNaN(f) {
+		f = math.NaN()
+	}
+	return mixHash(math.Float64bits(f))
+}
+
+//hashes a complex number from the hashes of its parts, see hashFloat
+func hashComplex(c complex128) uint64 {
+	return hashFloat(real(c)) ^ mixHash(hashFloat(imag(c)))
+}
+
+//spreads the bits of an integer, so that consecutive integers do not fall into consecutive shards (splitmix64)
+func mixHash(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Shard returns the shard a key belongs to, for instance to call the methods of LLRU which ShardedLLRU does not have.
+// The key must only be used with that shard
+func (sharded *ShardedLLRU[K, V]) Shard(key K) *LLRU[K, V] {
+	return sharded.shards[sharded.hash(key) % uint64(len(sharded.shards))]
+}
+
+// Shards returns every shard, for instance to configure them all at once
+func (sharded *ShardedLLRU[K, V]) Shards() []*LLRU[K, V] {
+	return append([]*LLRU[K, V](nil), sharded.shards...)
+}
+
+// AddOrUpdateUnlocked adds or updates an unlocked value in the shard of the key. See LLRU.AddOrUpdateUnlocked
+func (sharded *ShardedLLRU[K, V]) AddOrUpdateUnlocked(key K, value V) (ok bool, evicted *Entry[K, V]) {
+	return sharded.Shard(key).AddOrUpdateUnlocked(key, value)
+}
+
+// AddOrUpdateLocked adds or updates a locked value in the shard of the key. See LLRU.AddOrUpdateLocked
+func (sharded *ShardedLLRU[K, V]) AddOrUpdateLocked(key K, value V) (ok bool, evicted *Entry[K, V]) {
+	return sharded.Shard(key).AddOrUpdateLocked(key, value)
+}
+
+// AddOrUpdate adds or updates a value without changing the lock state of an existing entry. See LLRU.AddOrUpdate
+func (sharded *ShardedLLRU[K, V]) AddOrUpdate(key K, value V) (ok bool, evicted *Entry[K, V]) {
+	return sharded.Shard(key).AddOrUpdate(key, value)
+}
+
+// Get gets a value and updates its recency in its shard. See LLRU.Get
+func (sharded *ShardedLLRU[K, V]) Get(key K) (value *V) {
+	return sharded.Shard(key).Get(key)
+}
+
+// Get2 gets a value without returning a pointer to it. See LLRU.Get2
+func (sharded *ShardedLLRU[K, V]) Get2(key K) (value V, ok bool) {
+	return sharded.Shard(key).Get2(key)
+}
+
+// Peek returns the value of a key without changing its recency. See LLRU.Peek
+func (sharded *ShardedLLRU[K, V]) Peek(key K) (value V, ok bool) {
+	return sharded.Shard(key).Peek(key)
+}
+
+// Contains returns whether a key exists. See LLRU.Contains
+func (sharded *ShardedLLRU[K, V]) Contains(key K) bool {
+	return sharded.Shard(key).Contains(key)
+}
+
+// Lock locks an entry. See LLRU.Lock
+func (sharded *ShardedLLRU[K, V]) Lock(key K) (ok bool) {
+	return sharded.Shard(key).Lock(key)
+}
+
+// Unlock unlocks an entry. See LLRU.Unlock
+func (sharded *ShardedLLRU[K, V]) Unlock(key K) (ok bool) {
+	return sharded.Shard(key).Unlock(key)
+}
+
+// Remove removes an entry, whether it is locked or unlocked. See LLRU.Remove
+func (sharded *ShardedLLRU[K, V]) Remove(key K) (present bool, wasLocked bool) {
+	return sharded.Shard(key).Remove(key)
+}
+
+// Purge removes every entry of every shard, locked ones included, and returns the number of entries removed
+func (sharded *ShardedLLRU[K, V]) Purge() int {
+	purged := 0
+	for _, shard := range sharded.shards {
+		purged += shard.Purge()
+	}
+	return purged
+}
+
+// Len returns the number of entries of every shard, locked ones included. Shards are counted one after the other, so
+// the total may include changes made while counting
+func (sharded *ShardedLLRU[K, V]) Len() int {
+	length := 0
+	for _, shard := range sharded.shards {
+		length += shard.Len()
+	}
+	return length
+}
+
+// Keys returns the keys of every shard, shard after shard, each in the order returned by LLRU.Keys
+func (sharded *ShardedLLRU[K, V]) Keys() []K {
+	var keys []K
+	for _, shard := range sharded.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+// Values returns the values of every shard, shard after shard, each in the order returned by LLRU.Values
+func (sharded *ShardedLLRU[K, V]) Values() []V {
+	var values []V
+	for _, shard := range sharded.shards {
+		values = append(values, shard.Values()...)
+	}
+	return values
+}
+
+// Entries returns the entries of every shard, shard after shard, each in the order returned by LLRU.Entries
+func (sharded *ShardedLLRU[K, V]) Entries() []Entry[K, V] {
+	var entries []Entry[K, V]
+	for _, shard := range sharded.shards {
+		entries = append(entries, shard.Entries()...)
+	}
+	return entries
+}
+
+// Stats returns the total size and lengths of the shards, and the lengths of the emptiest and fullest shards
+func (sharded *ShardedLLRU[K, V]) Stats() ShardedStats {
+	stats := ShardedStats{Shards: len(sharded.shards)}
+	for i, shard := range sharded.shards {
+		size, lockedLen, unlockedLen, _ := shard.Room()
+		length := lockedLen + unlockedLen
+		stats.Size += size
+		stats.Len += length
+		stats.LockedLen += lockedLen
+		stats.UnlockedLen += unlockedLen
+		if i == 0 || length < stats.MinShardLen {
+			stats.MinShardLen = length
+		}
+		if i == 0 || length > stats.MaxShardLen {
+			stats.MaxShardLen = length
+		}
+	}
+	return stats
+}
+
+// Close stops the goroutines started on every shard. See LLRU.Close
+func (sharded *ShardedLLRU[K, V]) Close() {
+	for _, shard := range sharded.shards {
+		shard.Close()
+	}
+}
@@ -0,0 +1,165 @@
+package lockable_lru
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestSharded(t *testing.T) {
+	sharded, err := NewSharded[string, int](4, 80)
+	if err != nil {
+		t.Fatalf("could not create sharded llru: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		_, _ = sharded.AddOrUpdateUnlocked(fmt.Sprint(i), i)
+	}
+	_ = sharded.Lock("3")
+
+	for i := 0; i < 20; i++ {
+		if value, ok := sharded.Get2(fmt.Sprint(i)); !ok || value != i {
+			t.Errorf("expected %v but got %v, %v", i, value, ok)
+		}
+	}
+	if locked, _ := sharded.Shard("3").IsLocked("3"); !locked {
+		t.Errorf("expected `3` to be locked in its shard")
+	}
+
+	values := sharded.Values()
+	slices.Sort(values)
+	if len(values) != 20 || values[0] != 0 || values[19] != 19 {
+		t.Errorf("expected the values 0 to 19 but got %v", values)
+	}
+
+	stats := sharded.Stats()
+	if stats.Shards != 4 || stats.Size != 80 || stats.Len != 20 || stats.LockedLen != 1 || stats.UnlockedLen != 19 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.MinShardLen > stats.MaxShardLen || stats.MaxShardLen > 20 {
+		t.Errorf("unexpected shard lengths %+v", stats)
+	}
+
+	if purged := sharded.Purge(); purged != 20 || sharded.Len() != 0 {
+		t.Errorf("expected 20 entries to be purged but got %v, leaving %v", purged, sharded.Len())
+	}
+}
+
+func TestNewShardedWithOptions(t *testing.T) {
+	var evicted []string
+	var mutex sync.Mutex
+	onEvicted := func(key string, value int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		evicted = append(evicted, key)
+	}
+	sharded, err := NewShardedWithOptions[string, int](8, WithShards[string, int](4), WithEvict(onEvicted))
+	if err != nil {
+		t.Fatalf("could not create sharded llru: %v", err)
+	}
+	if stats := sharded.Stats(); stats.Shards != 4 || stats.Size != 8 {
+		t.Errorf("expected 4 shards of size 2 but got %+v", stats)
+	}
+	for i := 0; i < 20; i++ {
+		_, _ = sharded.AddOrUpdateUnlocked(fmt.Sprint(i), i)
+	}
+	if length := sharded.Len(); length > 8 || len(evicted) != 20-length {
+		t.Errorf("expected at most 8 entries and the others evicted but got %v and %v", length, evicted)
+	}
+
+	sharded, err = NewShardedWithOptions[string, int](0)
+	if err != nil || len(sharded.Shards()) != runtime.GOMAXPROCS(0) {
+		t.Errorf("expected as many shards as GOMAXPROCS but got %v, %v", len(sharded.Shards()), err)
+	}
+	if _, err := NewWithOptions[string, int](8, WithShards[string, int](4)); err == nil {
+		t.Errorf("expected an error for WithShards without sharding")
+	}
+}
+
+func TestShardedDefaultHash(t *testing.T) {
+	type point struct{ x, y int }
+	hash := newKeyHash[any]()
+
+	value := 1
+	keys := []any{"key", 42, uint8(7), point{1, 2}, &value}
+	for _, key := range keys {
+		if hash(key) != hash(key) {
+			t.Errorf("expected the hash of %v to be stable", key)
+		}
+	}
+	if hash(point{1, 2}) != hash(point{1, 2}) {
+		t.Errorf("expected equal keys to have the same hash")
+	}
+	before := hash(&value)
+	value = 2
+	if hash(&value) != before {
+		t.Errorf("expected the hash of a pointer not to depend on the pointed value")
+	}
+}
+
+// Equal floats have the same hash, even though -0 and 0 have different bits
+func TestShardedFloatHash(t *testing.T) {
+	type celsius float64
+	negativeZero := math.Copysign(0, -1)
+
+	floats := newKeyHash[float64]()
+	if floats(negativeZero) != floats(0) {
+		t.Errorf("expected -0 and 0 to have the same hash")
+	}
+	if floats(math.NaN()) != floats(-math.NaN()) {
+		t.Errorf("expected every NaN to have the same hash")
+	}
+	if floats(1) == floats(2) {
+		t.Errorf("expected different floats to have different hashes")
+	}
+
+	complexes := newKeyHash[complex128]()
+	if complexes(complex(negativeZero, 1)) != complexes(complex(0, 1)) || complexes(complex(1, negativeZero)) != complexes(complex(1, 0)) {
+		t.Errorf("expected complex numbers with -0 and 0 parts to have the same hash")
+	}
+	if complexes(complex(1, 2)) == complexes(complex(2, 1)) {
+		t.Errorf("expected complex numbers with swapped parts to have different hashes")
+	}
+
+	named := newKeyHash[celsius]()
+	if named(celsius(negativeZero)) != named(0) {
+		t.Errorf("expected -0 and 0 of a named float type to have the same hash")
+	}
+
+	sharded, err := NewSharded[float64, string](8, 0)
+	if err != nil {
+		t.Fatalf("could not create sharded llru: %v", err)
+	}
+	_, _ = sharded.AddOrUpdateUnlocked(0, "zero")
+	if value, ok := sharded.Get2(negativeZero); !ok || value != "zero" {
+		t.Errorf("expected `zero, true` but got %v, %v", value, ok)
+	}
+}
+
+func TestShardedConcurrently(t *testing.T) {
+	sharded, err := NewSharded[int, int](8, 0)
+	if err != nil {
+		t.Fatalf("could not create sharded llru: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := g*100 + i
+				_, _ = sharded.AddOrUpdateUnlocked(key, key)
+				_, _ = sharded.Get2(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if length := sharded.Len(); length != 800 {
+		t.Errorf("expected 800 entries but got %v", length)
+	}
+}
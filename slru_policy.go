@@ -0,0 +1,51 @@
+package lockable_lru
+
+/*
+ * Segmented LRU policy for the unlocked entries
+ *
+ * New entries are added to the probationary segment and move to the protected segment when they are used again. When
+ * the protected segment grows beyond its share of the size, its oldest entries are demoted back to the probationary
+ * segment, keeping their recency. Entries are evicted from the probationary segment as long as it has any.
+ *
+ */
+
+const (
+	slruProbationary = 0
+	slruProtected    = 1
+)
+
+type slruPolicy[K comparable, V any] struct {
+	protectedRatio float64 //ratio of the size dedicated to the protected segment
+}
+
+func (s slruPolicy[K, V]) segmentCount() int {
+	return 2
+}
+
+func (s slruPolicy[K, V]) onAdd(c *unlockedLRU[K, V], key K) int {
+	return slruProbationary
+}
+
+func (s slruPolicy[K, V]) onHit(c *unlockedLRU[K, V], key K, segment int) int {
+	return slruProtected
+}
+
+func (s slruPolicy[K, V]) rebalance(c *unlockedLRU[K, V]) {
+	protected := c.segments[slruProtected]
+	for protected.Len() > c.share(s.protectedRatio) {
+		c.moveToSegment(protected.Oldest().Key, slruProbationary)
+	}
+}
+
+func (s slruPolicy[K, V]) victimSegment(c *unlockedLRU[K, V]) int {
+	if c.segments[slruProbationary].Len() > 0 {
+		return slruProbationary
+	}
+	return slruProtected
+}
+
+func (s slruPolicy[K, V]) onEvicted(c *unlockedLRU[K, V], key K, segment int) {}
+
+func (s slruPolicy[K, V]) clone() segmentPolicy[K, V] {
+	return s
+}
@@ -0,0 +1,74 @@
+package lockable_lru
+
+/*
+ * A snapshot is a copy of the entries of an LLRU or ThreadunsafeLLRU, with their lock states, taken at a point in time.
+ *
+ * It is never changed once taken, so it can be read from any number of goroutines, and iterated for as long as needed,
+ * without holding the cache lock, while the cache keeps changing. Values are copied as is, so values holding pointers
+ * still share what they point to with the cache.
+ *
+ */
+import (
+	"context"
+)
+
+type Snapshot[K comparable, V any] struct {
+	entries []Entry[K, V] //every entry, in the same order as Entries
+	locked  []bool        //whether each entry was locked
+}
+
+//returns a snapshot of the entries of the cache
+func newSnapshot[K comparable, V any](llru *ThreadunsafeLLRU[K, V]) *Snapshot[K, V] {
+	snapshot := &Snapshot[K, V]{
+		entries: make([]Entry[K, V], 0, llru.locked.Len()+llru.unlocked.Len()),
+		locked:  make([]bool, 0, llru.locked.Len()+llru.unlocked.Len()),
+	}
+	llru.rangeEntries(false, func(key K, value V, locked bool) bool {
+		snapshot.entries = append(snapshot.entries, Entry[K, V]{Key: key, Value: value})
+		snapshot.locked = append(snapshot.locked, locked)
+		return true
+	})
+	return snapshot
+}
+
+// Returns the number of entries in the snapshot
+func (snapshot *Snapshot[K, V]) Len() int {
+	return len(snapshot.entries)
+}
+
+// Returns the entry at position `i`, in the same order as Entries, and whether it was locked
+func (snapshot *Snapshot[K, V]) At(i int) (entry Entry[K, V], locked bool) {
+	return snapshot.entries[i], snapshot.locked[i]
+}
+
+// Returns an array of every entry, starting with unlocked from oldest to newest, then locked
+func (snapshot *Snapshot[K, V]) Entries() []Entry[K, V] {
+	return append([]Entry[K, V](nil), snapshot.entries...)
+}
+
+// Range calls `fn` with every entry, in the same order as Entries, until it returns false
+func (snapshot *Snapshot[K, V]) Range(fn func(key K, value V, locked bool) bool) {
+	for i, entry := range snapshot.entries {
+		if !fn(entry.Key, entry.Value, snapshot.locked[i]) {
+			return
+		}
+	}
+}
+
+// Stream returns a channel which receives every entry, in the same order as Entries, from a goroutine, for instance to
+// pipe the entries into an exporter. The channel is closed once every entry was sent, or once `ctx` is done, in which
+// case the remaining entries are not sent
+func (snapshot *Snapshot[K, V]) Stream(ctx context.Context) <-chan Entry[K, V] {
+	entries := make(chan Entry[K, V])
+	go func() {
+		defer close(entries)
+		for _, entry := range snapshot.entries {
+			select {
+			case entries <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return entries
+}
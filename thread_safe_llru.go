@@ -3,41 +3,136 @@ package lockable_lru
 /*
  * A thread-safe LRU implementation with items that can be "locked"
  *
- * Based on hashicorp/golang-lru
+ * The API is modelled on hashicorp/golang-lru, but the cache no longer depends on it: LLRU wraps a ThreadunsafeLLRU (see
+ * thread_unsafe_llru.go), whose unlocked items are kept in unlockedLRU (see unlocked_lru.go), and guards it with a
+ * single RWMutex. Read-only methods share the read lock, unless expired items must be released first.
  *
- * A locked item cannot be evicted until it is unlocked. When it is unlocked, it is moved to the most recent.
+ * A locked item cannot be evicted until it is unlocked. When it is unlocked, it is moved to the most recent, or back to
+ * its position before it was locked if SetKeepPositionOnUnlock is enabled.
  *
  */
 import (
+	"context"
+	"errors"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type LLRU[K comparable, V any] struct {
 	tullru ThreadunsafeLLRU[K, V]
+	changed chan struct{} //closed and replaced whenever an entry is added, unlocked or removed, or the room for locked entries changes, to wake up callers waiting in LockCtx
+	computing map[K]*computation[V] //computations in progress in GetOrCompute, by key
+	stopJanitors []func() //stops the goroutines started with StartJanitor and StartMemoryController, when Close is called
+	order uint64 //order in which caches are locked when two of them must be locked at once, see MoveTo
 	lock sync.RWMutex //even though the underlying structures are threadsafe, we need to lock if we have to do 2 or more operations - which means we have to lock for every operation, otherwise we could deadlock if one call has locked the outer lock but is waiting on the inner lock, and another call has not locked the outer but has locked the inner
 }
 
+//number of LLRUs created, giving each one its order
+var llruCount atomic.Uint64
+
 // New creates an LRU of the given size.
 func New[K comparable, V any](size int) (*LLRU[K, V], error) {
-	tullru, err := NewUnsafe[K, V](size)
-	if err != nil {
-		return nil, err
-	}
-	return &LLRU[K, V]{
-		tullru: *tullru,
-	}, nil
+	return NewWithEvict[K, V](size, nil)
 }
 
 // NewWithEvict constructs a fixed size cache with the given eviction
 // callback.
 func NewWithEvict[K comparable, V any](size int, onEvicted func(key K, value V)) (*LLRU[K, V], error) {
-	tullru, err := NewUnsafeWithEvict(size, onEvicted)
+	return NewWithPolicy[K, V](size, LRUPolicy(), onEvicted)
+}
+
+// NewWithPolicy constructs a fixed size cache whose unlocked entries are evicted according to the given policy, with
+// the given eviction callback, which may be nil. A size of 0 makes the cache unbounded, see NewUnsafeWithPolicy.
+func NewWithPolicy[K comparable, V any](size int, policy Policy, onEvicted func(key K, value V)) (*LLRU[K, V], error) {
+	tullru, err := NewUnsafeWithPolicy[K, V](size, policy, onEvicted)
+	if err != nil {
+		return nil, err
+	}
+	return newLLRU(tullru), nil
+}
+
+// NewWithOptions constructs a fixed size cache configured with the given options. See NewUnsafeWithOptions
+func NewWithOptions[K comparable, V any](size int, opts ...Option[K, V]) (*LLRU[K, V], error) {
+	tullru, err := NewUnsafeWithOptions[K, V](size, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return &LLRU[K, V]{
+	return newLLRU(tullru), nil
+}
+
+//wraps a thread-unsafe cache, which must no longer be used directly
+func newLLRU[K comparable, V any](tullru *ThreadunsafeLLRU[K, V]) *LLRU[K, V] {
+	llru := &LLRU[K, V]{
 		tullru: *tullru,
-	}, nil
+		changed: make(chan struct{}),
+		computing: make(map[K]*computation[V]),
+		order: llruCount.Add(1),
+	}
+	llru.tullru.unlocked.onEvict = llru.tullru.evicted //bind the callbacks to the copy, so that setting them on the LLRU takes effect
+	llru.tullru.onChanged = llru.notifyWaiters
+	return llru
+}
+
+//takes the read lock for a method which does not change the cache, and returns `true`. Every method first releases the
+//expired locks and entries, which does change the cache, so if there are any, the read lock is not kept and `false` is
+//returned, and the method must take the write lock instead
+func (llru *LLRU[K, V]) rlock() bool {
+	llru.lock.RLock()
+	if !llru.tullru.hasExpired() {
+		return true
+	}
+	llru.lock.RUnlock()
+	return false
+}
+
+// Clone returns an independent copy of the cache, with the same entries, order and lock states. See
+// ThreadunsafeLLRU.Clone
+// `copyValue` is called while holding the cache lock, so it must not call methods of the LLRU. Goroutines started with
+// StartJanitor and StartMemoryController are not started for the copy
+func (llru *LLRU[K, V]) Clone(copyValue func(value V) V) *LLRU[K, V] {
+	llru.lock.Lock()
+	tullru := llru.tullru.Clone(copyValue)
+	llru.lock.Unlock()
+
+	return newLLRU(tullru)
+}
+
+// Merge imports every entry of `other` into the cache. See ThreadunsafeLLRU.Merge
+// The entries of `other` are read while holding its lock, then imported while holding the lock of the cache, never both
+// at once, so that merging two caches into each other concurrently cannot deadlock
+func (llru *LLRU[K, V]) Merge(other *LLRU[K, V], conflict MergeConflict) (merged int, failed []K) {
+	if other == llru {
+		return 0, nil
+	}
+	other.lock.Lock()
+	candidates := other.tullru.mergeCandidates()
+	other.lock.Unlock()
+
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.merge(candidates, conflict)
+}
+
+// MoveTo moves an entry to `other`, with its value and lock state, without calling the eviction callbacks. See
+// ThreadunsafeLLRU.MoveTo
+// Both caches are locked while the entry is moved, always in the same order, so that moving entries between two caches
+// in both directions concurrently cannot deadlock
+func (llru *LLRU[K, V]) MoveTo(other *LLRU[K, V], key K) (ok bool) {
+	if other == llru {
+		return false
+	}
+	first, second := llru, other
+	if second.order < first.order {
+		first, second = second, first
+	}
+	first.lock.Lock()
+	defer first.lock.Unlock()
+	second.lock.Lock()
+	defer second.lock.Unlock()
+
+	return llru.tullru.MoveTo(&other.tullru, key)
 }
 
 // Add adds an unlocked value to the cache.
@@ -49,6 +144,14 @@ func (llru *LLRU[K, V]) AddOrUpdateUnlocked(key K, value V) (ok bool, evicted *E
 	return llru.tullru.AddOrUpdateUnlocked(key, value)
 }
 
+// AddOrUpdateUnlockedWithPriority adds or updates an unlocked value and sets its eviction priority.
+// See ThreadunsafeLLRU.AddOrUpdateUnlockedWithPriority
+func (llru *LLRU[K, V]) AddOrUpdateUnlockedWithPriority(key K, value V, priority int) (ok bool, evicted *Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.AddOrUpdateUnlockedWithPriority(key, value, priority)
+}
+
 // Add adds a locked value to the cache.
 // If the value exists, it is updated. If it existed and was unlocked, it is locked.
 // Returns `false, nil` if there was no room, otherwise returns true and the evicted entry, if any
@@ -58,66 +161,1241 @@ func (llru *LLRU[K, V]) AddOrUpdateLocked(key K, value V) (ok bool, evicted *Ent
 	return llru.tullru.AddOrUpdateLocked(key, value)
 }
 
-func (llru *LLRU[K, V]) Lock(key K) (ok bool) {
+// AddWithFinalizer adds or updates a value and sets a finalizer which is called once that value leaves the cache. See
+// ThreadunsafeLLRU.AddWithFinalizer
+// The finalizer is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) AddWithFinalizer(key K, value V, locked bool, finalizer func(key K, value V)) (ok bool, evicted *Entry[K, V]) {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
-	return llru.tullru.Lock(key)
+	return llru.tullru.AddWithFinalizer(key, value, locked, finalizer)
 }
 
-func (llru *LLRU[K, V]) Unlock(key K) (ok bool) {
+// AddOrUpdateUnlockedWithTTL adds or updates an unlocked value which expires once `ttl` has elapsed. See
+// ThreadunsafeLLRU.AddOrUpdateUnlockedWithTTL
+func (llru *LLRU[K, V]) AddOrUpdateUnlockedWithTTL(key K, value V, ttl time.Duration) (ok bool, evicted *Entry[K, V]) {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
-	return llru.tullru.Unlock(key)
+	return llru.tullru.AddOrUpdateUnlockedWithTTL(key, value, ttl)
 }
 
-func (llru *LLRU[K, V]) Get(key K) (value *V) {
+// ResetTTL restarts the lifetime of an entry with a new `ttl`. See ThreadunsafeLLRU.ResetTTL
+func (llru *LLRU[K, V]) ResetTTL(key K, ttl time.Duration) (ok bool) {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
-	return llru.tullru.Get(key)
+	return llru.tullru.ResetTTL(key, ttl)
 }
 
-func (llru *LLRU[K, V]) Contains(key K) bool {
+// ExtendTTL postpones the expiration of an entry. See ThreadunsafeLLRU.ExtendTTL
+func (llru *LLRU[K, V]) ExtendTTL(key K, extension time.Duration) (ok bool) {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
-	return llru.tullru.Contains(key)
+	return llru.tullru.ExtendTTL(key, extension)
 }
 
-func (llru *LLRU[K, V]) Len() int {
+// RemoveExpired removes every expired entry and returns them. See ThreadunsafeLLRU.RemoveExpired
+func (llru *LLRU[K, V]) RemoveExpired() []Entry[K, V] {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
-	return llru.tullru.Len()
+	return llru.tullru.RemoveExpired()
 }
 
-func (llru *LLRU[K, V]) Entries() []Entry[K,V] {
+// SetOnExpired sets a callback which is called whenever an entry expires, instead of the eviction callbacks. See
+// ThreadunsafeLLRU.SetOnExpired
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnExpired(onExpired func(key K, value V)) {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
-	return llru.tullru.Entries()
+	llru.tullru.SetOnExpired(onExpired)
 }
 
-func (llru *LLRU[K, V]) Keys() []K {
+// SetExpireLocked sets whether locked entries expire. See ThreadunsafeLLRU.SetExpireLocked
+func (llru *LLRU[K, V]) SetExpireLocked(enabled bool) {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
-	return llru.tullru.Keys()
+	llru.tullru.SetExpireLocked(enabled)
 }
 
-func (llru *LLRU[K, V]) Values() []V {
+// SetTTLJitter makes every lifetime randomly shorter or longer by up to `jitter` times its duration. See
+// ThreadunsafeLLRU.SetTTLJitter
+func (llru *LLRU[K, V]) SetTTLJitter(jitter float64) {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
-	return llru.tullru.Values()
+	llru.tullru.SetTTLJitter(jitter)
 }
 
-func (llru *LLRU[K, V]) RemoveOldest() *Entry[K, V] {
+// SetExpireAfterAccess makes the lifetime of entries restart whenever they are read. See
+// ThreadunsafeLLRU.SetExpireAfterAccess
+func (llru *LLRU[K, V]) SetExpireAfterAccess(enabled bool) {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
-	return llru.tullru.RemoveOldest()
+	llru.tullru.SetExpireAfterAccess(enabled)
+}
+
+// SetWeigher limits the total cost of the entries. See ThreadunsafeLLRU.SetWeigher
+// The weigher is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetWeigher(weigher func(key K, value V) int, maxWeight int) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetWeigher(weigher, maxWeight)
+}
+
+// Reserve keeps room for `n` locked entries to be added. See ThreadunsafeLLRU.Reserve
+func (llru *LLRU[K, V]) Reserve(n int) (evicted []Entry[K, V], err error) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Reserve(n)
+}
+
+// Release cancels every reservation taken with Reserve. See ThreadunsafeLLRU.Release
+func (llru *LLRU[K, V]) Release() {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.Release()
+}
+
+// SetCapacityPolicy sets a callback which decides what to do when an add would go over the size of the cache. See
+// ThreadunsafeLLRU.SetCapacityPolicy
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetCapacityPolicy(capacityPolicy func(lockedLen, unlockedLen, requested int) Decision) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetCapacityPolicy(capacityPolicy)
+}
+
+// SetWatermarks makes adding entries evict unlocked entries in batches. See ThreadunsafeLLRU.SetWatermarks
+func (llru *LLRU[K, V]) SetWatermarks(low, high int) error {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.SetWatermarks(low, high)
+}
+
+// Weight returns the total cost of the entries. See ThreadunsafeLLRU.Weight
+func (llru *LLRU[K, V]) Weight() int {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.totalWeight
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Weight()
+}
+
+// LenBytes returns the total length in bytes of the string and []byte values. See ThreadunsafeLLRU.LenBytes
+func (llru *LLRU[K, V]) LenBytes() int {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.lenBytes()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.LenBytes()
+}
+
+// SetClock sets the source of the current time. See ThreadunsafeLLRU.SetClock
+// The janitor started with StartJanitor still runs on the actual time
+// The clock may be called by several goroutines at once, as read-only methods such as Peek only take the read lock
+func (llru *LLRU[K, V]) SetClock(clock Clock) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetClock(clock)
+}
+
+// SetDefaultTTL sets the lifetime of entries added or updated afterwards. See ThreadunsafeLLRU.SetDefaultTTL
+func (llru *LLRU[K, V]) SetDefaultTTL(ttl time.Duration) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetDefaultTTL(ttl)
+}
+
+// AddOrUpdateLockedAll is the same as AddOrUpdateLocked, but returns every evicted entry instead of only the first one.
+// See ThreadunsafeLLRU.AddOrUpdateLockedAll
+func (llru *LLRU[K, V]) AddOrUpdateLockedAll(key K, value V) (ok bool, evicted []Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.AddOrUpdateLockedAll(key, value)
+}
+
+// AddUnlockedIfAbsent adds an unlocked value only if the key does not exist. See ThreadunsafeLLRU.AddUnlockedIfAbsent
+func (llru *LLRU[K, V]) AddUnlockedIfAbsent(key K, value V) (current *V, added bool, evicted *Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.AddUnlockedIfAbsent(key, value)
+}
+
+// AddMany adds or updates each of the given entries as an unlocked value while holding the cache lock once. See
+// ThreadunsafeLLRU.AddMany
+func (llru *LLRU[K, V]) AddMany(entries []Entry[K, V]) (ok []bool, evicted []*Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.AddMany(entries)
+}
+
+// ContainsOrAdd checks whether a key exists and adds it if it does not. See ThreadunsafeLLRU.ContainsOrAdd
+func (llru *LLRU[K, V]) ContainsOrAdd(key K, value V, locked bool) (contained bool, evicted *Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.ContainsOrAdd(key, value, locked)
+}
+
+// PeekOrAdd returns the value of a key and adds it if it does not exist. See ThreadunsafeLLRU.PeekOrAdd
+func (llru *LLRU[K, V]) PeekOrAdd(key K, value V, locked bool) (previous V, ok bool, evicted *Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.PeekOrAdd(key, value, locked)
+}
+
+// GetOrAdd gets the value of a key, or adds it as an unlocked value if the key does not exist, atomically. See
+// ThreadunsafeLLRU.GetOrAdd
+func (llru *LLRU[K, V]) GetOrAdd(key K, value V) (actual V, loaded bool, evicted *Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.GetOrAdd(key, value)
+}
+
+//computation of a value by GetOrCompute, shared by every caller asking for the same key while it is in progress
+type computation[V any] struct {
+	done chan struct{} //closed once value and err are set
+	value V
+	err error
+}
+
+// GetOrCompute gets the value of a key, or computes it with `compute` and adds it as an unlocked value if the key does
+// not exist. See ThreadunsafeLLRU.GetOrCompute
+// `compute` is called without holding the cache lock, so it may call methods of the LLRU. While it runs, other callers
+// of GetOrCompute for the same key wait for it and get its result, instead of computing the value again, so it is called
+// only once however many callers miss at the same time. If it panics, the panic is propagated to its caller, and the
+// waiting callers get ErrComputePanicked
+func (llru *LLRU[K, V]) GetOrCompute(key K, compute func() (V, error)) (value V, err error) {
+	llru.lock.Lock()
+	value, ok := llru.tullru.Get2(key)
+	if ok {
+		llru.lock.Unlock()
+		return value, nil
+	}
+	if inProgress, ok := llru.computing[key]; ok {
+		llru.lock.Unlock()
+		<-inProgress.done
+		return inProgress.value, inProgress.err
+	}
+	c := &computation[V]{done: make(chan struct{})}
+	llru.computing[key] = c
+	llru.lock.Unlock()
+
+	panicked := true
+	defer func() {
+		llru.lock.Lock()
+		delete(llru.computing, key)
+		if panicked {
+			c.err = ErrComputePanicked
+		} else if c.err == nil {
+			current, _, _ := llru.tullru.AddUnlockedIfAbsent(key, c.value)
+			if current != nil {
+				c.value = *current
+			}
+		}
+		llru.lock.Unlock()
+		close(c.done)
+		value, err = c.value, c.err
+	}()
+
+	c.value, c.err = compute()
+	if c.err != nil {
+		var zero V
+		c.value = zero
+	}
+	panicked = false
+	return c.value, c.err
+}
+
+// Update changes the value of a key based on its current value, atomically. See ThreadunsafeLLRU.Update
+// `fn` is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) Update(key K, fn func(old V, exists bool) (V, bool)) (ok bool, evicted *Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Update(key, fn)
+}
+
+// AddOrUpdate adds or updates a value without changing the lock state of an existing entry. See
+// ThreadunsafeLLRU.AddOrUpdate
+func (llru *LLRU[K, V]) AddOrUpdate(key K, value V) (ok bool, evicted *Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.AddOrUpdate(key, value)
+}
+
+// AddLockedIfAbsent adds a locked value only if the key does not exist. See ThreadunsafeLLRU.AddLockedIfAbsent
+func (llru *LLRU[K, V]) AddLockedIfAbsent(key K, value V) (current *V, added bool, evicted *Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.AddLockedIfAbsent(key, value)
+}
+
+// Do calls `fn` with the underlying ThreadunsafeLLRU while holding the cache lock, so that several operations can be
+// composed atomically, for instance to check an entry, lock it and replace another one without any other call changing
+// the cache in between. Entries evicted by the operations of `fn` are passed together to the batch eviction callback.
+// `fn` must not call methods of the LLRU, nor keep the ThreadunsafeLLRU once it returns
+func (llru *LLRU[K, V]) Do(fn func(tullru *ThreadunsafeLLRU[K, V])) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	defer llru.tullru.startBatch()()
+
+	fn(&llru.tullru)
+}
+
+//wakes up every caller waiting in LockCtx. Must be called while holding the lock
+func (llru *LLRU[K, V]) notifyWaiters() {
+	close(llru.changed)
+	llru.changed = make(chan struct{})
+}
+
+// SetForbidImplicitUnlock chooses whether AddOrUpdateUnlocked may unlock entries. See ThreadunsafeLLRU.SetForbidImplicitUnlock
+func (llru *LLRU[K, V]) SetForbidImplicitUnlock(enabled bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetForbidImplicitUnlock(enabled)
+}
+
+// SetLockCounting chooses between counted and boolean locks. See ThreadunsafeLLRU.SetLockCounting
+func (llru *LLRU[K, V]) SetLockCounting(enabled bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetLockCounting(enabled)
+}
+
+// SetKeepPositionOnUnlock chooses where unlocked entries go. See ThreadunsafeLLRU.SetKeepPositionOnUnlock
+func (llru *LLRU[K, V]) SetKeepPositionOnUnlock(enabled bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetKeepPositionOnUnlock(enabled)
+}
+
+// SetMaxLocked limits the number of entries which can be locked at the same time. See ThreadunsafeLLRU.SetMaxLocked
+func (llru *LLRU[K, V]) SetMaxLocked(maxLocked int) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetMaxLocked(maxLocked)
+}
+
+// SetUnlimitedLocked chooses whether locked entries count against the size of the cache. See
+// ThreadunsafeLLRU.SetUnlimitedLocked
+func (llru *LLRU[K, V]) SetUnlimitedLocked(enabled bool) []Entry[K, V] {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.SetUnlimitedLocked(enabled)
+}
+
+// SetSizeBounds sets the sizes between which ApplyMemoryPressure resizes the cache. See ThreadunsafeLLRU.SetSizeBounds
+func (llru *LLRU[K, V]) SetSizeBounds(minSize, maxSize int) error {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.SetSizeBounds(minSize, maxSize)
+}
+
+// ApplyMemoryPressure resizes the cache between its size bounds according to `pressure`. See
+// ThreadunsafeLLRU.ApplyMemoryPressure
+func (llru *LLRU[K, V]) ApplyMemoryPressure(pressure float64) (evicted []Entry[K, V], err error) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.ApplyMemoryPressure(pressure)
+}
+
+// SetPinOnGet chooses whether Get locks the entries it returns. See ThreadunsafeLLRU.SetPinOnGet
+func (llru *LLRU[K, V]) SetPinOnGet(enabled bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetPinOnGet(enabled)
+}
+
+// SetMetrics sets a sink which the cache reports to. See ThreadunsafeLLRU.SetMetrics
+// The sink is called while holding the cache lock, so it must not call methods of the LLRU. Latencies do not include
+// the time spent waiting for the lock
+func (llru *LLRU[K, V]) SetMetrics(metrics MetricsSink) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetMetrics(metrics)
+}
+
+// SetEvictCallback sets the callback which is called whenever an entry is evicted or removed. See
+// ThreadunsafeLLRU.SetEvictCallback
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetEvictCallback(onEvicted func(key K, value V)) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetEvictCallback(onEvicted)
+}
+
+// Hooks returns every callback of the cache. See ThreadunsafeLLRU.Hooks
+func (llru *LLRU[K, V]) Hooks() Hooks[K, V] {
+	llru.lock.RLock()
+	defer llru.lock.RUnlock()
+	return llru.tullru.Hooks()
+}
+
+// SetHooks replaces every callback of the cache at once. See ThreadunsafeLLRU.SetHooks
+// The callbacks are called while holding the cache lock, so they must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetHooks(hooks Hooks[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetHooks(hooks)
+}
+
+// SetOnEvictedWithReason sets a callback which is called whenever an entry is evicted or removed, along with the reason.
+// See ThreadunsafeLLRU.SetOnEvictedWithReason
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnEvictedWithReason(onEvicted func(key K, value V, reason EvictionReason)) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnEvictedWithReason(onEvicted)
+}
+
+// SetOnEvictedWithHistory sets a callback which is called whenever an entry is evicted or removed, along with the
+// reason and its lock history. See ThreadunsafeLLRU.SetOnEvictedWithHistory
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnEvictedWithHistory(onEvicted func(key K, value V, reason EvictionReason, history LockHistory)) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnEvictedWithHistory(onEvicted)
+}
+
+// SetOnEvictedBatch sets a callback which is called once per operation with every entry it evicted or removed. See
+// ThreadunsafeLLRU.SetOnEvictedBatch
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnEvictedBatch(onEvictedBatch func(entries []Entry[K, V])) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnEvictedBatch(onEvictedBatch)
+}
+
+// SetVictimScore sets a function choosing which unlocked entry is evicted. See ThreadunsafeLLRU.SetVictimScore
+// The function is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetVictimScore(score func(key K, value V, age time.Duration) float64) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetVictimScore(score)
+}
+
+// SetMinResidency sets a grace period during which newly added entries are only evicted if there are no other
+// candidates. See ThreadunsafeLLRU.SetMinResidency
+func (llru *LLRU[K, V]) SetMinResidency(minResidency time.Duration) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetMinResidency(minResidency)
+}
+
+// SetOverflowHandler sets a second cache tier for evicted entries and misses. See ThreadunsafeLLRU.SetOverflowHandler
+// The handler is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOverflowHandler(handler OverflowHandler[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOverflowHandler(handler)
+}
+
+// Evictions returns a channel which receives every entry evicted or removed from now on. By default, entries are dropped
+// when its buffer is full, see SetEvictionsOverflow. See ThreadunsafeLLRU.Evictions
+func (llru *LLRU[K, V]) Evictions() <-chan Entry[K, V] {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Evictions()
+}
+
+// SetEvictionsOverflow tells what happens to an entry sent to the Evictions channel when its buffer is full. See
+// ThreadunsafeLLRU.SetEvictionsOverflow
+// With EventsBlock, entries are sent while holding the cache lock, so the receiver must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetEvictionsOverflow(overflow EventsOverflow) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetEvictionsOverflow(overflow)
+}
+
+// DroppedEvictions returns the number of entries dropped because the Evictions channel was full
+func (llru *LLRU[K, V]) DroppedEvictions() uint64 {
+	llru.lock.RLock()
+	defer llru.lock.RUnlock()
+	return llru.tullru.DroppedEvictions()
+}
+
+// SetOnAutoUnlock sets a callback which is called whenever a timed lock expires. See ThreadunsafeLLRU.SetOnAutoUnlock
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnAutoUnlock(onAutoUnlock func(key K, value V)) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnAutoUnlock(onAutoUnlock)
+}
+
+// SetOnMisuse enables misuse detection, for debugging. See ThreadunsafeLLRU.SetOnMisuse
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnMisuse(onMisuse func(misuse Misuse[K])) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnMisuse(onMisuse)
+}
+
+// SetOnLock sets a callback which is called whenever an entry becomes locked. See ThreadunsafeLLRU.SetOnLock
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnLock(onLock func(key K, value V)) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnLock(onLock)
+}
+
+// SetOnUnlock sets a callback which is called whenever an entry becomes unlocked. See ThreadunsafeLLRU.SetOnUnlock
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnUnlock(onUnlock func(key K, value V)) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnUnlock(onUnlock)
+}
+
+// SetOnAdd sets a callback which is called whenever a key which did not exist is added. See ThreadunsafeLLRU.SetOnAdd
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnAdd(onAdd func(key K, value V, locked bool)) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnAdd(onAdd)
+}
+
+// SetOnUpdate sets a callback which is called whenever the value of an existing entry is replaced. See
+// ThreadunsafeLLRU.SetOnUpdate
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnUpdate(onUpdate func(key K, oldValue V, newValue V, wasLocked bool)) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnUpdate(onUpdate)
+}
+
+// Subscribe adds a subscriber which is called with every event of the given kinds. See ThreadunsafeLLRU.Subscribe
+// The subscriber is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) Subscribe(fn func(event Event[K, V]), kinds ...EventKind) Subscription {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Subscribe(fn, kinds...)
+}
+
+// SubscribeChan adds a subscriber which sends every event of the given kinds to the returned channel. See
+// ThreadunsafeLLRU.SubscribeChan
+// With EventsBlock, events are sent while holding the cache lock, so the receiver must not call methods of the LLRU
+func (llru *LLRU[K, V]) SubscribeChan(bufferSize int, overflow EventsOverflow, kinds ...EventKind) (<-chan Event[K, V], Subscription) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.SubscribeChan(bufferSize, overflow, kinds...)
+}
+
+// DroppedEvents returns the number of events which could not be sent to the channel of a subscription. See
+// ThreadunsafeLLRU.DroppedEvents
+func (llru *LLRU[K, V]) DroppedEvents(subscription Subscription) uint64 {
+	llru.lock.RLock()
+	defer llru.lock.RUnlock()
+	return llru.tullru.DroppedEvents(subscription)
+}
+
+// Unsubscribe removes a subscriber added with Subscribe or SubscribeChan. See ThreadunsafeLLRU.Unsubscribe
+func (llru *LLRU[K, V]) Unsubscribe(subscription Subscription) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Unsubscribe(subscription)
+}
+
+// SetOnHit sets a callback which is called whenever Get finds a key. See ThreadunsafeLLRU.SetOnHit
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnHit(onHit func(key K)) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnHit(onHit)
+}
+
+// SetOnMiss sets a callback which is called whenever Get does not find a key. See ThreadunsafeLLRU.SetOnMiss
+// The callback is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) SetOnMiss(onMiss func(key K)) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.SetOnMiss(onMiss)
+}
+
+func (llru *LLRU[K, V]) Lock(key K) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Lock(key)
+}
+
+// LockCtx locks a value in the cache, like Lock. If the key does not exist, it blocks until the key is added or the
+// context is done.
+// Returns `nil` once the key is locked, otherwise the context's error
+func (llru *LLRU[K, V]) LockCtx(ctx context.Context, key K) error {
+	for {
+		llru.lock.Lock()
+		ok := llru.tullru.Lock(key)
+		changed := llru.changed
+		llru.lock.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// TryLock locks an unlocked value in the cache. See ThreadunsafeLLRU.TryLock
+func (llru *LLRU[K, V]) TryLock(key K) error {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.TryLock(key)
+}
+
+// GetAndLock locks a value in the cache and returns it. See ThreadunsafeLLRU.GetAndLock
+func (llru *LLRU[K, V]) GetAndLock(key K) (value V, ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.GetAndLock(key)
+}
+
+// Acquire locks a value in the cache and returns a lease which unlocks it when closed. See ThreadunsafeLLRU.Acquire
+func (llru *LLRU[K, V]) Acquire(key K) (lease *Lease[K, V], ok bool) {
+	value, ok := llru.GetAndLock(key)
+	if !ok {
+		return nil, false
+	}
+	return newLease(key, value, llru.Unlock), true
+}
+
+// WithLocked locks a value in the cache, calls `fn` with it, then unlocks it, even if `fn` panics.
+// The cache lock is not held while `fn` runs, so `fn` may call methods of the LLRU. See ThreadunsafeLLRU.WithLocked
+func (llru *LLRU[K, V]) WithLocked(key K, fn func(value V)) (ok bool) {
+	lease, ok := llru.Acquire(key)
+	if !ok {
+		return false
+	}
+	defer lease.Close()
+
+	fn(lease.Value())
+	return true
+}
+
+func (llru *LLRU[K, V]) LockOldest() *Entry[K, V] {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.LockOldest()
+}
+
+func (llru *LLRU[K, V]) LockNewest() *Entry[K, V] {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.LockNewest()
+}
+
+// GetOldest returns the least recently used unlocked entry without changing its recency. See ThreadunsafeLLRU.GetOldest
+func (llru *LLRU[K, V]) GetOldest() *Entry[K, V] {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.getOldest()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.GetOldest()
+}
+
+// OldestUnlocked returns the unlocked entry which would be evicted next, without evicting it. See
+// ThreadunsafeLLRU.OldestUnlocked
+func (llru *LLRU[K, V]) OldestUnlocked() *Entry[K, V] {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.oldestUnlocked()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.OldestUnlocked()
+}
+
+// GetNewest returns the newest entry, locked or unlocked, without changing its recency. See ThreadunsafeLLRU.GetNewest
+func (llru *LLRU[K, V]) GetNewest() *Entry[K, V] {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.getNewest()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.GetNewest()
+}
+
+// LockAs locks a value in the cache on behalf of `owner`. See ThreadunsafeLLRU.LockAs
+func (llru *LLRU[K, V]) LockAs(key K, owner string) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.LockAs(key, owner)
+}
+
+// UnlockAs releases a lock taken with LockAs by `owner`. See ThreadunsafeLLRU.UnlockAs
+func (llru *LLRU[K, V]) UnlockAs(key K, owner string) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.UnlockAs(key, owner)
+}
+
+// Owners returns the owners of the locks taken with LockAs on an entry. See ThreadunsafeLLRU.Owners
+func (llru *LLRU[K, V]) Owners(key K) []string {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.ownersOf(key)
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Owners(key)
+}
+
+// LockMany locks each of the given keys while holding the cache lock once. See ThreadunsafeLLRU.LockMany
+func (llru *LLRU[K, V]) LockMany(keys []K) (ok []bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.LockMany(keys)
+}
+
+// LockFor locks a value in the cache and releases that lock once `duration` has elapsed. See ThreadunsafeLLRU.LockFor
+func (llru *LLRU[K, V]) LockFor(key K, duration time.Duration) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.LockFor(key, duration)
+}
+
+// ReleaseExpiredLocks releases every expired timed lock. See ThreadunsafeLLRU.ReleaseExpiredLocks
+func (llru *LLRU[K, V]) ReleaseExpiredLocks() int {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.ReleaseExpiredLocks()
+}
+
+// StartJanitor starts a goroutine which, every `interval`, releases expired timed locks and removes expired entries,
+// calling the eviction callbacks with EvictionReasonExpired, so that they do not linger while the cache is idle, or
+// when their keys are never used again. Call the returned function, or Close, to stop it
+// If `interval` is not positive, no goroutine is started and the returned function does nothing
+func (llru *LLRU[K, V]) StartJanitor(interval time.Duration) (stop func()) {
+	return llru.startPeriodic(interval, func() {
+		defer llru.tullru.startBatch()()
+		llru.tullru.releaseExpired()
+	})
+}
+
+// StartMemoryController starts a goroutine which, every `interval`, reads the heap size of the process and resizes the
+// cache between the bounds set with SetSizeBounds, shrinking it as the heap grows towards `heapLimit` bytes and growing it
+// back as the heap shrinks. See ThreadunsafeLLRU.ApplyMemoryPressure. Call the returned function, or Close, to stop it
+// Reading the heap size stops the world briefly, so the interval should not be too short
+// If `interval` or `heapLimit` is not positive, or the size bounds are not set, an error is returned and no goroutine is
+// started. If the cache is frozen when the heap size is read, or the size bounds are unset afterwards, the cache is left
+// as it is until the next interval
+func (llru *LLRU[K, V]) StartMemoryController(interval time.Duration, heapLimit uint64) (stop func(), err error) {
+	if interval <= 0 {
+		return nil, errors.New("interval must be positive")
+	}
+	if heapLimit == 0 {
+		return nil, errors.New("heap limit must be positive")
+	}
+	llru.lock.RLock()
+	bounded := llru.tullru.maxSize > 0
+	llru.lock.RUnlock()
+	if !bounded {
+		return nil, errors.New("size bounds are not set")
+	}
+
+	return llru.startPeriodic(interval, func() {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		_, _ = llru.tullru.ApplyMemoryPressure(float64(stats.HeapAlloc) / float64(heapLimit))
+	}), nil
+}
+
+//starts a goroutine which calls `task` every `interval`, while holding the cache lock, until the returned function or
+//Close is called. Does nothing if `interval` is not positive, which time.NewTicker would panic on
+func (llru *LLRU[K, V]) startPeriodic(interval time.Duration, task func()) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				llru.lock.Lock()
+				select {
+				case <-done: //stopped while waiting for the lock
+				default:
+					task()
+				}
+				llru.lock.Unlock()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.stopJanitors = append(llru.stopJanitors, stop)
+	return stop
+}
+
+// Close stops every goroutine started with StartJanitor and StartMemoryController. The cache can still be used afterwards
+func (llru *LLRU[K, V]) Close() {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	for _, stop := range llru.stopJanitors {
+		stop()
+	}
+	llru.stopJanitors = nil
+}
+
+func (llru *LLRU[K, V]) Unlock(key K) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Unlock(key)
+}
+
+// UnlockIf unlocks a locked value only if `predicate` returns true for its value. See ThreadunsafeLLRU.UnlockIf
+// The predicate is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) UnlockIf(key K, predicate func(value V) bool) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.UnlockIf(key, predicate)
+}
+
+// UnlockMany unlocks each of the given keys while holding the cache lock once. See ThreadunsafeLLRU.UnlockMany
+func (llru *LLRU[K, V]) UnlockMany(keys []K) (ok []bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.UnlockMany(keys)
+}
+
+func (llru *LLRU[K, V]) UnlockAll() int {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.UnlockAll()
+}
+
+// SetLockStates locks or unlocks many keys at once. See ThreadunsafeLLRU.SetLockStates
+func (llru *LLRU[K, V]) SetLockStates(states map[K]bool) (failed []K) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.SetLockStates(states)
+}
+
+// RLock adds a read pin to an entry. See ThreadunsafeLLRU.RLock
+func (llru *LLRU[K, V]) RLock(key K) error {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.RLock(key)
+}
+
+// RUnlock removes a read pin from an entry. See ThreadunsafeLLRU.RUnlock
+func (llru *LLRU[K, V]) RUnlock(key K) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.RUnlock(key)
+}
+
+// WLock adds a write pin to an entry. See ThreadunsafeLLRU.WLock
+func (llru *LLRU[K, V]) WLock(key K) error {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.WLock(key)
+}
+
+// WUnlock removes the write pin from an entry. See ThreadunsafeLLRU.WUnlock
+func (llru *LLRU[K, V]) WUnlock(key K) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.WUnlock(key)
+}
+
+// Get gets a value and makes it the most recently used. See ThreadunsafeLLRU.Get
+// Unlike the methods which only read the cache, such as Peek, Contains, Len, Keys, Values, Range, Find, List and
+// Snapshot, which only take the read lock while no lock or entry needs to be released for having expired, it takes the
+// write lock, as it changes the recency of the entry, so Peek should be preferred when the recency does not matter
+func (llru *LLRU[K, V]) Get(key K) (value *V) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Get(key)
+}
+
+// Get2 gets a value without returning a pointer to it. See ThreadunsafeLLRU.Get2
+func (llru *LLRU[K, V]) Get2(key K) (value V, ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Get2(key)
+}
+
+// GetOrDefault gets the value of a key, or returns `def` if the key does not exist. See ThreadunsafeLLRU.GetOrDefault
+func (llru *LLRU[K, V]) GetOrDefault(key K, def V) V {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.GetOrDefault(key, def)
+}
+
+// Peek returns the value of a key without changing its recency. See ThreadunsafeLLRU.Peek
+func (llru *LLRU[K, V]) Peek(key K) (value V, ok bool) {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.peekValue(key)
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Peek(key)
+}
+
+// Touch makes an unlocked entry the most recently used item without reading its value. See ThreadunsafeLLRU.Touch
+func (llru *LLRU[K, V]) Touch(key K) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Touch(key)
+}
+
+// GetMany gets each of the given keys while holding the cache lock once. See ThreadunsafeLLRU.GetMany
+func (llru *LLRU[K, V]) GetMany(keys []K) (values []V, ok []bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.GetMany(keys)
+}
+
+// GetWithExpiry gets a value along with the time at which it expires. See ThreadunsafeLLRU.GetWithExpiry
+func (llru *LLRU[K, V]) GetWithExpiry(key K) (value V, expiresAt time.Time, ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.GetWithExpiry(key)
+}
+
+func (llru *LLRU[K, V]) Contains(key K) bool {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.contains(key)
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Contains(key)
+}
+
+func (llru *LLRU[K, V]) IsLocked(key K) (locked bool, exists bool) {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.isLocked(key)
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.IsLocked(key)
+}
+
+func (llru *LLRU[K, V]) Len() int {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.locked.Len() + llru.tullru.unlocked.Len()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Len()
+}
+
+func (llru *LLRU[K, V]) LenLocked() int {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.locked.Len()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.LenLocked()
+}
+
+func (llru *LLRU[K, V]) LenUnlocked() int {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.unlocked.Len()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.LenUnlocked()
+}
+
+func (llru *LLRU[K, V]) EvictableRoom() int {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.unlockedSize()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.EvictableRoom()
+}
+
+// Room returns the size of the cache, the number of locked and unlocked entries, and the room for unlocked entries, from
+// a single consistent snapshot. See ThreadunsafeLLRU.Room
+func (llru *LLRU[K, V]) Room() (size, lockedLen, unlockedLen, evictableRoom int) {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.room()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Room()
+}
+
+func (llru *LLRU[K, V]) Entries() []Entry[K,V] {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.entries()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Entries()
+}
+
+// Keys returns every key, locked ones included, unlocked first from oldest to newest, then locked. See
+// ThreadunsafeLLRU.Keys
+func (llru *LLRU[K, V]) Keys() []K {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.keys()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Keys()
+}
+
+// Range calls `fn` with every entry until it returns false, without changing their recency. See ThreadunsafeLLRU.Range
+// `fn` is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) Range(fn func(key K, value V, locked bool) bool) {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		llru.tullru.rangeEntries(false, fn)
+		return
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.Range(fn)
+}
+
+// RangeReverse calls `fn` with every entry, in the opposite order to Range, until it returns false. See
+// ThreadunsafeLLRU.RangeReverse
+// `fn` is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) RangeReverse(fn func(key K, value V, locked bool) bool) {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		llru.tullru.rangeEntries(true, fn)
+		return
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.RangeReverse(fn)
+}
+
+// Snapshot returns a copy of every entry, with its lock state, which can be iterated without holding the cache lock
+// while the cache keeps changing. The cache lock is only held while the entries are copied. See ThreadunsafeLLRU.Snapshot
+func (llru *LLRU[K, V]) Snapshot() *Snapshot[K, V] {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return newSnapshot(&llru.tullru)
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Snapshot()
+}
+
+// Stream returns a channel which receives every entry until `ctx` is done. The cache lock is only held while the entries
+// are copied, before Stream returns. See ThreadunsafeLLRU.Stream
+func (llru *LLRU[K, V]) Stream(ctx context.Context) <-chan Entry[K, V] {
+	return llru.Snapshot().Stream(ctx)
+}
+
+// List returns up to `limit` entries, skipping the first `offset` ones, along with the total number of entries. See
+// ThreadunsafeLLRU.List
+// The cache lock is only held while the page is copied, so the page can be serialized without holding it
+func (llru *LLRU[K, V]) List(offset, limit int) (page []EntryInfo[K, V], total int) {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.list(offset, limit)
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.List(offset, limit)
+}
+
+// RangeWhere calls `fn` with every entry for which `predicate` returns true, until `fn` returns false. See
+// ThreadunsafeLLRU.RangeWhere
+// Both functions are called while holding the cache lock, so they must not call methods of the LLRU
+func (llru *LLRU[K, V]) RangeWhere(predicate func(key K, value V) bool, fn func(key K, value V, locked bool) bool) {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		llru.tullru.rangeWhere(predicate, fn)
+		return
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.RangeWhere(predicate, fn)
+}
+
+// Find returns every entry for which `predicate` returns true, while holding the cache lock once. See
+// ThreadunsafeLLRU.Find
+// `predicate` is called while holding the cache lock, so it must not call methods of the LLRU
+func (llru *LLRU[K, V]) Find(predicate func(key K, value V) bool) []Entry[K, V] {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.find(predicate)
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Find(predicate)
+}
+
+// KeysByRecency returns up to `limit` of the most or least recently used unlocked keys. See
+// ThreadunsafeLLRU.KeysByRecency
+func (llru *LLRU[K, V]) KeysByRecency(limit int, newestFirst bool) []K {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.keysByRecency(limit, newestFirst)
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.KeysByRecency(limit, newestFirst)
+}
+
+func (llru *LLRU[K, V]) Values() []V {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.values()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Values()
+}
+
+// ValuesLocked returns the values of the locked entries, in the order they were locked. See ThreadunsafeLLRU.ValuesLocked
+func (llru *LLRU[K, V]) ValuesLocked() []V {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.valuesLocked()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.ValuesLocked()
+}
+
+// ValuesUnlocked returns the values of the unlocked entries, from oldest to newest. See ThreadunsafeLLRU.ValuesUnlocked
+func (llru *LLRU[K, V]) ValuesUnlocked() []V {
+	if llru.rlock() {
+		defer llru.lock.RUnlock()
+		return llru.tullru.unlocked.Values()
+	}
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.ValuesUnlocked()
+}
+
+// Freeze prevents entries from being evicted or removed until Thaw is called. See ThreadunsafeLLRU.Freeze
+func (llru *LLRU[K, V]) Freeze() {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.Freeze()
+}
+
+func (llru *LLRU[K, V]) Thaw() {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	llru.tullru.Thaw()
+}
+
+func (llru *LLRU[K, V]) IsFrozen() bool {
+	llru.lock.RLock()
+	defer llru.lock.RUnlock()
+	return llru.tullru.IsFrozen()
+}
+
+// ForceRemove removes an entry from the cache, even if it is locked. See ThreadunsafeLLRU.ForceRemove
+func (llru *LLRU[K, V]) ForceRemove(key K) (ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.ForceRemove(key)
+}
+
+// Remove removes an entry from the cache, whether it is locked or unlocked. See ThreadunsafeLLRU.Remove
+func (llru *LLRU[K, V]) Remove(key K) (present bool, wasLocked bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Remove(key)
+}
+
+// Pop returns the value of a key and removes it, atomically. See ThreadunsafeLLRU.Pop
+func (llru *LLRU[K, V]) Pop(key K, force bool) (value V, ok bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Pop(key, force)
+}
+
+// RemoveMany removes each of the given keys while holding the cache lock once. See ThreadunsafeLLRU.RemoveMany
+func (llru *LLRU[K, V]) RemoveMany(keys []K) (present []bool) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.RemoveMany(keys)
+}
+
+// RemoveOldest removes the least recently used unlocked entry and returns it. See ThreadunsafeLLRU.RemoveOldest
+func (llru *LLRU[K, V]) RemoveOldest() *Entry[K, V] {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.RemoveOldest()
+}
+
+// RemoveOldestN removes up to `n` of the least recently used unlocked entries and returns them. See
+// ThreadunsafeLLRU.RemoveOldestN
+func (llru *LLRU[K, V]) RemoveOldestN(n int) (removed []Entry[K, V]) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.RemoveOldestN(n)
+}
+
+// Resize changes the size of the cache and returns the evicted entries. See ThreadunsafeLLRU.Resize
+func (llru *LLRU[K, V]) Resize(size int) (evicted []Entry[K, V], err error) {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Resize(size)
+}
+
+// Purge removes every entry, locked ones included. See ThreadunsafeLLRU.Purge
+func (llru *LLRU[K, V]) Purge() int {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.Purge()
+}
+
+// PurgeUnlocked removes every unlocked entry. See ThreadunsafeLLRU.PurgeUnlocked
+func (llru *LLRU[K, V]) PurgeUnlocked() int {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.PurgeUnlocked()
+}
+
+// EvictN evicts up to n unlocked entries and returns them. See ThreadunsafeLLRU.EvictN
+func (llru *LLRU[K, V]) EvictN(n int) []Entry[K, V] {
+	llru.lock.Lock()
+	defer llru.lock.Unlock()
+	return llru.tullru.EvictN(n)
 }
 
+// ReplaceOldestKey gives the least recently used unlocked entry a new key, keeping its value, so that its slot can be
+// reused. See ThreadunsafeLLRU.ReplaceOldestKey
 func (llru *LLRU[K, V]) ReplaceOldestKey(newKey K) (value *V, oldKey *K, ok bool) {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
 	return llru.tullru.ReplaceOldestKey(newKey)
 }
 
+// ReplaceOldestValue gives the least recently used unlocked entry a new value, keeping its key. See
+// ThreadunsafeLLRU.ReplaceOldestValue
 func (llru *LLRU[K, V]) ReplaceOldestValue(newValue V) (oldValue *V, key *K, ok bool) {
 	llru.lock.Lock()
 	defer llru.lock.Unlock()
@@ -0,0 +1,652 @@
+package lockable_lru
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func buildNewEmptySafe(t *testing.T, size int) *LLRU[string, string] {
+	llru, err := New[string, string](size)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+	return llru
+}
+
+// If the key is added while waiting, it is locked, and `nil` is returned
+func TestLockCtxWaitsForKey(t *testing.T) {
+	llru := buildNewEmptySafe(t, 2)
+
+	result := make(chan error)
+	go func() {
+		result <- llru.LockCtx(context.Background(), "new key")
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("expected `nil` but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("LockCtx did not return after the key was added")
+	}
+
+	locked, _ := llru.IsLocked("new key")
+	if !locked {
+		t.Errorf("expected key to be locked")
+	}
+}
+
+// A caller waiting in LockCtx for room to lock an entry is woken up once another entry is unlocked
+func TestLockCtxWaitsForLockRoom(t *testing.T) {
+	llru := buildNewEmptySafe(t, 2)
+	llru.SetMaxLocked(1)
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	result := make(chan error)
+	go func() {
+		result <- llru.LockCtx(context.Background(), "new key2")
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	_ = llru.Unlock("new key1")
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("expected `nil` but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("LockCtx did not return after another entry was unlocked")
+	}
+}
+
+// If the context is done before the key is added, the context's error is returned
+func TestLockCtxCancelled(t *testing.T) {
+	llru := buildNewEmptySafe(t, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := llru.LockCtx(ctx, "new key")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected `context.DeadlineExceeded` but got %v", err)
+	}
+}
+
+func TestJanitorReleasesExpiredLocks(t *testing.T) {
+	llru := buildNewEmptySafe(t, 2)
+
+	autoUnlocked := make(chan string, 1)
+	llru.SetOnAutoUnlock(func(key string, value string) {
+		autoUnlocked <- key
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+	_ = llru.LockFor("new key", time.Millisecond)
+
+	stop := llru.StartJanitor(time.Millisecond)
+	defer stop()
+
+	select {
+	case key := <-autoUnlocked:
+		if key != "new key" {
+			t.Errorf("expected `new key` but got %v", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("janitor did not release the expired lock")
+	}
+}
+
+// A janitor with no interval is not started
+func TestJanitorWithoutInterval(t *testing.T) {
+	llru := buildNewEmptySafe(t, 2)
+
+	stop := llru.StartJanitor(0)
+	stop()
+	_ = llru.StartJanitor(-time.Second)
+	llru.Close()
+}
+
+// An eviction callback set on the LLRU after it was created is called
+func TestSetOnEvictedWithReasonAfterNew(t *testing.T) {
+	llru := buildNewEmptySafe(t, 1)
+
+	var reasons []EvictionReason
+	llru.SetOnEvictedWithReason(func(key string, value string, reason EvictionReason) {
+		reasons = append(reasons, reason)
+	})
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	if len(reasons) != 1 || reasons[0] != EvictionReasonCapacity {
+		t.Errorf("expected `[capacity]` but got %v", reasons)
+	}
+}
+
+func TestSetEvictCallbackAndHooks(t *testing.T) {
+	llru := buildNewEmptySafe(t, 1)
+
+	var evicted []string
+	llru.SetEvictCallback(func(key string, value string) {
+		evicted = append(evicted, key)
+	})
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	if !slices.Equal(evicted, []string{"new key1"}) {
+		t.Errorf("expected `[new key1]` but got %v", evicted)
+	}
+
+	var added []string
+	hooks := llru.Hooks()
+	hooks.OnAdd = func(key string, value string, locked bool) {
+		added = append(added, key)
+	}
+	llru.SetHooks(hooks)
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	if !slices.Equal(evicted, []string{"new key1", "new key2"}) {
+		t.Errorf("expected `[new key1 new key2]` but got %v", evicted)
+	}
+	if !slices.Equal(added, []string{"new key3"}) {
+		t.Errorf("expected `[new key3]` but got %v", added)
+	}
+
+	llru.SetHooks(Hooks[string, string]{})
+	_, _ = llru.AddOrUpdateUnlocked("new key4", "4")
+	if len(evicted) != 2 || len(added) != 1 {
+		t.Errorf("expected the callbacks to be removed")
+	}
+}
+
+// Evicted entries are sent to the Evictions channel, and dropped once its buffer is full
+func TestEvictions(t *testing.T) {
+	llru := buildNewEmptySafe(t, 1)
+	evictions := llru.Evictions()
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	select {
+	case entry := <-evictions:
+		if entry.Key != "new key1" || entry.Value != "1" {
+			t.Errorf("expected `Entry{Key: \"new key1\", Value: \"1\"}` but got %v", entry)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected an evicted entry but got none")
+	}
+
+	for i := 0; i < EvictionsBufferSize+1; i++ {
+		_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+		_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	}
+	if dropped := llru.DroppedEvictions(); dropped != EvictionsBufferSize+2 {
+		t.Errorf("expected %v dropped entries but got %v", EvictionsBufferSize+2, dropped)
+	}
+}
+
+func TestEvictionsOverflow(t *testing.T) {
+	llru := buildNewEmptySafe(t, 1)
+	evictions := llru.Evictions()
+
+	//the oldest buffered entries make room for the new ones
+	llru.SetEvictionsOverflow(EventsDropOldest)
+	for i := 0; i < EvictionsBufferSize+1; i++ {
+		_, _ = llru.AddOrUpdateUnlocked(fmt.Sprint(i), "x")
+	}
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+	if dropped := llru.DroppedEvictions(); dropped != 1 {
+		t.Errorf("expected 1 dropped entry but got %v", dropped)
+	}
+	if entry := <-evictions; entry.Key != "1" {
+		t.Errorf("expected `1` to be the oldest entry left but got %v", entry)
+	}
+
+	//no entry is dropped, the add waits for the receiver instead
+	llru.SetEvictionsOverflow(EventsBlock)
+	added := make(chan struct{})
+	go func() {
+		for i := 0; i < 2; i++ {
+			_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+			_, _ = llru.AddOrUpdateUnlocked("new key2", "x")
+		}
+		close(added)
+	}()
+	for received := 0; received < EvictionsBufferSize+2; received++ {
+		select {
+		case <-evictions:
+		case <-time.After(time.Second):
+			t.Fatalf("expected an evicted entry but got none")
+		}
+	}
+	<-added
+	if dropped := llru.DroppedEvictions(); dropped != 1 {
+		t.Errorf("expected 1 dropped entry but got %v", dropped)
+	}
+}
+
+// The memory controller shrinks the cache to its smallest size when the heap is over the limit
+func TestMemoryControllerShrinksUnderPressure(t *testing.T) {
+	llru := buildNewEmptySafe(t, 4)
+	if err := llru.SetSizeBounds(1, 4); err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	//any heap is larger than a limit of one byte
+	stop, err := llru.StartMemoryController(time.Millisecond, 1)
+	if err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for llru.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("memory controller did not shrink the cache")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// The memory controller is not started without an interval, a heap limit and size bounds
+func TestMemoryControllerInvalidArguments(t *testing.T) {
+	llru := buildNewEmptySafe(t, 4)
+
+	if _, err := llru.StartMemoryController(time.Millisecond, 1); err == nil {
+		t.Errorf("expected an error without size bounds")
+	}
+	if err := llru.SetSizeBounds(1, 4); err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+	if _, err := llru.StartMemoryController(0, 1); err == nil {
+		t.Errorf("expected an error without an interval")
+	}
+	if _, err := llru.StartMemoryController(time.Millisecond, 0); err == nil {
+		t.Errorf("expected an error without a heap limit")
+	}
+}
+
+// The janitor removes expired entries even if the cache is not used, until Close is called
+func TestJanitorRemovesExpiredEntries(t *testing.T) {
+	llru := buildNewEmptySafe(t, 3)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru.SetClock(clock)
+	llru.SetDefaultTTL(time.Millisecond)
+
+	expired := make(chan string, 3)
+	llru.SetOnEvictedWithReason(func(key string, value string, reason EvictionReason) {
+		if reason == EvictionReasonExpired {
+			expired <- key
+		}
+	})
+	batches := make(chan []Entry[string, string], 2)
+	llru.SetOnEvictedBatch(func(entries []Entry[string, string]) {
+		batches <- entries
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key0", "0")
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	clock.now = clock.now.Add(time.Second) //before the janitor starts, so that it does not race with the clock
+	_ = llru.StartJanitor(time.Millisecond)
+
+	var keys []string
+	for len(keys) < 2 {
+		select {
+		case key := <-expired:
+			keys = append(keys, key)
+		case <-time.After(time.Second):
+			t.Fatalf("janitor did not remove the expired entries")
+		}
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"new key0", "new key1"}) {
+		t.Errorf("expected `[new key0 new key1]` but got %v", keys)
+	}
+	//the entries expired during the same sweep are passed in a single batch
+	select {
+	case batch := <-batches:
+		if len(batch) != 2 {
+			t.Errorf("expected a batch of 2 entries but got %v", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("janitor did not pass the expired entries to the batch eviction callback")
+	}
+
+	llru.Close()
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	llru.lock.Lock()
+	clock.now = clock.now.Add(time.Second)
+	llru.lock.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case key := <-expired:
+		t.Errorf("expected no expired entry after Close but got %v", key)
+	default:
+	}
+}
+
+// Concurrent callers missing the same key compute its value only once, and all get it
+func TestGetOrComputeOnce(t *testing.T) {
+	llru := buildNewEmptySafe(t, 2)
+
+	release := make(chan struct{})
+	var calls atomic.Int32
+	compute := func() (string, error) {
+		calls.Add(1)
+		<-release
+		return "computed", nil
+	}
+
+	const callers = 10
+	results := make(chan string, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			value, err := llru.GetOrCompute("new key", compute)
+			if err != nil {
+				t.Errorf("expected no error but got %v", err)
+			}
+			results <- value
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < callers; i++ {
+		if value := <-results; value != "computed" {
+			t.Errorf("expected `computed` but got %v", value)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected compute to be called once but got %v", n)
+	}
+	if value, ok := llru.Get2("new key"); !ok || value != "computed" {
+		t.Errorf("expected `computed, true` but got %v, %v", value, ok)
+	}
+
+	failure := errors.New("failure")
+	_, err := llru.GetOrCompute("other key", func() (string, error) { return "", failure })
+	if !errors.Is(err, failure) || llru.Contains("other key") {
+		t.Errorf("expected `failure` and no entry but got %v", err)
+	}
+}
+
+// The oldest unlocked entry can be reused through the LLRU, and the key it is given wakes up callers waiting for it
+func TestReplaceOldestSafe(t *testing.T) {
+	llru := buildNewEmptySafe(t, 2)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	result := make(chan error)
+	go func() {
+		result <- llru.LockCtx(context.Background(), "new key3")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	value, oldKey, ok := llru.ReplaceOldestKey("new key3")
+	if !ok || *value != "2" || *oldKey != "new key2" {
+		t.Fatalf("expected `2, new key2, true` but got %v, %v, %v", value, oldKey, ok)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("expected `nil` but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("LockCtx did not return after the key was replaced")
+	}
+
+	_ = llru.Unlock("new key3")
+	oldValue, key, ok := llru.ReplaceOldestValue("3")
+	if !ok || *oldValue != "2" || *key != "new key3" {
+		t.Errorf("expected `2, new key3, true` but got %v, %v, %v", oldValue, key, ok)
+	}
+	if value, _ := llru.Peek("new key3"); value != "3" {
+		t.Errorf("expected `3` but got %v", value)
+	}
+}
+
+// Moving entries between two caches in both directions at the same time does not deadlock
+func TestMoveToBothWays(t *testing.T) {
+	a := buildNewEmptySafe(t, 2)
+	b := buildNewEmptySafe(t, 2)
+	_, _ = a.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = b.AddOrUpdateUnlocked("new key2", "2")
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			_ = a.MoveTo(b, "new key1")
+			_ = b.MoveTo(a, "new key1")
+		}
+		close(done)
+	}()
+	for i := 0; i < 1000; i++ {
+		_ = b.MoveTo(a, "new key2")
+		_ = a.MoveTo(b, "new key2")
+	}
+	<-done
+
+	if a.Len() + b.Len() != 2 {
+		t.Errorf("expected 2 entries in total but got %v, %v", a.Keys(), b.Keys())
+	}
+}
+
+// Operations composed with Do are atomic, and wake up callers waiting in LockCtx
+func TestDo(t *testing.T) {
+	llru := buildNewEmptySafe(t, 2)
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+
+	result := make(chan error)
+	go func() {
+		result <- llru.LockCtx(context.Background(), "new key2")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	llru.Do(func(tullru *ThreadunsafeLLRU[string, string]) {
+		if value, ok := tullru.Peek("new key1"); ok {
+			_, _ = tullru.Pop("new key1", false)
+			_, _ = tullru.AddOrUpdateUnlocked("new key2", value+"'")
+		}
+	})
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("expected `nil` but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("LockCtx did not return after the key was added in Do")
+	}
+	if keys := llru.Keys(); len(keys) != 1 || keys[0] != "new key2" {
+		t.Errorf("expected `[new key2]` but got %v", keys)
+	}
+}
+
+// A snapshot can be iterated while the cache keeps changing, and is not affected by the changes
+func TestSnapshotUnderConcurrency(t *testing.T) {
+	llru := buildNewEmptySafe(t, 3)
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+
+	snapshot := llru.Snapshot()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+			_, _ = llru.Remove("new key1")
+			_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+		}
+		close(done)
+	}()
+
+	var keys []string
+	var locked []bool
+	snapshot.Range(func(key string, value string, isLocked bool) bool {
+		//the cache can be used while iterating
+		_ = llru.Contains(key)
+		keys = append(keys, key)
+		locked = append(locked, isLocked)
+		return true
+	})
+	<-done
+
+	if len(keys) != 2 || keys[0] != "new key1" || keys[1] != "new key2" || locked[0] || !locked[1] {
+		t.Errorf("expected `[new key1 new key2]`, `[false true]` but got %v, %v", keys, locked)
+	}
+	if entry, isLocked := snapshot.At(1); entry.Value != "2" || !isLocked || snapshot.Len() != 2 {
+		t.Errorf("expected `2, true` but got %v, %v", entry, isLocked)
+	}
+}
+
+// Stream sends every entry, and stops once the context is done
+func TestStream(t *testing.T) {
+	llru := buildNewEmptySafe(t, 3)
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateLocked("new key3", "3")
+
+	var keys []string
+	for entry := range llru.Stream(context.Background()) {
+		keys = append(keys, entry.Key)
+	}
+	if len(keys) != 3 || keys[0] != "new key1" || keys[2] != "new key3" {
+		t.Errorf("expected `[new key1 new key2 new key3]` but got %v", keys)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	entries := llru.Stream(ctx)
+	<-entries
+	cancel()
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("the channel was not closed after the context was cancelled")
+	case _, ok := <-entries:
+		//the next entry may have been sent before the cancellation was noticed
+		if ok {
+			if _, ok = <-entries; ok {
+				t.Errorf("expected the channel to be closed")
+			}
+		}
+	}
+}
+
+// With EventsBlock, no event is dropped however slow the receiver
+func TestSubscribeChanBlock(t *testing.T) {
+	llru := buildNewEmptySafe(t, 100)
+
+	events, subscription := llru.SubscribeChan(0, EventsBlock, EventAdd)
+	received := make(chan int)
+	go func() {
+		count := 0
+		for range events {
+			count++
+		}
+		received <- count
+	}()
+
+	for i := 0; i < 50; i++ {
+		_, _ = llru.AddOrUpdateUnlocked(fmt.Sprint(i), "value")
+	}
+	_ = llru.Unsubscribe(subscription)
+
+	if count := <-received; count != 50 {
+		t.Errorf("expected 50 events but got %v", count)
+	}
+	if dropped := llru.DroppedEvents(subscription); dropped != 0 {
+		t.Errorf("expected no dropped events but got %v", dropped)
+	}
+}
+
+// Read-only methods only take the read lock, unless expired entries must be removed first
+func TestReadOnlyMethodsShareTheLock(t *testing.T) {
+	llru := buildNewEmptySafe(t, 10)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru.SetClock(clock)
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlockedWithTTL("new key2", "2", time.Minute)
+
+	llru.lock.RLock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if value, ok := llru.Peek("new key1"); !ok || value != "1" {
+			t.Errorf("expected `1` but got %v, %v", value, ok)
+		}
+		if !llru.Contains("new key2") || llru.Len() != 2 || len(llru.Keys()) != 2 {
+			t.Errorf("expected both keys to be found")
+		}
+		llru.Range(func(key string, value string, locked bool) bool {
+			return true
+		})
+		_ = llru.Find(func(key string, value string) bool { return true })
+		_, _ = llru.List(0, 10)
+		_ = llru.GetOldest()
+		_ = llru.OldestUnlocked()
+		_ = llru.KeysByRecency(10, true)
+		_ = llru.Owners("new key1")
+		_ = llru.Weight()
+		_ = llru.LenBytes()
+		_ = llru.IsFrozen()
+		_ = llru.Hooks()
+		if llru.Snapshot().Len() != 2 {
+			t.Errorf("expected a snapshot of both keys")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected read-only methods not to wait for another reader")
+	}
+	llru.lock.RUnlock()
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	if llru.Contains("new key2") || llru.Len() != 1 {
+		t.Errorf("expected `new key2` to have expired")
+	}
+
+	//an expired timed lock is released before reading, under the write lock
+	_ = llru.LockFor("new key1", time.Minute)
+	clock.now = clock.now.Add(2 * time.Minute)
+	if locked, _ := llru.IsLocked("new key1"); locked {
+		t.Errorf("expected the timed lock on `new key1` to be released")
+	}
+}
+
+func TestReadOnlyMethodsConcurrently(t *testing.T) {
+	llru := buildNewEmptySafe(t, 100)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				_, _ = llru.AddOrUpdateUnlockedWithTTL(fmt.Sprint(g, i), "value", time.Microsecond)
+			}
+		}(g)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				_, _ = llru.Peek(fmt.Sprint(g, i))
+				_ = llru.Contains(fmt.Sprint(g, i))
+				_ = llru.Len()
+				_ = llru.Values()
+			}
+		}(g)
+	}
+	wg.Wait()
+}
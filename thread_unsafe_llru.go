@@ -3,9 +3,8 @@ package lockable_lru
 /*
  * A thread-safe LRU implementation with items that can be "locked"
  *
- * Based on hashicorp/golang-lru. Uses orcaman/concurrent-map for locked items. Both of these underlying
- * structures are thread-safe, so if you really want ThreadunsafeLLRU to be faster, this needs to be refactored
- * to use non-locking underlying implementations. 
+ * Modelled on hashicorp/golang-lru. Unlocked items are kept in an LRU mirroring the API of hashicorp's simplelru (see
+ * unlocked_lru.go) and locked items in wk8/go-ordered-map. Neither of these underlying structures is thread-safe.
  * 
  * The only reason there is a thread-unsafe version of this LLRU is to separate concerns and keep the code clearn.
  * Thread safety is handled entirely in thread_safe_llru.go.
@@ -14,21 +13,243 @@ package lockable_lru
  *
  */
 import (
-	lru "github.com/hashicorp/golang-lru/v2"
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"time"
+
 	gmap "github.com/wk8/go-ordered-map/v2"
 )
 
 type ThreadunsafeLLRU[K comparable, V any] struct {
-	unlocked         *lru.Cache[K, V]							//unlocked k-v store whose values can be evicted when a new value is added
+	unlocked         *unlockedLRU[K, V]							//unlocked k-v store whose values can be evicted when a new value is added
 	locked						*gmap.OrderedMap[K,V]   //locked k-v store, whose values can never be evicted
-	size int			                                //total size, combined locked and unlocked
+	size int			                                //total size, combined locked and unlocked, or 0 for no limit
+	minSize int                                 //smallest size ApplyMemoryPressure shrinks to, when size bounds are set
+	maxSize int                                 //largest size ApplyMemoryPressure grows to, or 0 when size bounds are not set
+	lockCounts map[K]int                        //number of outstanding locks for each locked key
+	countLocks bool                             //when false, a single Unlock unlocks an entry regardless of how many times it was locked
+	lockDeadlines map[K]time.Time               //time at which the timed lock on each key is released
+	lockedPositions map[K]unlockedPosition      //position of each locked key among the unlocked entries at the time it was locked
+	keepPositionOnUnlock bool                   //when true, unlocked entries go back to their pre-lock recency instead of becoming the most recent
+	onAdd func(key K, value V, locked bool)     //called when a key which did not exist is added
+	onUpdate func(key K, oldValue V, newValue V, wasLocked bool) //called when the value of an existing entry is replaced
+	subscribers []subscriber[K, V]              //receive events, in the order they subscribed
+	lastSubscription Subscription               //last identifier handed out by Subscribe
+	onHit func(key K)                           //called when Get finds a key
+	onMiss func(key K)                          //called when Get does not find a key
+	onLock func(key K, value V)                 //called when an entry becomes locked
+	onUnlock func(key K, value V)               //called when an entry becomes unlocked
+	onAutoUnlock func(key K, value V)           //called when a timed lock expires
+	onEvicted func(key K, value V)              //called when an entry is evicted or removed
+	onEvictedWithReason func(key K, value V, reason EvictionReason) //called when an entry is evicted or removed, with the reason
+	onEvictedWithHistory func(key K, value V, reason EvictionReason, history LockHistory) //called when an entry is evicted or removed, with the reason and its lock history
+	onMisuse func(misuse Misuse[K])             //called when an entry is unlocked more times than it was locked
+	metrics MetricsSink                         //receives hits, misses, evictions, latencies and lengths, if set
+	observing bool                              //whether the latency of an operation is being measured
+	onChanged func()                            //called when an entry is added, unlocked or removed, or the room for locked entries changes
+	maxLocked int                               //maximum number of locked entries, or 0 for no limit other than size
+	unlimitedLocked bool                        //when true, locked entries do not count against the size, which only bounds the unlocked entries
+	reserved int                                //room kept for locked entries to be added, taken by Reserve and used up by AddOrUpdateLocked
+	pinOnGet bool                               //when true, Get locks the entry it returns
+	forbidImplicitUnlock bool                   //when true, AddOrUpdateUnlocked refuses to update locked entries instead of unlocking them
+	frozen bool                                 //when true, no entry can be evicted or removed
+	owners map[K][]string                       //owners of the locks taken with LockAs on each key, in the order they were taken
+	readPins map[K]int                          //number of read pins held on each key
+	writePins map[K]bool                        //keys on which a write pin is held
+	evictions chan Entry[K, V]                  //receives evicted and removed entries once Evictions has been called
+	droppedEvictions uint64                     //number of entries not sent to evictions because its buffer was full
+	evictionsOverflow EventsOverflow            //what happens to an entry sent to evictions when its buffer is full
+	overflow OverflowHandler[K, V]              //second tier receiving entries evicted for lack of room, and consulted by Get on a miss
+	onEvictedBatch func(entries []Entry[K, V])  //called once per operation with every entry evicted or removed by it
+	batch []Entry[K, V]                         //entries evicted or removed by the current operation, for onEvictedBatch
+	batchDepth int                              //number of nested operations collecting entries for onEvictedBatch
+	defaultTTL time.Duration                    //lifetime given to entries when they are added or updated, or 0 for no expiration
+	expiresAt map[K]time.Time                   //time at which each entry with a lifetime expires
+	ttls map[K]time.Duration                    //lifetime of each entry which has one
+	expireAfterAccess bool                      //when true, reading an entry restarts its lifetime
+	expireLocked bool                           //when true, locked entries expire like unlocked ones
+	onExpired func(key K, value V)              //called instead of onEvicted and onEvictedWithReason when an entry expires
+	clock Clock                                 //source of the current time for lifetimes, timed locks and ages
+	ttlJitter float64                           //fraction of each lifetime by which it is randomly shortened or lengthened
+	weigher func(key K, value V) int            //returns the cost of an entry, when the total cost is limited
+	maxWeight int                               //maximum total cost of the entries, locked and unlocked, when weigher is set
+	lowWatermark int                            //number of entries unlocked entries are evicted down to once highWatermark is reached
+	highWatermark int                           //number of entries which triggers evicting down to lowWatermark, or 0 when disabled
+	capacityPolicy func(lockedLen, unlockedLen, requested int) Decision //decides what to do when an add would go over the size
+	weights map[K]int                           //cost of each entry, when weigher is set
+	updatedAt map[K]time.Time                   //time at which each entry was last added or updated
+	lockHistories map[K]LockHistory             //lock history of each entry which was ever locked
+	finalizers map[K]func(key K, value V)       //called once when the value of an entry added with AddWithFinalizer leaves the cache
+	totalWeight int                             //total cost of the entries, when weigher is set
+	lockedWeight int                            //total cost of the locked entries, when weigher is set
+	nextExpiry time.Time                        //earliest time at which an unlocked entry may expire, zero if none can
+	nextLockRelease time.Time                   //earliest time at which a timed lock may be released, zero if there are none
+}
+
+// OverflowHandler is a second cache tier, for instance on disk or in Redis, which receives the unlocked entries evicted
+// for lack of room and is consulted by Get when a key is not in the cache, making the LLRU the first tier.
+// Its methods are called while holding the cache lock of an LLRU, so they must not call methods of the LLRU
+type OverflowHandler[K comparable, V any] interface {
+	// Store is called with every unlocked entry evicted to make room for another entry
+	Store(key K, value V)
+	// Load is called by Get when the key is not in the cache. If it returns `true`, the value is added back to the cache
+	// as an unlocked entry, so the handler may drop its own copy
+	Load(key K) (value V, ok bool)
+}
+
+// Clock is a source of the current time, which can be replaced to test time-dependent behaviour deterministically
+type Clock interface {
+	Now() time.Time
+}
+
+//clock returning the actual time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
 }
 
+// EvictionsBufferSize is the number of entries the channel returned by Evictions can hold before entries are dropped
+const EvictionsBufferSize = 1024
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrAlreadyLocked = errors.New("key is already locked")
+	ErrLockLimitReached = errors.New("maximum number of locked entries reached")
+	ErrReadLocked = errors.New("key is read-locked")
+	ErrWriteLocked = errors.New("key is write-locked")
+	ErrTooManyLocked = errors.New("size is smaller than the number of locked entries")
+	ErrFrozen = errors.New("cache is frozen")
+	ErrNotEnoughRoom = errors.New("not enough room")
+	ErrComputePanicked = errors.New("compute function panicked")
+)
+
 type Entry[K comparable, V any] struct {
 	Key K
 	Value V
 }
 
+// EntryInfo describes an entry listed by List
+type EntryInfo[K comparable, V any] struct {
+	Key K
+	Value V
+	Locked bool
+	ExpiresAt time.Time //time at which the entry expires, zero if it never expires
+}
+
+// LockHistory tells whether an entry which was evicted or removed had ever been locked, for instance to find out which
+// entries keep being evicted soon after they are unlocked
+type LockHistory struct {
+	EverLocked bool //whether the entry was locked at any time since it was added
+	LastUnlocked time.Time //time at which the entry was last unlocked, zero if it never was
+}
+
+// Misuse describes a call which unlocked an entry more times than it was locked
+type Misuse[K comparable] struct {
+	Key K
+	Reason string
+	Stack []byte //stack trace of the offending call
+}
+
+// EvictionReason tells why an entry was evicted or removed from the cache
+type EvictionReason int
+
+const (
+	EvictionReasonCapacity EvictionReason = iota //evicted to make room for another entry
+	EvictionReasonRemoved                        //removed by Remove, Pop, RemoveOldest, RemoveOldestN, EvictN, ReplaceOldestKey or ReplaceOldestValue
+	EvictionReasonForced                         //removed by ForceRemove, even though it may have been locked
+	EvictionReasonPurged                         //removed by Purge or PurgeUnlocked
+	EvictionReasonExpired                        //removed because its lifetime elapsed
+)
+
+func (reason EvictionReason) String() string {
+	switch reason {
+	case EvictionReasonCapacity:
+		return "capacity"
+	case EvictionReasonRemoved:
+		return "removed"
+	case EvictionReasonForced:
+		return "forced"
+	case EvictionReasonPurged:
+		return "purged"
+	case EvictionReasonExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// Hooks holds every callback of a cache, to read or replace them all at once with Hooks and SetHooks. A `nil` field
+// means there is no such callback. See the matching setters, such as SetOnAdd, for when each of them is called
+type Hooks[K comparable, V any] struct {
+	OnAdd func(key K, value V, locked bool)
+	OnUpdate func(key K, oldValue V, newValue V, wasLocked bool)
+	OnHit func(key K)
+	OnMiss func(key K)
+	OnLock func(key K, value V)
+	OnUnlock func(key K, value V)
+	OnAutoUnlock func(key K, value V)
+	OnEvicted func(key K, value V)
+	OnEvictedWithReason func(key K, value V, reason EvictionReason)
+	OnEvictedWithHistory func(key K, value V, reason EvictionReason, history LockHistory)
+	OnEvictedBatch func(entries []Entry[K, V])
+	OnExpired func(key K, value V)
+	OnMisuse func(misuse Misuse[K])
+}
+
+// MergeConflict tells Merge which value to keep when a key exists in both caches
+type MergeConflict int
+
+const (
+	MergeKeepExisting MergeConflict = iota //keeps the value of the cache being merged into
+	MergeOverwrite                         //takes the value of the cache being merged from
+	MergeNewestWins                        //keeps the value which was added or updated last
+)
+
+// Decision tells what to do when adding an entry would go over the size of the cache. See SetCapacityPolicy
+type Decision struct {
+	action decisionAction
+	growth int
+}
+
+type decisionAction int
+
+const (
+	decisionEvict decisionAction = iota
+	decisionReject
+	decisionGrow
+)
+
+// EvictDecision evicts unlocked entries to make room, as when no capacity policy is set. This is the zero value of
+// Decision.
+func EvictDecision() Decision {
+	return Decision{action: decisionEvict}
+}
+
+// RejectDecision refuses the add, which fails as if there was no room.
+func RejectDecision() Decision {
+	return Decision{action: decisionReject}
+}
+
+// GrowDecision grows the size of the cache by `growth` entries before adding, so that nothing needs to be evicted. A
+// growth which is not positive evicts instead.
+func GrowDecision(growth int) Decision {
+	return Decision{action: decisionGrow, growth: growth}
+}
+
+//where an entry sits among the unlocked entries, remembered while it is locked
+type unlockedPosition struct {
+	recency uint64
+	priority int
+	segment int //segment of the policy, or -1 if the entry was not unlocked
+}
+
 // New creates an LRU of the given size.
 func NewUnsafe[K comparable, V any](size int) (*ThreadunsafeLLRU[K, V], error) {
 	return NewUnsafeWithEvict[K, V](size, nil)
@@ -37,40 +258,347 @@ func NewUnsafe[K comparable, V any](size int) (*ThreadunsafeLLRU[K, V], error) {
 // NewWithEvict constructs a fixed size cache with the given eviction
 // callback.
 func NewUnsafeWithEvict[K comparable, V any](size int, onEvicted func(key K, value V)) (*ThreadunsafeLLRU[K, V], error) {
-	lru, err := lru.NewWithEvict(size, onEvicted)
-	if err != nil {	
+	return NewUnsafeWithPolicy[K, V](size, LRUPolicy(), onEvicted)
+}
+
+// NewUnsafeWithOptions constructs a fixed size cache configured with the given options, which are applied in order. A
+// size of 0 makes the cache unbounded, see NewUnsafeWithPolicy. If an option fails, its error is returned. WithShards
+// is only supported by NewShardedWithOptions, and makes it return an error
+func NewUnsafeWithOptions[K comparable, V any](size int, opts ...Option[K, V]) (*ThreadunsafeLLRU[K, V], error) {
+	options := collectOptions(opts)
+	if options.shards != 0 {
+		return nil, errors.New("WithShards is only supported by NewShardedWithOptions")
+	}
+	return newUnsafeWithOptions(size, options)
+}
+
+//constructs a cache configured with the options already collected, ignoring the number of shards
+func newUnsafeWithOptions[K comparable, V any](size int, options options[K, V]) (*ThreadunsafeLLRU[K, V], error) {
+	llru, err := NewUnsafeWithPolicy[K, V](size, options.policy, options.onEvicted)
+	if err != nil {
 		return nil, err
 	}
+	for _, setting := range options.settings {
+		if err := setting(llru); err != nil {
+			return nil, err
+		}
+	}
+	return llru, nil
+}
 
+// NewUnsafeWithPolicy constructs a fixed size cache whose unlocked entries are evicted according to the given policy,
+// with the given eviction callback, which may be nil. A size of 0 makes the cache unbounded: entries are never evicted
+// for lack of room, but can still be locked, expire, or be evicted to respect the weight limit and watermarks.
+func NewUnsafeWithPolicy[K comparable, V any](size int, policy Policy, onEvicted func(key K, value V)) (*ThreadunsafeLLRU[K, V], error) {
+	if size < 0 {
+		return nil, errors.New("must not provide a negative size")
+	}
 	m := gmap.New[K,V]()
 	llru := ThreadunsafeLLRU[K, V]{
-		unlocked: lru,
 		locked: m,
 		size: size,
+		onEvicted: onEvicted,
+		lockCounts: make(map[K]int),
+		countLocks: true,
+		lockDeadlines: make(map[K]time.Time),
+		lockedPositions: make(map[K]unlockedPosition),
+		owners: make(map[K][]string),
+		readPins: make(map[K]int),
+		writePins: make(map[K]bool),
+		expiresAt: make(map[K]time.Time),
+		ttls: make(map[K]time.Duration),
+		clock: systemClock{},
+		weights: make(map[K]int),
+		updatedAt: make(map[K]time.Time),
+		lockHistories: make(map[K]LockHistory),
+		finalizers: make(map[K]func(key K, value V)),
+	}
+
+	lru, err := newUnlockedLRU(llru.unlockedSize(), policy, llru.evicted)
+	if err != nil {	
+		return nil, err
 	}
+	llru.unlocked = lru
 
 	return &llru, nil
 }
 
+// Clone returns an independent copy of the cache, with the same entries, in the same order, with the same lock states,
+// lock counts, pins, owners, priorities and lifetimes, and the same settings and callbacks. Each value is passed through
+// `copyValue`, for instance to deep copy values holding pointers, or copied as is if `copyValue` is `nil`. The copy
+// starts with no Evictions channel, and changing either cache afterwards has no effect on the other. Finalizers set with
+// AddWithFinalizer are not copied, so that each of them is still called once, and neither are subscriptions added with
+// SubscribeChan
+func (llru *ThreadunsafeLLRU[K, V]) Clone(copyValue func(value V) V) *ThreadunsafeLLRU[K, V] {
+	llru.releaseExpired()
+
+	if copyValue == nil {
+		copyValue = func(value V) V { return value }
+	}
+	clone := *llru
+	clone.locked = gmap.New[K,V]()
+	for pair := llru.locked.Oldest(); pair != nil; pair = pair.Next() {
+		clone.locked.Set(pair.Key, copyValue(pair.Value))
+	}
+	clone.unlocked = llru.unlocked.clone(copyValue, clone.evicted)
+	clone.lockCounts = maps.Clone(llru.lockCounts)
+	clone.lockDeadlines = maps.Clone(llru.lockDeadlines)
+	clone.lockedPositions = maps.Clone(llru.lockedPositions)
+	clone.owners = make(map[K][]string, len(llru.owners))
+	for key, owners := range llru.owners {
+		clone.owners[key] = slices.Clone(owners)
+	}
+	clone.readPins = maps.Clone(llru.readPins)
+	clone.writePins = maps.Clone(llru.writePins)
+	clone.expiresAt = maps.Clone(llru.expiresAt)
+	clone.ttls = maps.Clone(llru.ttls)
+	clone.weights = maps.Clone(llru.weights)
+	clone.updatedAt = maps.Clone(llru.updatedAt)
+	clone.lockHistories = maps.Clone(llru.lockHistories)
+	clone.finalizers = make(map[K]func(key K, value V))
+	clone.subscribers = slices.DeleteFunc(slices.Clone(llru.subscribers), func(s subscriber[K, V]) bool {
+		return s.events != nil
+	})
+	clone.evictions = nil
+	clone.droppedEvictions = 0
+	clone.batch = nil
+	clone.batchDepth = 0
+	clone.onChanged = nil
+	return &clone
+}
+
+//entry of a cache being merged into another, see Merge
+type mergeCandidate[K comparable, V any] struct {
+	entry Entry[K, V]
+	locked bool
+	updatedAt time.Time
+}
+
+// Merge imports every entry of `other` into the cache, unlocked entries from oldest to newest, then locked entries in
+// the order they were locked, so that they keep their relative recency. Entries which are locked in `other` are locked
+// once in the cache, and entries which are already locked in the cache stay locked, keeping their lock count. When a key
+// exists in both caches, `conflict` decides which value is kept. `other` is left unchanged. Entries are added as
+// AddOrUpdateUnlocked and AddOrUpdateLocked would add them, so unlocked entries may be evicted to make room.
+// Returns the number of entries imported, and the keys which could not be imported for lack of room, in the order they
+// were tried
+func (llru *ThreadunsafeLLRU[K, V]) Merge(other *ThreadunsafeLLRU[K, V], conflict MergeConflict) (merged int, failed []K) {
+	defer llru.observe(MetricsOpAdd)()
+	if other == llru {
+		return 0, nil
+	}
+	return llru.merge(other.mergeCandidates(), conflict)
+}
+
+//returns the entries to import when merging this cache into another one
+func (llru *ThreadunsafeLLRU[K, V]) mergeCandidates() []mergeCandidate[K, V] {
+	llru.releaseExpired()
+
+	candidates := make([]mergeCandidate[K, V], 0, llru.locked.Len() + llru.unlocked.Len())
+	for _, entry := range collectEntriesFromUnderlyingUnlocked(llru.unlocked) {
+		candidates = append(candidates, mergeCandidate[K, V]{entry: entry, updatedAt: llru.updatedAt[entry.Key]})
+	}
+	for _, entry := range collectEntriesFromUnderlyingLocked(llru.locked) {
+		candidates = append(candidates, mergeCandidate[K, V]{entry: entry, locked: true, updatedAt: llru.updatedAt[entry.Key]})
+	}
+	return candidates
+}
+
+//imports the entries of another cache. See Merge
+func (llru *ThreadunsafeLLRU[K, V]) merge(candidates []mergeCandidate[K, V], conflict MergeConflict) (merged int, failed []K) {
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	for _, candidate := range candidates {
+		key, value := candidate.entry.Key, candidate.entry.Value
+		locked, exists := llru.IsLocked(key)
+		if exists {
+			switch conflict {
+			case MergeKeepExisting:
+				continue
+			case MergeNewestWins:
+				if !candidate.updatedAt.After(llru.updatedAt[key]) {
+					continue
+				}
+			}
+		}
+
+		var ok bool
+		switch {
+		case locked:
+			ok, _ = llru.Update(key, func(old V, exists bool) (V, bool) {
+				return value, true
+			})
+		case candidate.locked:
+			ok, _ = llru.AddOrUpdateLockedAll(key, value)
+		default:
+			ok, _ = llru.addOrUpdateUnlocked(key, value, nil)
+		}
+		if ok {
+			merged++
+		} else {
+			failed = append(failed, key)
+		}
+	}
+	return merged, failed
+}
+
+// MoveTo moves an entry to `other`, with its value, lock state, lock count, pins, owners, timed lock, priority and
+// lifetime, without calling the eviction callbacks of either cache, for instance to rebalance entries between shards.
+// The entry is added to `other` as AddOrUpdateUnlocked or AddOrUpdateLocked would add it, so unlocked entries of `other`
+// may be evicted to make room.
+// If the key exists and could be added to `other`, it is moved and `true` is returned
+// If the key does not exist, already exists in `other`, cannot be added to `other`, or the cache is frozen, nothing
+// changes and `false` is returned
+func (llru *ThreadunsafeLLRU[K, V]) MoveTo(other *ThreadunsafeLLRU[K, V], key K) (ok bool) {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+	defer other.startBatch()()
+	llru.releaseExpired()
+	other.releaseExpired()
+
+	locked, exists := llru.IsLocked(key)
+	if !exists || llru.frozen || other == llru || other.Contains(key) {
+		return false
+	}
+	value := *llru.peek(key)
+	if locked {
+		ok, _ = other.AddOrUpdateLockedAll(key, value)
+	} else {
+		priority := llru.priorityOf(key)
+		ok, _ = other.addOrUpdateUnlocked(key, value, &priority)
+	}
+	if !ok {
+		return false
+	}
+
+	if locked {
+		if lockCount, ok := llru.lockCounts[key]; ok {
+			other.lockCounts[key] = lockCount
+		} else {
+			delete(other.lockCounts, key)
+		}
+		if deadline, ok := llru.lockDeadlines[key]; ok {
+			other.setLockDeadline(key, deadline)
+		}
+		if owners, ok := llru.owners[key]; ok {
+			other.owners[key] = slices.Clone(owners)
+		}
+		if readPins, ok := llru.readPins[key]; ok {
+			other.readPins[key] = readPins
+		}
+		if llru.writePins[key] {
+			other.writePins[key] = true
+		}
+		position := other.lockedPositions[key]
+		position.priority = llru.priorityOf(key)
+		other.lockedPositions[key] = position
+	}
+	if deadline, ok := llru.expiresAt[key]; ok {
+		other.expiresAt[key] = deadline
+		other.ttls[key] = llru.ttls[key]
+		other.scheduleExpiry(deadline)
+	} else {
+		other.setTTL(key, 0)
+	}
+	other.updatedAt[key] = llru.updatedAt[key]
+	if history, ok := llru.lockHistories[key]; ok {
+		other.lockHistories[key] = history
+	}
+	if finalizer, ok := llru.finalizers[key]; ok {
+		other.finalizers[key] = finalizer
+		delete(llru.finalizers, key)
+	}
+
+	llru.detach(key)
+	return true
+}
+
+//removes an entry, whether it is locked or unlocked, without calling the eviction callbacks
+func (llru *ThreadunsafeLLRU[K, V]) detach(key K) {
+	if _, locked := llru.deleteLocked(key); locked {
+		llru.forgetLock(key)
+		resizeUnderlyingUnlocked(llru.unlocked, llru.unlockedSize())
+	} else {
+		llru.unlocked.Remove(key)
+	}
+	llru.forget(key)
+	llru.changed()
+}
+
+//calls the callback set by LLRU to wake up the callers waiting in LockCtx, if any
+func (llru *ThreadunsafeLLRU[K, V]) changed() {
+	if llru.onChanged != nil {
+		llru.onChanged()
+	}
+}
+
+//forgets the bookkeeping of an entry which left the cache
+func (llru *ThreadunsafeLLRU[K, V]) forget(key K) {
+	llru.totalWeight -= llru.weights[key]
+	delete(llru.weights, key)
+	delete(llru.updatedAt, key)
+	delete(llru.lockHistories, key)
+	delete(llru.expiresAt, key)
+	delete(llru.ttls, key)
+}
+
+//calls the eviction callbacks
+func (llru *ThreadunsafeLLRU[K, V]) evicted(key K, value V, reason EvictionReason) {
+	history := llru.lockHistories[key]
+	llru.forget(key)
+	llru.changed()
+	if reason == EvictionReasonExpired && llru.onExpired != nil {
+		llru.onExpired(key, value)
+	} else {
+		if llru.onEvicted != nil {
+			llru.onEvicted(key, value)
+		}
+		if llru.onEvictedWithReason != nil {
+			llru.onEvictedWithReason(key, value, reason)
+		}
+		if llru.onEvictedWithHistory != nil {
+			llru.onEvictedWithHistory(key, value, reason, history)
+		}
+	}
+	llru.finalize(key, value)
+	if llru.metrics != nil {
+		llru.metrics.IncEviction(reason)
+	}
+	llru.publish(Event[K, V]{Kind: eventKindOf(reason), Key: key, Value: value, Reason: reason, History: history})
+	if llru.onEvictedBatch != nil {
+		llru.batch = append(llru.batch, Entry[K, V]{Key: key, Value: value})
+		if llru.batchDepth == 0 {
+			llru.flushBatch()
+		}
+	}
+	if llru.batchDepth == 0 {
+		llru.reportLen() //evicted outside of an operation, for instance when Len releases expired entries
+	}
+	if llru.overflow != nil && reason == EvictionReasonCapacity {
+		llru.overflow.Store(key, value)
+	}
+	if llru.evictions != nil {
+		sendOverflowing(llru.evictions, Entry[K, V]{Key: key, Value: value}, llru.evictionsOverflow, &llru.droppedEvictions)
+	}
+}
+
 //modifies the passed LRU to add or update the key/value pair. If a value was evicted, returns it.
-func addOrUpdateUnderlyingUnlocked[K comparable, V any](lru *lru.Cache[K, V], key K, value V) (*Entry[K, V]) {
-	oldestKey, oldestValue, _ := lru.GetOldest() //we can ignore the last parameter, which is false if the lru is empty
-	wasEvicted := lru.Add(key, value)
+func addOrUpdateUnderlyingUnlocked[K comparable, V any](lru *unlockedLRU[K, V], key K, value V, priority int) (*Entry[K, V]) {
+	evicted := lru.AddWithPriority(key, value, priority)
 
-	if wasEvicted {
-		return &Entry[K, V]{Key: oldestKey, Value: oldestValue}
+	if len(evicted) > 0 {
+		return &evicted[0]
 	} else {
 		return nil
 	}
 }
 
-//modifies the passed LRU to change its size. If one item was evicted, it is returned. If more than one is evicted, the oldest is returned
-func resizeUnderlyingUnlocked[K comparable, V any](lru *lru.Cache[K, V], size int) (*Entry[K, V]) {
-	oldestKey, oldestValue, _ := lru.GetOldest() //we can ignore the last parameter, which is false if the lru is empty
-	numberEvicted := lru.Resize(size)
+//modifies the passed LRU to change its size. If one item was evicted, it is returned. If more than one is evicted, the first evicted is returned
+func resizeUnderlyingUnlocked[K comparable, V any](lru *unlockedLRU[K, V], size int) (*Entry[K, V]) {
+	evicted := lru.Resize(size)
 
-	if numberEvicted > 0 {
-		return &Entry[K, V]{Key: oldestKey, Value: oldestValue}
+	if len(evicted) > 0 {
+		return &evicted[0]
 	} else {
 		return nil
 	}
@@ -110,7 +638,7 @@ func collectEntriesFromUnderlyingLocked[K comparable, V any](gmap *gmap.OrderedM
 }
 
 //return array of entries
-func collectEntriesFromUnderlyingUnlocked[K comparable, V any](lru *lru.Cache[K, V]) []Entry[K,V] {
+func collectEntriesFromUnderlyingUnlocked[K comparable, V any](lru *unlockedLRU[K, V]) []Entry[K,V] {
 	keys := lru.Keys()
 	values := lru.Values()
 
@@ -125,144 +653,2327 @@ func collectEntriesFromUnderlyingUnlocked[K comparable, V any](lru *lru.Cache[K,
 
 // Add adds an unlocked value to the cache. 
 // If the key exists and is unlocked, its value is updated, making it the most recently used item, and `true, nil` is returned.
-// If the key exists and is locked, its value is updated and it is unlocked, making it the most recently used item, and `true, nil` is returned.
+// If the key exists and is locked, its value is updated and it is unlocked, regardless of its lock count and pins, making it the most recently used item, and `true, nil` is returned.
 // If the key does not exist and there is room, it is added, making it the most recently used item. If an entry was evicted, `true, entry` is returned, otherwise `true, nil` is returned.
 // If the key does not exist and there is no room, `false, nil` is returned.
+// An entry which already exists keeps its priority, a new entry has priority 0.
+// When SetForbidImplicitUnlock is enabled and the key exists and is locked, it is left unchanged and `false, nil` is returned.
+// If the cache is frozen, the key does not exist, and adding it would evict an entry, `false, nil` is returned.
 func (llru *ThreadunsafeLLRU[K, V]) AddOrUpdateUnlocked(key K, value V) (ok bool, evicted *Entry[K, V]) {
-	llru.locked.Delete(key) //safe to do here, we'll never remove a value and then not have room
+	defer llru.observe(MetricsOpAdd)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+	return llru.addOrUpdateUnlocked(key, value, nil)
+}
+
+// AddOrUpdateUnlockedWithPriority adds or updates an unlocked value, like AddOrUpdateUnlocked, and sets its priority.
+// When an unlocked entry has to be evicted, entries with a lower priority are evicted before entries with a higher
+// priority, regardless of how recently they were used. Among entries with the same priority, the least recently used is
+// evicted first. Entries added without a priority have priority 0. An entry keeps its priority while it is locked.
+func (llru *ThreadunsafeLLRU[K, V]) AddOrUpdateUnlockedWithPriority(key K, value V, priority int) (ok bool, evicted *Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+	return llru.addOrUpdateUnlocked(key, value, &priority)
+}
+
+// AddOrUpdateUnlockedWithTTL adds or updates an unlocked value, like AddOrUpdateUnlocked, and makes it expire once `ttl`
+// has elapsed, instead of using the default lifetime set with SetDefaultTTL. A `ttl` of `0` means the entry never
+// expires. See SetDefaultTTL
+func (llru *ThreadunsafeLLRU[K, V]) AddOrUpdateUnlockedWithTTL(key K, value V, ttl time.Duration) (ok bool, evicted *Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+	ok, evicted = llru.addOrUpdateUnlocked(key, value, nil)
+	if ok {
+		llru.setTTL(key, ttl)
+	}
+	return ok, evicted
+}
+
+//adds or updates an unlocked value. If priority is nil, the entry keeps its current priority
+func (llru *ThreadunsafeLLRU[K, V]) addOrUpdateUnlocked(key K, value V, priority *int) (ok bool, evicted *Entry[K, V]) {
+	if llru.forbidImplicitUnlock {
+		_, locked := llru.locked.Get(key)
+		if locked {
+			return false, nil
+		}
+	}
+	if llru.wouldEvictToAdd(key) {
+		return false, nil
+	}
+	if llru.rejectedByCapacityPolicy(key, false) {
+		return false, nil
+	}
+	if priority == nil {
+		currentPriority := llru.priorityOf(key)
+		priority = &currentPriority
+	}
+	if !llru.fitsWeight(key, value) {
+		return false, nil
+	}
 
-	hasRoom := llru.locked.Len() < llru.size
+	old := llru.peek(key)
+	_, wasLocked := llru.deleteLocked(key) //safe to do here, we'll never remove a value and then not have room
+	llru.forgetLock(key)
+
+	hasRoom := llru.hasRoomBesideLocked()
 	if hasRoom {
 		//in case we did remove from the locked values, resize the locked so we don't unnecessarily evict
-		llru.unlocked.Resize(llru.size - llru.locked.Len())
+		llru.unlocked.Resize(llru.unlockedSize())
 		
-		evicted = addOrUpdateUnderlyingUnlocked(llru.unlocked, key, value)
+		llru.setTTL(key, llru.defaultTTL)
+		llru.setWeight(key, value)
+		llru.updatedAt[key] = llru.clock.Now()
+		evicted = addOrUpdateUnderlyingUnlocked(llru.unlocked, key, value, *priority)
+		if overweight := llru.evictOverweight(); evicted == nil && len(overweight) > 0 {
+			evicted = &overweight[0]
+		}
+		if overWatermark := llru.evictToLowWatermark(); evicted == nil && len(overWatermark) > 0 {
+			evicted = &overWatermark[0]
+		}
+		if wasLocked {
+			llru.notifyUnlocked(key, value)
+		}
+		if old == nil {
+			llru.notifyAdded(key, value, false)
+		} else {
+			llru.notifyUpdated(key, *old, value, wasLocked)
+		}
 	}
 
 	ok = hasRoom
 	return ok, evicted
 }
 
+//returns the priority of a key, whether it is locked or unlocked
+func (llru *ThreadunsafeLLRU[K, V]) priorityOf(key K) int {
+	position, locked := llru.lockedPositions[key]
+	if locked {
+		return position.priority
+	}
+	return llru.unlocked.Priority(key)
+}
+
 
 // Add adds a locked value to the cache. 
-// If the key exists and is locked, its value is updated, and `true, nil` is returned.
+// If the key exists and is locked, its value is updated and its lock count is incremented, and `true, nil` is returned.
 // If the key exists and is unlocked, its value is updated and it is locked, and `true, nil` is returned.
 // If the key does not exist and there is room, it is added, making it the most recently used item. If an entry was evicted, `true, entry` is returned, otherwise `true, nil` is returned.
 // If the key does not exist and there is no room, `false, nil` is returned.
+// If the key is not locked and the maximum number of locked entries is reached, `false, nil` is returned.
+// If the cache is frozen, the key does not exist, and adding it would evict an entry, `false, nil` is returned.
 func (llru *ThreadunsafeLLRU[K, V]) AddOrUpdateLocked(key K, value V) (ok bool, evicted *Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	ok, all := llru.AddOrUpdateLockedAll(key, value)
+	if len(all) > 0 {
+		evicted = &all[0]
+	}
+	return ok, evicted
+}
+
+// AddWithFinalizer adds or updates a value, as AddOrUpdateUnlocked does, or as AddOrUpdateLocked does when `locked` is
+// true, and sets a finalizer which is called once that value leaves the cache, whatever the reason: when it is evicted,
+// removed, purged, expires, or is replaced by another value, for instance to close a file descriptor held by the value.
+// The finalizer is called after the eviction callbacks, with the value it was set for. Replacing the value, even with an
+// equal one, calls it, and the new value only has a finalizer if it is also added with AddWithFinalizer.
+// If the value could not be added, or was evicted by its own add, for instance because every other unlocked entry has a
+// higher priority, the finalizer is not set and the results of the add are returned
+func (llru *ThreadunsafeLLRU[K, V]) AddWithFinalizer(key K, value V, locked bool, finalizer func(key K, value V)) (ok bool, evicted *Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	if locked {
+		ok, evicted = llru.AddOrUpdateLocked(key, value)
+	} else {
+		ok, evicted = llru.AddOrUpdateUnlocked(key, value)
+	}
+	//the add may have evicted the new value itself, which must not leave a finalizer behind for a later value
+	if ok && finalizer != nil && llru.contains(key) {
+		llru.finalizers[key] = finalizer
+	}
+	return ok, evicted
+}
+
+//calls the finalizer of an entry whose value leaves the cache, if it has one
+func (llru *ThreadunsafeLLRU[K, V]) finalize(key K, value V) {
+	finalizer, ok := llru.finalizers[key]
+	if !ok {
+		return
+	}
+	delete(llru.finalizers, key)
+	finalizer(key, value)
+}
+
+// AddOrUpdateLockedAll is the same as AddOrUpdateLocked, but returns every evicted entry, in the order they were evicted,
+// instead of only the first one.
+func (llru *ThreadunsafeLLRU[K, V]) AddOrUpdateLockedAll(key K, value V) (ok bool, evicted []Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	if llru.wouldEvictToAdd(key) || !llru.fitsWeight(key, value) || llru.rejectedByCapacityPolicy(key, true) {
+		return false, nil
+	}
+
+	old := llru.peek(key)
 	//instead of checking if the value already exists, which complicates the capacity check, just remove
-	llru.locked.Delete(key)
+	_, wasLocked := llru.deleteLocked(key)
 
-	hasRoom := llru.locked.Len() < llru.size
+	usesReservation := !wasLocked && llru.reserved > 0
+	hasRoom := usesReservation || (llru.hasRoomBesideLocked() && (wasLocked || llru.hasLockRoom()))
 	if hasRoom {
+		if usesReservation {
+			llru.reserved--
+		}
+		llru.setTTL(key, llru.defaultTTL)
+		llru.setWeight(key, value)
+		llru.updatedAt[key] = llru.clock.Now()
+		if !wasLocked {
+			llru.lockedPositions[key] = llru.positionBeforeLock(key)
+		}
 		llru.unlocked.Remove(key)
-		llru.locked.Set(key, value)
-		evicted = resizeUnderlyingUnlocked(llru.unlocked, llru.size - llru.locked.Len()) //recalculate size of unlocked in case we added a new value
+		llru.setLocked(key, value)
+		evicted = llru.unlocked.Resize(llru.unlockedSize()) //recalculate size of unlocked in case we added a new value
+		evicted = append(evicted, llru.evictOverweight()...)
+		evicted = append(evicted, llru.evictToLowWatermark()...)
+		llru.incrementLockCount(key)
+		if old == nil {
+			llru.notifyAdded(key, value, true)
+		} else {
+			llru.notifyUpdated(key, *old, value, wasLocked)
+		}
+		if !wasLocked {
+			llru.notifyLocked(key, value)
+		}
 	}
 
 	ok = hasRoom
 	return ok, evicted
 }
 
-// Locks an unlocked value in the cache. 
-// If the key exists and is unlocked, it is locked, and `true` is returned
-// If the key exists and is locked, `true` is returned
-// If the key does not exist, returns `false`
-func (llru *ThreadunsafeLLRU[K, V]) Lock(key K) (ok bool) {
-	value, exists := llru.unlocked.Get(key)
-	if !exists {
-		_, exists = llru.locked.Get(key)
-		return exists
-	}
-	llru.unlocked.Remove(key)
-	llru.locked.Set(key, value)
-
-	//resize unlocked
-	resizeUnderlyingUnlocked(llru.unlocked, llru.size - llru.locked.Len())
+// AddUnlockedIfAbsent adds an unlocked value to the cache only if the key does not exist. Unlike AddOrUpdateUnlocked, it
+// never changes the value, recency or lock state of an existing entry.
+// If the key exists, its current value, `false` and `nil` are returned.
+// If the key does not exist and there is room, it is added, making it the most recently used item. If an entry was evicted, `nil, true, entry` is returned, otherwise `nil, true, nil` is returned.
+// If the key does not exist and there is no room, `nil, false, nil` is returned.
+func (llru *ThreadunsafeLLRU[K, V]) AddUnlockedIfAbsent(key K, value V) (current *V, added bool, evicted *Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
 
-	return true
+	current = llru.peek(key)
+	if current != nil {
+		return current, false, nil
+	}
+	added, evicted = llru.addOrUpdateUnlocked(key, value, nil)
+	return nil, added, evicted
 }
 
-// Unlocks a locked value in the cache. 
-// If the key exists and is locked, it is unlocked, making it the most recently used item, and `true` is returned
-// If the key exists and is unlocked, it becomes the most recently used item, and `true` is returned
-// If the key does not exist, returns `false`
-func (llru *ThreadunsafeLLRU[K, V]) Unlock(key K) (ok bool) {
-	value, exists := llru.locked.Get(key)
-	if !exists {
-		_, exists = llru.unlocked.Get(key)
-		return exists
-	}
-	llru.locked.Delete(key)
+// AddMany adds or updates each of the given entries as an unlocked value, as AddOrUpdateUnlocked does, in order, and
+// returns whether each entry was added and the entry it evicted, if any
+func (llru *ThreadunsafeLLRU[K, V]) AddMany(entries []Entry[K, V]) (ok []bool, evicted []*Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	defer llru.startBatch()()
 
-	//grow unlocked to prevent unnecessary eviction prior to adding the new value
-	resizeUnderlyingUnlocked(llru.unlocked, llru.size - llru.locked.Len())
+	ok = make([]bool, len(entries))
+	evicted = make([]*Entry[K, V], len(entries))
+	for i, entry := range entries {
+		ok[i], evicted[i] = llru.AddOrUpdateUnlocked(entry.Key, entry.Value)
+	}
+	return ok, evicted
+}
 
-	llru.unlocked.Add(key, value)
+// GetOrAdd gets the value of a key, like Get2, or adds it as an unlocked value if the key does not exist, in a single
+// call, so that the key cannot be added in between.
+// If the key exists, it is used like with Get2, and its value, `true` and `nil` are returned.
+// If the key does not exist and there is room, it is added, making it the most recently used item. If an entry was evicted, `value, false, entry` is returned, otherwise `value, false, nil` is returned.
+// If the key does not exist and there is no room, it is not added, and `value, false, nil` is returned.
+func (llru *ThreadunsafeLLRU[K, V]) GetOrAdd(key K, value V) (actual V, loaded bool, evicted *Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
 
-	return true
+	actual, loaded = llru.Get2(key)
+	if loaded {
+		return actual, true, nil
+	}
+	_, evicted = llru.addOrUpdateUnlocked(key, value, nil)
+	return value, false, evicted
 }
 
-// If the key exists and is locked, the value is returned
-// If the key exists and is unlocked, it becomes the most recently used item, and the value is returned
-// If the key does not exist, `nil` is returned
-func (llru *ThreadunsafeLLRU[K, V]) Get(key K) (value *V) {
-	val, exists := llru.locked.Get(key)
-	if exists {
-		return &val
-	} else {
-		val, exists = llru.unlocked.Get(key)
-		if exists {
-			return &val
-		} else {
-			return nil
-		}
+// GetOrCompute gets the value of a key, like Get2, or computes it with `compute` and adds it as an unlocked value if the
+// key does not exist, making the cache a read-through cache.
+// If the key exists, its value and `nil` are returned, and `compute` is not called.
+// If the key does not exist, the computed value is added, if there is room, and returned along with `nil`.
+// If `compute` returns an error, nothing is added, and the zero value and the error are returned.
+func (llru *ThreadunsafeLLRU[K, V]) GetOrCompute(key K, compute func() (V, error)) (value V, err error) {
+	value, ok := llru.Get2(key)
+	if ok {
+		return value, nil
+	}
+
+	value, err = compute()
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	if current, _, _ := llru.AddUnlockedIfAbsent(key, value); current != nil {
+		return *current, nil
 	}
+	return value, nil
 }
 
-// If the key exists, true is returned. The recentness of the item is unchanged
-// If the key does not exist, false is returned. 
-func (llru *ThreadunsafeLLRU[K, V]) Contains(key K) bool {
-	inUnlocked := llru.unlocked.Contains(key)
-	if inUnlocked {
-		return inUnlocked
+// Update calls `fn` with the current value of a key, and whether it exists, and stores the value it returns if it also
+// returns `true`, so that a value can be changed based on its previous value without another call changing it in
+// between. A locked entry stays locked, keeping its lock count and pins. An unlocked entry becomes the most recently used
+// item, as with AddOrUpdateUnlocked. The lifetime of the entry restarts, as when it is updated.
+// If `fn` returns `false`, nothing changes and `false, nil` is returned
+// If the key exists and the new value fits, it is stored and `true, nil` is returned, or `true, entry` if an unlocked entry was evicted to respect the weight limit
+// If the key does not exist, it is added as an unlocked value, as AddOrUpdateUnlocked does, and its results are returned
+func (llru *ThreadunsafeLLRU[K, V]) Update(key K, fn func(old V, exists bool) (V, bool)) (ok bool, evicted *Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	old, locked := llru.locked.Get(key)
+	exists := locked
+	if !locked {
+		old, exists = llru.unlocked.Peek(key)
+	}
+	value, store := fn(old, exists)
+	if !store {
+		return false, nil
+	}
+	if !locked {
+		return llru.addOrUpdateUnlocked(key, value, nil)
 	}
-	_, inLocked := llru.locked.Get(key)
 
-	return inLocked
+	if !llru.fitsWeight(key, value) {
+		return false, nil
+	}
+	llru.setLocked(key, value)
+	llru.setTTL(key, llru.defaultTTL)
+	llru.setWeight(key, value)
+	llru.updatedAt[key] = llru.clock.Now()
+	llru.notifyUpdated(key, old, value, true)
+	if overweight := llru.evictOverweight(); len(overweight) > 0 {
+		evicted = &overweight[0]
+	}
+	return true, evicted
 }
 
-// Returns the number of entries
-func (llru *ThreadunsafeLLRU[K, V]) Len() int {
-	return llru.locked.Len() + llru.unlocked.Len()
+// AddOrUpdate adds or updates a value without changing the lock state of an existing entry, unlike AddOrUpdateUnlocked
+// and AddOrUpdateLocked, for instance to refresh a value. It is the same as Update with a function which always returns
+// `value, true`.
+// If the key exists and is locked, its value is updated and it stays locked, keeping its lock count and pins
+// If the key exists and is unlocked, its value is updated, making it the most recently used item
+// If the key does not exist, it is added as an unlocked value, as AddOrUpdateUnlocked does, and its results are returned
+func (llru *ThreadunsafeLLRU[K, V]) AddOrUpdate(key K, value V) (ok bool, evicted *Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	return llru.Update(key, func(old V, exists bool) (V, bool) {
+		return value, true
+	})
 }
 
-// Returns an array of every entry, starting with unlocked from oldest to newest, then locked
-func (llru *ThreadunsafeLLRU[K, V]) Entries() []Entry[K,V] {
-	unlockedEntries := collectEntriesFromUnderlyingUnlocked(llru.unlocked)
-	lockedEntries := collectEntriesFromUnderlyingLocked(llru.locked)
+// AddLockedIfAbsent adds a locked value to the cache only if the key does not exist. Unlike AddOrUpdateLocked, it never
+// changes the value, lock state or lock count of an existing entry.
+// If the key exists, its current value, `false` and `nil` are returned.
+// If the key does not exist and there is room, it is added. If an entry was evicted, `nil, true, entry` is returned, otherwise `nil, true, nil` is returned.
+// If the key does not exist and there is no room, or the maximum number of locked entries is reached, `nil, false, nil` is returned.
+func (llru *ThreadunsafeLLRU[K, V]) AddLockedIfAbsent(key K, value V) (current *V, added bool, evicted *Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	llru.releaseExpired()
 
-	return append(unlockedEntries, lockedEntries...)
+	current = llru.peek(key)
+	if current != nil {
+		return current, false, nil
+	}
+	added, evicted = llru.AddOrUpdateLocked(key, value)
+	return nil, added, evicted
 }
 
-// Returns an array of every value, starting with unlocked from oldest to newest, then locked
-func (llru *ThreadunsafeLLRU[K, V]) Keys() []K {
-	unlockedKeys := llru.unlocked.Keys()
-	lockedKeys := collectKeysFromUnderlyingLocked(llru.locked)
-
-	return append(unlockedKeys, lockedKeys...)
+// Peek returns the value of a key, whether it is locked or unlocked, without changing its recency or lifetime
+// If the key exists, its value and `true` are returned
+// If the key does not exist, the zero value and `false` are returned
+func (llru *ThreadunsafeLLRU[K, V]) Peek(key K) (value V, ok bool) {
+	llru.releaseExpired()
+	return llru.peekValue(key)
 }
 
-// Returns an array of every value, starting with unlocked from oldest to newest, then locked
-func (llru *ThreadunsafeLLRU[K, V]) Values() []V {
-	unlockedValues := llru.unlocked.Values()
-	lockedValues := collectValuesFromUnderlyingLocked(llru.locked)
+func (llru *ThreadunsafeLLRU[K, V]) peekValue(key K) (value V, ok bool) {
+	found := llru.peek(key)
+	if found == nil {
+		return value, false
+	}
+	return *found, true
+}
 
-	return append(unlockedValues, lockedValues...)
+// ContainsOrAdd checks whether a key exists, without changing its recency, and adds it if it does not, as
+// AddUnlockedIfAbsent does, or as AddLockedIfAbsent does when `locked` is true.
+// If the key exists, `true, nil` is returned
+// If the key does not exist, `false` is returned, along with the evicted entry, if any. The key is not added if there is no room
+func (llru *ThreadunsafeLLRU[K, V]) ContainsOrAdd(key K, value V, locked bool) (contained bool, evicted *Entry[K, V]) {
+	_, ok, evicted := llru.PeekOrAdd(key, value, locked)
+	return ok, evicted
 }
 
-func (llru *ThreadunsafeLLRU[K, V]) RemoveOldest() *Entry[K, V] {
-	oldestKey, oldestValue, ok := llru.unlocked.RemoveOldest()
+// PeekOrAdd returns the value of a key, as Peek does, and adds it if it does not exist, as AddUnlockedIfAbsent does, or
+// as AddLockedIfAbsent does when `locked` is true.
+// If the key exists, its value, `true` and `nil` are returned
+// If the key does not exist, the zero value and `false` are returned, along with the evicted entry, if any. The key is not added if there is no room
+func (llru *ThreadunsafeLLRU[K, V]) PeekOrAdd(key K, value V, locked bool) (previous V, ok bool, evicted *Entry[K, V]) {
+	defer llru.observe(MetricsOpAdd)()
+	var current *V
+	if locked {
+		current, _, evicted = llru.AddLockedIfAbsent(key, value)
+	} else {
+		current, _, evicted = llru.AddUnlockedIfAbsent(key, value)
+	}
+	if current != nil {
+		return *current, true, nil
+	}
+	return previous, false, evicted
+}
+
+//calls the hit or miss callback and reports the hit or miss to the metrics sink, if any
+func (llru *ThreadunsafeLLRU[K, V]) countLookup(key K, hit bool) {
+	if hit {
+		if llru.onHit != nil {
+			llru.onHit(key)
+		}
+		if llru.metrics != nil {
+			llru.metrics.IncHit()
+		}
+		return
+	}
+	if llru.onMiss != nil {
+		llru.onMiss(key)
+	}
+	if llru.metrics != nil {
+		llru.metrics.IncMiss()
+	}
+}
+
+//returns the value of a key without changing its recency, or nil if it does not exist
+func (llru *ThreadunsafeLLRU[K, V]) peek(key K) *V {
+	value, exists := llru.locked.Get(key)
+	if !exists {
+		value, exists = llru.unlocked.Peek(key)
+	}
+	if !exists {
+		return nil
+	}
+	return &value
+}
+
+// SetForbidImplicitUnlock chooses whether AddOrUpdateUnlocked may unlock entries. Disabled by default.
+// When disabled, AddOrUpdateUnlocked and AddOrUpdateUnlockedWithPriority unlock a locked entry when they update it.
+// When enabled, they leave a locked entry unchanged and return `false, nil`. IsLocked tells these failures apart from a
+// lack of room. Locked entries can still be unlocked explicitly.
+func (llru *ThreadunsafeLLRU[K, V]) SetForbidImplicitUnlock(enabled bool) {
+	llru.forbidImplicitUnlock = enabled
+}
+
+// SetLockCounting chooses between counted and boolean locks. Lock counting is enabled by default.
+// When enabled, each call to Lock increments the key's lock count and each call to Unlock decrements it. The entry is
+// only unlocked when its count reaches zero.
+// When disabled, locking a locked entry has no effect and a single call to Unlock unlocks it.
+func (llru *ThreadunsafeLLRU[K, V]) SetLockCounting(enabled bool) {
+	llru.countLocks = enabled
+}
+
+//increments the lock count of a locked key. If lock counting is disabled, the count never goes above 1
+func (llru *ThreadunsafeLLRU[K, V]) incrementLockCount(key K) {
+	if llru.countLocks || llru.lockCounts[key] == 0 {
+		llru.lockCounts[key]++
+	}
+}
+
+// SetKeepPositionOnUnlock chooses where unlocked entries go. Disabled by default.
+// When disabled, an entry becomes the most recently used item when it is unlocked.
+// When enabled, an entry goes back to the position it had among the unlocked entries when it was locked. An entry which
+// was added locked goes back to the position it would have had if it had been added unlocked.
+func (llru *ThreadunsafeLLRU[K, V]) SetKeepPositionOnUnlock(enabled bool) {
+	llru.keepPositionOnUnlock = enabled
+}
+
+// SetMaxLocked limits the number of entries which can be locked at the same time, so that some room is always left for
+// unlocked entries. A value of 0, the default, means entries can be locked until the cache is full.
+// Entries which are already locked stay locked if the new limit is lower than the number of locked entries, but no more
+// entries can be locked until enough of them are unlocked.
+func (llru *ThreadunsafeLLRU[K, V]) SetMaxLocked(maxLocked int) {
+	llru.maxLocked = maxLocked
+	llru.changed()
+}
+
+// SetUnlimitedLocked chooses whether locked entries count against the size of the cache. Disabled by default.
+// When enabled, any number of entries can be locked, and the size only bounds the unlocked entries, so locking entries
+// never makes room for unlocked entries scarcer. Disabling it shrinks the room for unlocked entries back to the size minus
+// the number of locked entries, evicting the unlocked entries which no longer fit, and returns them in the order they
+// were evicted.
+func (llru *ThreadunsafeLLRU[K, V]) SetUnlimitedLocked(enabled bool) (evicted []Entry[K, V]) {
+	defer llru.startBatch()()
+
+	llru.unlimitedLocked = enabled
+	llru.changed()
+	return llru.unlocked.Resize(llru.unlockedSize())
+}
+
+//returns the number of unlocked entries which fit in the cache
+func (llru *ThreadunsafeLLRU[K, V]) unlockedSize() int {
+	if llru.size == 0 {
+		return math.MaxInt
+	}
+	if llru.unlimitedLocked {
+		return llru.size
+	}
+	return max(llru.size - llru.locked.Len() - llru.reserved, 0)
+}
+
+// SetCapacityPolicy sets a callback which is consulted whenever adding an entry which does not exist would go over the
+// size of the cache, instead of always evicting. It is called with the number of locked and unlocked entries, and the
+// number of entries the cache would need to hold, and returns EvictDecision, RejectDecision or GrowDecision, for
+// instance `GrowDecision(requested / 10)` to grow the cache by 10%. Pass `nil` to always evict, which is the default
+func (llru *ThreadunsafeLLRU[K, V]) SetCapacityPolicy(capacityPolicy func(lockedLen, unlockedLen, requested int) Decision) {
+	llru.capacityPolicy = capacityPolicy
+}
+
+//consults the capacity policy if adding the key would go over the size, growing the cache if it decides so. Returns
+//whether the add must be refused
+func (llru *ThreadunsafeLLRU[K, V]) rejectedByCapacityPolicy(key K, locking bool) bool {
+	if llru.capacityPolicy == nil || llru.size == 0 || llru.frozen || llru.Contains(key) {
+		return false
+	}
+
+	lockedLen, unlockedLen := llru.locked.Len(), llru.unlocked.Len()
+	overCapacity := unlockedLen >= llru.unlockedSize()
+	if locking {
+		overCapacity = !llru.unlimitedLocked && llru.reserved == 0 && lockedLen + unlockedLen >= llru.size
+	}
+	if !overCapacity {
+		return false
+	}
+
+	decision := llru.capacityPolicy(lockedLen, unlockedLen, lockedLen + unlockedLen + 1)
+	switch decision.action {
+	case decisionReject:
+		return true
+	case decisionGrow:
+		if decision.growth > 0 {
+			llru.size += decision.growth
+			llru.unlocked.Resize(llru.unlockedSize())
+		}
+	}
+	return false
+}
+
+//returns whether the locked entries leave room for at least one more entry
+func (llru *ThreadunsafeLLRU[K, V]) hasRoomBesideLocked() bool {
+	return llru.size == 0 || llru.unlimitedLocked || llru.locked.Len() + llru.reserved < llru.size
+}
+
+//returns whether another entry can be locked without going over the maximum number of locked entries
+func (llru *ThreadunsafeLLRU[K, V]) hasLockRoom() bool {
+	return llru.maxLocked <= 0 || llru.locked.Len() + llru.reserved < llru.maxLocked
+}
+
+// Reserve keeps room for `n` locked entries to be added, evicting unlocked entries right away if needed, and returns
+// them in the order they were evicted. Each AddOrUpdateLocked of a key which is not already locked then uses up one
+// reservation, and is guaranteed to succeed without evicting anything, as long as it fits the weight limit. Unlocked
+// entries can never take the reserved room. Reservations add up, until they are used up or cancelled with Release.
+// If there is not enough room, even once every unlocked entry is evicted, or not enough entries can be locked because of
+// SetMaxLocked, ErrNotEnoughRoom is returned and nothing changes
+// If the cache is frozen and reserving would evict entries, ErrFrozen is returned and nothing changes
+func (llru *ThreadunsafeLLRU[K, V]) Reserve(n int) (evicted []Entry[K, V], err error) {
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	if n < 0 {
+		return nil, errors.New("must not reserve a negative number of entries")
+	}
+	bounded := llru.size > 0 && !llru.unlimitedLocked
+	if bounded && llru.locked.Len() + llru.reserved + n > llru.size {
+		return nil, ErrNotEnoughRoom
+	}
+	if llru.maxLocked > 0 && llru.locked.Len() + llru.reserved + n > llru.maxLocked {
+		return nil, ErrNotEnoughRoom
+	}
+
+	llru.reserved += n
+	if llru.frozen && llru.unlocked.Len() > llru.unlockedSize() {
+		llru.reserved -= n
+		return nil, ErrFrozen
+	}
+	return llru.unlocked.Resize(llru.unlockedSize()), nil
+}
+
+// Release cancels every reservation taken with Reserve which was not used up yet, giving the room back to unlocked
+// entries
+func (llru *ThreadunsafeLLRU[K, V]) Release() {
+	llru.reserved = 0
+	llru.unlocked.Resize(llru.unlockedSize())
+	llru.changed()
+}
+
+// SetPinOnGet chooses whether Get locks the entries it returns. Disabled by default.
+// When enabled, every successful call to Get locks the entry, and the caller must call Unlock once it is done with the
+// value. Acquire does the same and returns a lease which unlocks the entry when closed.
+func (llru *ThreadunsafeLLRU[K, V]) SetPinOnGet(enabled bool) {
+	llru.pinOnGet = enabled
+}
+
+// SetEvictCallback sets the callback which is called whenever an entry is evicted or removed, replacing the one passed
+// to NewUnsafeWithEvict, if any, for instance when the consumer of the evictions is created after the cache. Pass `nil`
+// to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetEvictCallback(onEvicted func(key K, value V)) {
+	llru.onEvicted = onEvicted
+}
+
+// Hooks returns every callback of the cache, for instance to replace one of them with SetHooks while keeping the others
+func (llru *ThreadunsafeLLRU[K, V]) Hooks() Hooks[K, V] {
+	return Hooks[K, V]{
+		OnAdd: llru.onAdd,
+		OnUpdate: llru.onUpdate,
+		OnHit: llru.onHit,
+		OnMiss: llru.onMiss,
+		OnLock: llru.onLock,
+		OnUnlock: llru.onUnlock,
+		OnAutoUnlock: llru.onAutoUnlock,
+		OnEvicted: llru.onEvicted,
+		OnEvictedWithReason: llru.onEvictedWithReason,
+		OnEvictedWithHistory: llru.onEvictedWithHistory,
+		OnEvictedBatch: llru.onEvictedBatch,
+		OnExpired: llru.onExpired,
+		OnMisuse: llru.onMisuse,
+	}
+}
+
+// SetHooks replaces every callback of the cache at once, including the eviction callback passed to NewUnsafeWithEvict.
+// Callbacks left `nil` in `hooks` are removed. Subscribers are not affected
+func (llru *ThreadunsafeLLRU[K, V]) SetHooks(hooks Hooks[K, V]) {
+	llru.onAdd = hooks.OnAdd
+	llru.onUpdate = hooks.OnUpdate
+	llru.onHit = hooks.OnHit
+	llru.onMiss = hooks.OnMiss
+	llru.onLock = hooks.OnLock
+	llru.onUnlock = hooks.OnUnlock
+	llru.onAutoUnlock = hooks.OnAutoUnlock
+	llru.onEvicted = hooks.OnEvicted
+	llru.onEvictedWithReason = hooks.OnEvictedWithReason
+	llru.onEvictedWithHistory = hooks.OnEvictedWithHistory
+	llru.onEvictedBatch = hooks.OnEvictedBatch
+	llru.onExpired = hooks.OnExpired
+	llru.onMisuse = hooks.OnMisuse
+}
+
+// SetOnEvictedWithReason sets a callback which is called whenever an entry is evicted or removed, along with the reason.
+// It is called in addition to the callback passed to NewUnsafeWithEvict, if any. Pass `nil` to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnEvictedWithReason(onEvicted func(key K, value V, reason EvictionReason)) {
+	llru.onEvictedWithReason = onEvicted
+}
+
+// SetOnEvictedWithHistory sets a callback which is called whenever an entry is evicted or removed, along with the reason
+// and its lock history, for instance to find out which entries keep being evicted soon after they are unlocked. It is
+// called in addition to the other eviction callbacks. Pass `nil` to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnEvictedWithHistory(onEvicted func(key K, value V, reason EvictionReason, history LockHistory)) {
+	llru.onEvictedWithHistory = onEvicted
+}
+
+// SetOnEvictedBatch sets a callback which is called once per operation with every entry evicted or removed by that
+// operation, in the order they were evicted, for instance to handle them in a single database transaction. It is not
+// called for operations which evicted nothing. It is called in addition to the other eviction callbacks. Pass `nil` to
+// remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnEvictedBatch(onEvictedBatch func(entries []Entry[K, V])) {
+	llru.onEvictedBatch = onEvictedBatch
+}
+
+//starts an operation whose evicted entries are passed together to the batch eviction callback. The returned function
+//ends it. Operations started while another one is running are part of it
+func (llru *ThreadunsafeLLRU[K, V]) startBatch() (end func()) {
+	llru.batchDepth++
+	return func() {
+		llru.batchDepth--
+		if llru.batchDepth == 0 {
+			llru.flushBatch()
+			llru.reportLen()
+		}
+	}
+}
+
+//passes the entries evicted by the current operation to the batch eviction callback
+func (llru *ThreadunsafeLLRU[K, V]) flushBatch() {
+	if len(llru.batch) == 0 {
+		return
+	}
+	batch := llru.batch
+	llru.batch = nil
+	if llru.onEvictedBatch != nil {
+		llru.onEvictedBatch(batch)
+	}
+}
+
+// SetMetrics sets a sink which the cache reports to, so that its activity can be bridged to a metrics system such as
+// statsd, Prometheus or OpenTelemetry. See MetricsSink. Pass `nil` to stop reporting, which is the default
+func (llru *ThreadunsafeLLRU[K, V]) SetMetrics(metrics MetricsSink) {
+	llru.metrics = metrics
+}
+
+//starts measuring the latency of an operation. The returned function reports it to the metrics sink, if any.
+//Operations started while another one is running are part of it, and are not reported separately
+func (llru *ThreadunsafeLLRU[K, V]) observe(op string) (end func()) {
+	if llru.metrics == nil || llru.observing {
+		return func() {}
+	}
+	llru.observing = true
+	start := time.Now()
+	return func() {
+		llru.observing = false
+		llru.metrics.ObserveLatency(op, time.Since(start))
+	}
+}
+
+//reports the number of entries to the metrics sink, if any
+func (llru *ThreadunsafeLLRU[K, V]) reportLen() {
+	if llru.metrics != nil {
+		llru.metrics.SetLen(llru.locked.Len() + llru.unlocked.Len())
+	}
+}
+
+// SetVictimScore sets a function which scores unlocked entries when one must be evicted, given the key, the value and
+// the time since the entry was last added, updated or read. The entry with the lowest score is evicted instead of the
+// least recently used one, for instance to keep the values which are the most expensive to recompute. Priorities still
+// come first, and entries with the same score are evicted oldest first. Every unlocked entry is scored on each
+// eviction, so the function should be cheap. Pass `nil` to go back to the policy the cache was created with
+func (llru *ThreadunsafeLLRU[K, V]) SetVictimScore(score func(key K, value V, age time.Duration) float64) {
+	llru.unlocked.score = score
+}
+
+// SetMinResidency sets a grace period during which a newly added unlocked entry is not evicted, unless every unlocked
+// entry is within its grace period, so that bursts of additions do not evict entries right after they were added. An
+// entry is resident from the time it is added or unlocked; updating it does not restart its grace period. Evicting
+// walks the unlocked entries from the oldest and stops at the first one past its grace period, so it is slower while
+// many entries are within theirs. Pass `0` to disable it, which is the default
+func (llru *ThreadunsafeLLRU[K, V]) SetMinResidency(minResidency time.Duration) {
+	llru.unlocked.minResidency = minResidency
+}
+
+// SetOverflowHandler sets a second cache tier which receives the unlocked entries evicted for lack of room, and which
+// Get falls back to when a key is not in the cache. Pass `nil` to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOverflowHandler(handler OverflowHandler[K, V]) {
+	llru.overflow = handler
+}
+
+// Evictions returns a channel which receives every entry evicted or removed from now on, so that they can be handled
+// asynchronously instead of inside an eviction callback. The same channel is returned on every call.
+// The channel holds up to EvictionsBufferSize entries. By default, sending never blocks: when the buffer is full, the
+// entry is dropped and counted in DroppedEvictions. See SetEvictionsOverflow to change this. The channel is never closed
+func (llru *ThreadunsafeLLRU[K, V]) Evictions() <-chan Entry[K, V] {
+	if llru.evictions == nil {
+		llru.evictions = make(chan Entry[K, V], EvictionsBufferSize)
+	}
+	return llru.evictions
+}
+
+// SetEvictionsOverflow tells what happens to an entry sent to the channel returned by Evictions when its buffer is full:
+// with EventsDropNewest, the default, the entry is dropped, and with EventsDropOldest, the oldest buffered entry is
+// dropped to make room for it. Either way, the dropped entries are counted in DroppedEvictions. With EventsBlock, no
+// entry is dropped, but the operation which evicted it waits until the receiver makes room
+func (llru *ThreadunsafeLLRU[K, V]) SetEvictionsOverflow(overflow EventsOverflow) {
+	llru.evictionsOverflow = overflow
+}
+
+// DroppedEvictions returns the number of evicted or removed entries which could not be sent to the channel returned by
+// Evictions because its buffer was full
+func (llru *ThreadunsafeLLRU[K, V]) DroppedEvictions() uint64 {
+	return llru.droppedEvictions
+}
+
+// SetClock sets the source of the current time used for lifetimes, timed locks, minimum residency and the ages passed
+// to the victim score function, for instance to control time in tests. Deadlines already set are kept as they are, so it
+// should be called before the cache is used. Pass `nil` to use the actual time, which is the default
+func (llru *ThreadunsafeLLRU[K, V]) SetClock(clock Clock) {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	llru.clock = clock
+	llru.unlocked.now = clock.Now
+}
+
+// SetWeigher limits the total cost of the entries, locked and unlocked, instead of only their number, for instance to
+// limit the memory used by values of very different sizes. `weigher` returns the cost of an entry, and `maxWeight` is the
+// maximum total cost. Unlocked entries are evicted until the total cost fits, in the same order as when room is needed
+// for a new entry. Adding or updating an entry fails if it does not fit even once every unlocked entry is evicted. The
+// size passed at construction still limits the number of entries. Existing entries are weighed, and evicted if needed,
+// right away. Pass a `nil` weigher to stop limiting the total cost
+func (llru *ThreadunsafeLLRU[K, V]) SetWeigher(weigher func(key K, value V) int, maxWeight int) {
+	defer llru.startBatch()()
+
+	llru.weigher = weigher
+	llru.maxWeight = maxWeight
+	clear(llru.weights)
+	llru.totalWeight = 0
+	llru.lockedWeight = 0
+	if weigher == nil {
+		return
+	}
+	for _, entry := range collectEntriesFromUnderlyingLocked(llru.locked) {
+		llru.setWeight(entry.Key, entry.Value)
+	}
+	for _, entry := range collectEntriesFromUnderlyingUnlocked(llru.unlocked) {
+		llru.setWeight(entry.Key, entry.Value)
+	}
+	llru.evictOverweight()
+}
+
+// Weight returns the total cost of the entries, as computed by the function set with SetWeigher, or 0 if none is set
+func (llru *ThreadunsafeLLRU[K, V]) Weight() int {
+	llru.releaseExpired()
+	return llru.totalWeight
+}
+
+// ByteSizeWeigher is a weigher for SetWeigher which weighs entries by the length in bytes of their string or []byte
+// value, so that the total size of the values can be capped, for instance with
+// `llru.SetWeigher(ByteSizeWeigher[string, []byte], 256<<20)`
+func ByteSizeWeigher[K comparable, V ~string | ~[]byte](key K, value V) int {
+	return len(value)
+}
+
+// LenBytes returns the total length in bytes of the values which are strings or byte slices, including named types
+// such as `type Blob []byte`, as ByteSizeWeigher weighs them. Other values count for 0
+func (llru *ThreadunsafeLLRU[K, V]) LenBytes() int {
+	llru.releaseExpired()
+	return llru.lenBytes()
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) lenBytes() int {
+	total := 0
+	for _, value := range llru.values() {
+		switch v := any(value).(type) {
+		case string:
+			total += len(v)
+		case []byte:
+			total += len(v)
+		default:
+			total += byteLen(v)
+		}
+	}
+	return total
+}
+
+//returns the length of a value whose type is a named string or byte slice type, or 0 for other values
+func byteLen(value any) int {
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Kind() == reflect.String:
+		return v.Len()
+	case v.Kind() == reflect.Slice && v.Type().Elem() == reflect.TypeOf(byte(0)):
+		return v.Len()
+	}
+	return 0
+}
+
+//records the cost of an entry which is added or updated
+func (llru *ThreadunsafeLLRU[K, V]) setWeight(key K, value V) {
+	if llru.weigher == nil {
+		return
+	}
+	weight := llru.weigher(key, value)
+	llru.totalWeight += weight - llru.weights[key]
+	if _, locked := llru.locked.Get(key); locked {
+		llru.lockedWeight += weight - llru.weights[key]
+	}
+	llru.weights[key] = weight
+}
+
+//adds or updates a locked entry, keeping track of the cost of the locked entries
+func (llru *ThreadunsafeLLRU[K, V]) setLocked(key K, value V) {
+	if _, present := llru.locked.Set(key, value); !present {
+		llru.lockedWeight += llru.weights[key]
+	}
+}
+
+//removes a locked entry, keeping track of the cost of the locked entries. Returns its value and whether it was locked
+func (llru *ThreadunsafeLLRU[K, V]) deleteLocked(key K) (value V, locked bool) {
+	value, locked = llru.locked.Delete(key)
+	if locked {
+		llru.lockedWeight -= llru.weights[key]
+	}
+	return value, locked
+}
+
+//returns whether an entry fits within the maximum total cost once every unlocked entry, and its own previous value, is
+//evicted
+func (llru *ThreadunsafeLLRU[K, V]) fitsWeight(key K, value V) bool {
+	if llru.weigher == nil {
+		return true
+	}
+	lockedWeight := llru.lockedWeight
+	if _, locked := llru.locked.Get(key); locked {
+		lockedWeight -= llru.weights[key]
+	}
+	return lockedWeight + llru.weigher(key, value) <= llru.maxWeight
+}
+
+//evicts unlocked entries until the total cost fits. Returns the evicted entries
+func (llru *ThreadunsafeLLRU[K, V]) evictOverweight() (evicted []Entry[K, V]) {
+	for llru.weigher != nil && !llru.frozen && llru.totalWeight > llru.maxWeight && llru.unlocked.Len() > 0 {
+		evicted = append(evicted, llru.unlocked.EvictN(1, EvictionReasonCapacity)...)
+	}
+	return evicted
+}
+
+// SetWatermarks makes adding entries evict unlocked entries in batches: once an add brings the number of entries, locked
+// and unlocked, to `high`, the least recently used unlocked entries are evicted until only `low` entries are left, with
+// EvictionReasonCapacity, instead of evicting a single entry on every add once the cache is full. Locked entries are
+// never evicted, so fewer than `high - low` entries may be evicted. Setting `high` to the size of the cache only starts
+// evicting once it is full. Pass `0, 0` to disable it, which is the default
+// If `low` is negative or is not smaller than `high`, an error is returned and nothing changes
+func (llru *ThreadunsafeLLRU[K, V]) SetWatermarks(low, high int) error {
+	if (low != 0 || high != 0) && (low < 0 || low >= high) {
+		return errors.New("invalid watermarks")
+	}
+
+	llru.lowWatermark = low
+	llru.highWatermark = high
+	return nil
+}
+
+//evicts unlocked entries down to the low watermark once the high watermark is reached. Returns the evicted entries
+func (llru *ThreadunsafeLLRU[K, V]) evictToLowWatermark() (evicted []Entry[K, V]) {
+	length := llru.locked.Len() + llru.unlocked.Len()
+	if llru.highWatermark <= 0 || llru.frozen || length < llru.highWatermark {
+		return nil
+	}
+	return llru.unlocked.EvictN(length - llru.lowWatermark, EvictionReasonCapacity)
+}
+
+// SetDefaultTTL sets the lifetime of entries: an entry expires once `ttl` has elapsed since it was last added or updated,
+// unless it was given another lifetime with AddOrUpdateUnlockedWithTTL. It only applies to entries added or updated
+// afterwards. Expired entries are removed lazily, the next time the cache is used, and the eviction callbacks are called
+// with EvictionReasonExpired. Locked entries only expire once unlocked, unless SetExpireLocked is enabled. Pass `0` to
+// disable expiration, which is the default
+func (llru *ThreadunsafeLLRU[K, V]) SetDefaultTTL(ttl time.Duration) {
+	llru.defaultTTL = ttl
+}
+
+//sets the lifetime of an entry, or removes it if `ttl` is not positive
+func (llru *ThreadunsafeLLRU[K, V]) setTTL(key K, ttl time.Duration) {
+	if ttl <= 0 {
+		delete(llru.expiresAt, key)
+		delete(llru.ttls, key)
+		return
+	}
+	jittered := ttl
+	if llru.ttlJitter > 0 {
+		jittered += time.Duration((rand.Float64()*2 - 1) * llru.ttlJitter * float64(ttl))
+	}
+	deadline := llru.clock.Now().Add(jittered)
+	llru.expiresAt[key] = deadline
+	llru.ttls[key] = ttl
+	llru.scheduleExpiry(deadline)
+}
+
+// SetTTLJitter makes every lifetime randomly shorter or longer by up to `jitter` times its duration, so that entries
+// added at the same time do not all expire, and get refreshed, at the same time. For instance, with a jitter of 0.1, a
+// 10 minute lifetime lasts between 9 and 11 minutes. The jitter is applied whenever a lifetime starts or restarts.
+// Values are clamped between 0 and 1. Pass `0` to disable it, which is the default
+func (llru *ThreadunsafeLLRU[K, V]) SetTTLJitter(jitter float64) {
+	llru.ttlJitter = min(max(jitter, 0), 1)
+}
+
+// SetExpireAfterAccess makes the lifetime of entries restart whenever they are read with Get or GetAndLock, so that
+// entries expire once they have not been used for their lifetime, for instance to expire sessions 30 minutes after they
+// were last used. When disabled, which is the default, entries expire once their lifetime has elapsed since they were
+// last added or updated. See SetDefaultTTL
+func (llru *ThreadunsafeLLRU[K, V]) SetExpireAfterAccess(enabled bool) {
+	llru.expireAfterAccess = enabled
+}
+
+//restarts the lifetime of an entry which was read, if lifetimes restart on access
+func (llru *ThreadunsafeLLRU[K, V]) accessed(key K) {
+	if ttl, ok := llru.ttls[key]; ok && llru.expireAfterAccess {
+		llru.setTTL(key, ttl)
+	}
+}
+
+// ResetTTL restarts the lifetime of an entry, making it expire once `ttl` has elapsed, without changing its value or
+// recency. A `ttl` of `0` makes the entry never expire. Later writes, and reads if SetExpireAfterAccess is enabled,
+// restart its lifetime with the new `ttl`
+// If the key exists, `true` is returned
+// If the key does not exist, `false` is returned
+func (llru *ThreadunsafeLLRU[K, V]) ResetTTL(key K, ttl time.Duration) (ok bool) {
+	llru.releaseExpired()
+
+	if llru.peek(key) == nil {
+		return false
+	}
+	llru.setTTL(key, ttl)
+	return true
+}
+
+// ExtendTTL postpones the expiration of an entry by `extension`, without changing its value or recency. It has no
+// effect on entries which never expire
+// If the key exists, `true` is returned
+// If the key does not exist, `false` is returned
+func (llru *ThreadunsafeLLRU[K, V]) ExtendTTL(key K, extension time.Duration) (ok bool) {
+	llru.releaseExpired()
+
+	if llru.peek(key) == nil {
+		return false
+	}
+	if deadline, expires := llru.expiresAt[key]; expires {
+		llru.expiresAt[key] = deadline.Add(extension)
+		llru.scheduleExpiry(llru.expiresAt[key])
+	}
+	return true
+}
+
+//makes sure expired entries are looked for once the deadline has passed
+func (llru *ThreadunsafeLLRU[K, V]) scheduleExpiry(deadline time.Time) {
+	if llru.nextExpiry.IsZero() || deadline.Before(llru.nextExpiry) {
+		llru.nextExpiry = deadline
+	}
+}
+
+// SetOnExpired sets a callback which is called whenever an entry expires, instead of the callback passed to
+// NewUnsafeWithEvict and the one set with SetOnEvictedWithReason, so that entries which aged out can be handled
+// differently from entries which were evicted or removed. When it is not set, expired entries are passed to the eviction
+// callbacks with EvictionReasonExpired. Pass `nil` to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnExpired(onExpired func(key K, value V)) {
+	llru.onExpired = onExpired
+}
+
+// SetExpireLocked sets whether locked entries expire. When disabled, which is the default, a locked entry never expires,
+// so that entries in use cannot vanish, and an entry whose lifetime elapsed while it was locked expires once it is
+// unlocked. When enabled, locked entries expire like unlocked ones, regardless of their lock counts and pins, as
+// ForceRemove would remove them, so whoever locked them will find them missing
+func (llru *ThreadunsafeLLRU[K, V]) SetExpireLocked(enabled bool) {
+	llru.expireLocked = enabled
+	for _, deadline := range llru.expiresAt {
+		llru.scheduleExpiry(deadline)
+	}
+}
+
+// RemoveExpired removes every entry whose lifetime has elapsed, earliest deadline first, and returns them. Expired
+// entries are also removed lazily whenever the cache is used, or by a janitor, so this only needs to be called to
+// control when they are removed, for instance during idle periods.
+// If the cache is frozen, nothing is removed and `nil` is returned
+func (llru *ThreadunsafeLLRU[K, V]) RemoveExpired() []Entry[K, V] {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+	llru.releaseExpiredLocks()
+	return llru.removeExpired()
+}
+
+//returns whether an entry can expire now, depending on whether it is locked
+func (llru *ThreadunsafeLLRU[K, V]) canExpire(key K) bool {
+	return llru.expireLocked || llru.unlocked.Contains(key)
+}
+
+//removes every entry whose lifetime has elapsed, earliest deadline first, and returns them. Locked entries are only
+//removed if SetExpireLocked is enabled
+func (llru *ThreadunsafeLLRU[K, V]) removeExpired() (removed []Entry[K, V]) {
+	now := llru.clock.Now()
+	if llru.frozen || llru.nextExpiry.IsZero() || now.Before(llru.nextExpiry) {
+		return nil
+	}
+
+	expired := []K{}
+	llru.nextExpiry = time.Time{}
+	for key, deadline := range llru.expiresAt {
+		if !llru.canExpire(key) {
+			continue
+		}
+		if deadline.After(now) {
+			llru.scheduleExpiry(deadline)
+		} else {
+			expired = append(expired, key)
+		}
+	}
+	slices.SortFunc(expired, func(a, b K) int {
+		return llru.expiresAt[a].Compare(llru.expiresAt[b])
+	})
+
+	for _, key := range expired {
+		value, locked := llru.deleteLocked(key)
+		if locked {
+			llru.forgetLock(key)
+			resizeUnderlyingUnlocked(llru.unlocked, llru.unlockedSize())
+		} else {
+			value, _ = llru.unlocked.Peek(key)
+			llru.unlocked.Remove(key)
+		}
+		llru.evicted(key, value, EvictionReasonExpired)
+		removed = append(removed, Entry[K, V]{Key: key, Value: value})
+	}
+	return removed
+}
+
+// SetOnAutoUnlock sets a callback which is called whenever a timed lock set with LockFor expires and is released.
+// It is called even if the entry stays locked because of other locks. Pass `nil` to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnAutoUnlock(onAutoUnlock func(key K, value V)) {
+	llru.onAutoUnlock = onAutoUnlock
+}
+
+// SetOnMisuse enables misuse detection, for debugging. Disabled by default.
+// When enabled, the callback is called, with a stack trace, whenever Unlock is called on an entry which is not locked,
+// RUnlock or WUnlock on an entry which has no such pin, or UnlockAs by an owner which holds no lock on the entry. The
+// return values of these methods are unchanged. Pass `nil` to disable it. Collecting stack traces is slow, so this should
+// not be enabled in production.
+func (llru *ThreadunsafeLLRU[K, V]) SetOnMisuse(onMisuse func(misuse Misuse[K])) {
+	llru.onMisuse = onMisuse
+}
+
+//reports a misuse, if misuse detection is enabled
+func (llru *ThreadunsafeLLRU[K, V]) reportMisuse(key K, reason string) {
+	if llru.onMisuse != nil {
+		llru.onMisuse(Misuse[K]{Key: key, Reason: reason, Stack: debug.Stack()})
+	}
+}
+
+// SetOnAdd sets a callback which is called whenever a key which did not exist is added, with whether it was added
+// locked, for instance to audit the first time a key is seen. It is not called when an existing entry is updated, even
+// if its lock state changes. Pass `nil` to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnAdd(onAdd func(key K, value V, locked bool)) {
+	llru.onAdd = onAdd
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) notifyAdded(key K, value V, locked bool) {
+	llru.changed()
+	if llru.onAdd != nil {
+		llru.onAdd(key, value, locked)
+	}
+	llru.publish(Event[K, V]{Kind: EventAdd, Key: key, Value: value, Locked: locked})
+}
+
+// SetOnUpdate sets a callback which is called whenever the value of an existing entry is replaced, by AddOrUpdateUnlocked,
+// AddOrUpdateLocked, AddOrUpdate, Update or any of the methods adding values like they do, with the value it replaced
+// and whether the entry was locked before, for instance to release resources owned by the old value. It is called even
+// if the new value is the same as the old one. The eviction callbacks are not called for replaced values. Pass `nil` to
+// remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnUpdate(onUpdate func(key K, oldValue V, newValue V, wasLocked bool)) {
+	llru.onUpdate = onUpdate
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) notifyUpdated(key K, oldValue V, newValue V, wasLocked bool) {
+	llru.finalize(key, oldValue)
+	if llru.onUpdate != nil {
+		llru.onUpdate(key, oldValue, newValue, wasLocked)
+	}
+	llru.publish(Event[K, V]{Kind: EventUpdate, Key: key, Value: newValue, OldValue: oldValue, Locked: wasLocked})
+}
+
+// Subscribe adds a subscriber which is called with every event of the given kinds, or of every kind if none is given,
+// for instance so that several components can observe the same cache without sharing a callback. Subscribers are called
+// in the order they subscribed, after the callbacks. Returns the subscription, to pass to Unsubscribe
+func (llru *ThreadunsafeLLRU[K, V]) Subscribe(fn func(event Event[K, V]), kinds ...EventKind) Subscription {
+	llru.lastSubscription++
+	llru.subscribers = append(llru.subscribers, subscriber[K, V]{id: llru.lastSubscription, kinds: slices.Clone(kinds), fn: fn})
+	return llru.lastSubscription
+}
+
+// SubscribeChan adds a subscriber which sends every event of the given kinds, or of every kind if none is given, to the
+// returned channel, so that events can be handled by another goroutine. The channel holds up to `bufferSize` events,
+// and `overflow` tells what happens to an event sent when the buffer is full: with EventsDropNewest or EventsDropOldest,
+// a slow receiver never holds up the cache, and the dropped events are counted in DroppedEvents. With EventsBlock, no
+// event is dropped but every operation waits for the receiver, so the receiver must not call methods of the cache. The
+// channel is closed by Unsubscribe. Returns the channel and the subscription, to pass to Unsubscribe
+func (llru *ThreadunsafeLLRU[K, V]) SubscribeChan(bufferSize int, overflow EventsOverflow, kinds ...EventKind) (<-chan Event[K, V], Subscription) {
+	events := make(chan Event[K, V], max(bufferSize, 0))
+	dropped := new(uint64)
+	llru.lastSubscription++
+	llru.subscribers = append(llru.subscribers, subscriber[K, V]{
+		id: llru.lastSubscription,
+		kinds: slices.Clone(kinds),
+		fn: sendEvents(events, overflow, dropped),
+		events: events,
+		dropped: dropped,
+	})
+	return events, llru.lastSubscription
+}
+
+// DroppedEvents returns the number of events which could not be sent to the channel of a subscription added with
+// SubscribeChan because its buffer was full. Returns `0` for other or removed subscriptions
+func (llru *ThreadunsafeLLRU[K, V]) DroppedEvents(subscription Subscription) uint64 {
+	for _, s := range llru.subscribers {
+		if s.id == subscription && s.dropped != nil {
+			return *s.dropped
+		}
+	}
+	return 0
+}
+
+// Unsubscribe removes a subscriber added with Subscribe or SubscribeChan, closing the channel of the latter
+// Returns `false` if the subscription was already removed
+func (llru *ThreadunsafeLLRU[K, V]) Unsubscribe(subscription Subscription) (ok bool) {
+	i := slices.IndexFunc(llru.subscribers, func(s subscriber[K, V]) bool {
+		return s.id == subscription
+	})
+	if i < 0 {
+		return false
+	}
+	if events := llru.subscribers[i].events; events != nil {
+		close(events)
+	}
+	llru.subscribers = slices.Delete(slices.Clone(llru.subscribers), i, i+1) //publish may be iterating over the current slice
+	return true
+}
+
+//passes an event to every subscriber which wants it
+func (llru *ThreadunsafeLLRU[K, V]) publish(event Event[K, V]) {
+	for _, s := range llru.subscribers {
+		if s.wants(event.Kind) {
+			s.fn(event)
+		}
+	}
+}
+
+// SetOnHit sets a callback which is called whenever Get, or any of the methods getting values like it does, such as Get2,
+// GetMany, GetOrAdd and GetOrCompute, finds a key in the cache, for instance to count hits in a metrics system. Pass
+// `nil` to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnHit(onHit func(key K)) {
+	llru.onHit = onHit
+}
+
+// SetOnMiss sets a callback which is called whenever Get, or any of the methods getting values like it does, does not
+// find a key in the cache, including when the value is then loaded from the overflow handler, or finds a key which it
+// cannot pin for lack of lock room when SetPinOnGet is enabled. Pass `nil` to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnMiss(onMiss func(key K)) {
+	llru.onMiss = onMiss
+}
+
+// SetOnLock sets a callback which is called whenever an entry becomes locked, including when it is added locked.
+// It is not called when the lock count of an entry which is already locked is incremented. Pass `nil` to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnLock(onLock func(key K, value V)) {
+	llru.onLock = onLock
+}
+
+// SetOnUnlock sets a callback which is called whenever an entry becomes unlocked.
+// It is not called when the lock count of an entry which stays locked is decremented. Pass `nil` to remove it
+func (llru *ThreadunsafeLLRU[K, V]) SetOnUnlock(onUnlock func(key K, value V)) {
+	llru.onUnlock = onUnlock
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) notifyLocked(key K, value V) {
+	history := llru.lockHistories[key]
+	history.EverLocked = true
+	llru.lockHistories[key] = history
+	if llru.onLock != nil {
+		llru.onLock(key, value)
+	}
+	llru.publish(Event[K, V]{Kind: EventLock, Key: key, Value: value})
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) notifyUnlocked(key K, value V) {
+	if deadline, ok := llru.expiresAt[key]; ok {
+		llru.scheduleExpiry(deadline)
+	}
+	llru.changed()
+	history := llru.lockHistories[key]
+	history.LastUnlocked = llru.clock.Now()
+	llru.lockHistories[key] = history
+	if llru.onUnlock != nil {
+		llru.onUnlock(key, value)
+	}
+	llru.publish(Event[K, V]{Kind: EventUnlock, Key: key, Value: value})
+}
+
+//returns the position a key should return to when it is unlocked: its current position if it is unlocked, otherwise the
+//position of a new entry
+func (llru *ThreadunsafeLLRU[K, V]) positionBeforeLock(key K) unlockedPosition {
+	recency, ok := llru.unlocked.Recency(key)
+	if !ok {
+		recency = llru.unlocked.tick()
+	}
+	segment, ok := llru.unlocked.Segment(key)
+	if !ok {
+		segment = -1
+	}
+	return unlockedPosition{recency: recency, priority: llru.unlocked.Priority(key), segment: segment}
+}
+
+//adds a previously locked entry to the unlocked entries, as the most recent or at its pre-lock position
+func (llru *ThreadunsafeLLRU[K, V]) addUnlockedAfterLock(key K, value V, position unlockedPosition) {
+	if llru.keepPositionOnUnlock {
+		llru.unlocked.AddAt(key, value, position.recency, position.priority, position.segment)
+	} else {
+		llru.unlocked.AddUsed(key, value, position.priority, position.segment)
+	}
+}
+
+//removes all lock bookkeeping for a key that is no longer locked
+func (llru *ThreadunsafeLLRU[K, V]) forgetLock(key K) {
+	delete(llru.lockCounts, key)
+	delete(llru.lockDeadlines, key)
+	delete(llru.lockedPositions, key)
+	delete(llru.owners, key)
+	delete(llru.readPins, key)
+	delete(llru.writePins, key)
+}
+
+//releases expired timed locks and removes expired entries, which most public methods do before anything else
+func (llru *ThreadunsafeLLRU[K, V]) releaseExpired() {
+	llru.releaseExpiredLocks()
+	llru.removeExpired()
+}
+
+//returns whether releaseExpired would release a lock or remove an entry. Does not change the cache
+func (llru *ThreadunsafeLLRU[K, V]) hasExpired() bool {
+	now := llru.clock.Now()
+	if !llru.nextLockRelease.IsZero() && !now.Before(llru.nextLockRelease) {
+		return true
+	}
+	return !llru.frozen && !llru.nextExpiry.IsZero() && !now.Before(llru.nextExpiry)
+}
+
+// ReleaseExpiredLocks releases the timed lock of every key whose deadline has passed, earliest deadline first, and
+// returns the number of locks released. Expired locks are also released lazily whenever the cache is used, so this only
+// needs to be called to release them sooner, for instance to get the OnAutoUnlock callback called in a timely manner.
+func (llru *ThreadunsafeLLRU[K, V]) ReleaseExpiredLocks() int {
+	return llru.releaseExpiredLocks()
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) releaseExpiredLocks() int {
+	now := llru.clock.Now()
+	if llru.nextLockRelease.IsZero() || now.Before(llru.nextLockRelease) {
+		return 0
+	}
+
+	//nextLockRelease may be earlier than every deadline, once the earliest was removed, so it is worked out again
+	expired := []K{}
+	llru.nextLockRelease = time.Time{}
+	for key, deadline := range llru.lockDeadlines {
+		if !deadline.After(now) {
+			expired = append(expired, key)
+		} else if llru.nextLockRelease.IsZero() || deadline.Before(llru.nextLockRelease) {
+			llru.nextLockRelease = deadline
+		}
+	}
+	slices.SortFunc(expired, func(a, b K) int {
+		return llru.lockDeadlines[a].Compare(llru.lockDeadlines[b])
+	})
+
+	for _, key := range expired {
+		delete(llru.lockDeadlines, key)
+		value, _ := llru.locked.Get(key)
+		llru.unlock(key)
+		if llru.onAutoUnlock != nil {
+			llru.onAutoUnlock(key, value)
+		}
+	}
+	return len(expired)
+}
+
+// Locks an unlocked value in the cache. 
+// If the key exists and is unlocked, it is locked, and `true` is returned
+// If the key exists and is locked, its lock count is incremented, and `true` is returned
+// If the key exists and is unlocked and the maximum number of locked entries is reached, returns `false`
+// If the key does not exist, returns `false`
+func (llru *ThreadunsafeLLRU[K, V]) Lock(key K) (ok bool) {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+	return llru.lock(key)
+}
+
+// TryLock locks an unlocked value in the cache. Unlike Lock, it never locks an entry which is already locked.
+// If the key exists and is unlocked, it is locked, and `nil` is returned
+// If the key exists and is locked, `ErrAlreadyLocked` is returned. Its lock count is unchanged
+// If the key exists and is unlocked and the maximum number of locked entries is reached, `ErrLockLimitReached` is returned
+// If the key does not exist, `ErrKeyNotFound` is returned
+func (llru *ThreadunsafeLLRU[K, V]) TryLock(key K) error {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+
+	locked, exists := llru.IsLocked(key)
+	if !exists {
+		return ErrKeyNotFound
+	}
+	if locked {
+		return ErrAlreadyLocked
+	}
+	if !llru.hasLockRoom() {
+		return ErrLockLimitReached
+	}
+	llru.lock(key)
+	return nil
+}
+
+// GetAndLock locks a value in the cache, like Lock, and returns it
+// If the key exists and could be locked, returns the value and `true`
+// If the key does not exist, or it is unlocked and the maximum number of locked entries is reached, returns the zero value and `false`
+func (llru *ThreadunsafeLLRU[K, V]) GetAndLock(key K) (value V, ok bool) {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+
+	if !llru.lock(key) {
+		return value, false
+	}
+	llru.accessed(key)
+	return llru.locked.Get(key)
+}
+
+// Acquire locks a value in the cache, like GetAndLock, and returns a lease which unlocks it when closed
+// If the key exists and could be locked, returns the lease and `true`
+// If the key does not exist, or it is unlocked and the maximum number of locked entries is reached, returns `nil, false`
+func (llru *ThreadunsafeLLRU[K, V]) Acquire(key K) (lease *Lease[K, V], ok bool) {
+	value, ok := llru.GetAndLock(key)
+	if !ok {
+		return nil, false
+	}
+	return newLease(key, value, llru.Unlock), true
+}
+
+// WithLocked locks a value in the cache, calls `fn` with it, then unlocks it, even if `fn` panics
+// If the key exists and could be locked, returns `true` once `fn` has returned
+// If the key does not exist, or it is unlocked and the maximum number of locked entries is reached, `fn` is not called and `false` is returned
+func (llru *ThreadunsafeLLRU[K, V]) WithLocked(key K, fn func(value V)) (ok bool) {
+	lease, ok := llru.Acquire(key)
+	if !ok {
+		return false
+	}
+	defer lease.Close()
+
+	fn(lease.Value())
+	return true
+}
+
+// LockOldest locks the least recently used unlocked entry and returns it
+// If there are no unlocked entries, or the maximum number of locked entries is reached, returns `nil`
+func (llru *ThreadunsafeLLRU[K, V]) LockOldest() *Entry[K, V] {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+
+	key, value, ok := llru.unlocked.GetOldest()
+	if !ok || !llru.lock(key) {
+		return nil
+	}
+	return &Entry[K, V]{Key: key, Value: value}
+}
+
+// LockNewest locks the most recently used unlocked entry and returns it
+// If there are no unlocked entries, or the maximum number of locked entries is reached, returns `nil`
+func (llru *ThreadunsafeLLRU[K, V]) LockNewest() *Entry[K, V] {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+
+	key, value, ok := llru.unlocked.GetNewest()
+	if !ok || !llru.lock(key) {
+		return nil
+	}
+	return &Entry[K, V]{Key: key, Value: value}
+}
+
+// GetOldest returns the least recently used unlocked entry, without changing its recency or locking it
+// If there are no unlocked entries, returns `nil`
+func (llru *ThreadunsafeLLRU[K, V]) GetOldest() *Entry[K, V] {
+	llru.releaseExpired()
+	return llru.getOldest()
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) getOldest() *Entry[K, V] {
+	key, value, ok := llru.unlocked.GetOldest()
+	if !ok {
+		return nil
+	}
+	return &Entry[K, V]{Key: key, Value: value}
+}
+
+// OldestUnlocked returns the unlocked entry which would be evicted next to make room, without changing its recency or
+// evicting it, so that it can be locked before it is evicted. This is the least recently used unlocked entry, unless
+// priorities, a victim score, a minimum residency or a policy other than LRU choose another one
+// If there are no unlocked entries, returns `nil`
+func (llru *ThreadunsafeLLRU[K, V]) OldestUnlocked() *Entry[K, V] {
+	llru.releaseExpired()
+	return llru.oldestUnlocked()
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) oldestUnlocked() *Entry[K, V] {
+	key, value, ok := llru.unlocked.PeekVictim()
+	if !ok {
+		return nil
+	}
+	return &Entry[K, V]{Key: key, Value: value}
+}
+
+// GetNewest returns the newest entry, without changing its recency or lock state: the most recently locked entry if
+// any entry is locked, since locked entries come after unlocked ones in Keys, otherwise the most recently used unlocked
+// entry
+// If the cache is empty, returns `nil`
+func (llru *ThreadunsafeLLRU[K, V]) GetNewest() *Entry[K, V] {
+	llru.releaseExpired()
+	return llru.getNewest()
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) getNewest() *Entry[K, V] {
+	if pair := llru.locked.Newest(); pair != nil {
+		return &Entry[K, V]{Key: pair.Key, Value: pair.Value}
+	}
+	key, value, ok := llru.unlocked.GetNewest()
+	if !ok {
+		return nil
+	}
+	return &Entry[K, V]{Key: key, Value: value}
+}
+
+// LockAs locks a value in the cache, like Lock, on behalf of `owner`. Owners identify who holds the locks on an entry,
+// see Owners. The same owner can lock an entry more than once.
+// Returns `true` if the entry was locked, `false` if it does not exist or the maximum number of locked entries is reached
+func (llru *ThreadunsafeLLRU[K, V]) LockAs(key K, owner string) (ok bool) {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+
+	ok = llru.lock(key)
+	if ok {
+		llru.owners[key] = append(llru.owners[key], owner)
+	}
+	return ok
+}
+
+// UnlockAs releases a lock taken with LockAs by `owner`, like Unlock does.
+// If `owner` does not hold a lock on the entry, nothing is unlocked, the misuse is reported if misuse detection is
+// enabled (see SetOnMisuse), and `false` is returned
+func (llru *ThreadunsafeLLRU[K, V]) UnlockAs(key K, owner string) (ok bool) {
+	defer llru.observe(MetricsOpUnlock)()
+	llru.releaseExpired()
+
+	owners := llru.owners[key]
+	i := slices.Index(owners, owner)
+	if i < 0 {
+		if llru.Contains(key) {
+			llru.reportMisuse(key, "unlock by "+owner+", which does not own a lock on the entry")
+		}
+		return false
+	}
+	llru.owners[key] = slices.Delete(owners, i, i+1)
+	if len(llru.owners[key]) == 0 {
+		delete(llru.owners, key)
+	}
+	return llru.unlock(key)
+}
+
+// Owners returns the owners of the locks taken with LockAs on an entry, in the order the locks were taken. An owner is
+// listed once for each lock it holds. Locks taken without an owner are not listed
+func (llru *ThreadunsafeLLRU[K, V]) Owners(key K) []string {
+	llru.releaseExpired()
+	return llru.ownersOf(key)
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) ownersOf(key K) []string {
+	return slices.Clone(llru.owners[key])
+}
+
+// LockMany locks each of the given keys, as Lock does, and returns whether each key was locked
+func (llru *ThreadunsafeLLRU[K, V]) LockMany(keys []K) (ok []bool) {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+
+	ok = make([]bool, len(keys))
+	for i, key := range keys {
+		ok[i] = llru.lock(key)
+	}
+	return ok
+}
+
+// LockFor locks a value in the cache, like Lock, and releases that lock once `duration` has elapsed.
+// Expired locks are released lazily, the next time the cache is used. A key has at most one timed lock: locking a key
+// which already has a timed lock moves its deadline instead of locking it again.
+// If the key exists, it is locked until the deadline, and `true` is returned
+// If the key does not exist, returns `false`
+func (llru *ThreadunsafeLLRU[K, V]) LockFor(key K, duration time.Duration) (ok bool) {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+
+	_, hasTimedLock := llru.lockDeadlines[key]
+	if hasTimedLock {
+		llru.setLockDeadline(key, llru.clock.Now().Add(duration))
+		return true
+	}
+
+	ok = llru.lock(key)
+	if ok {
+		llru.setLockDeadline(key, llru.clock.Now().Add(duration))
+	}
+	return ok
+}
+
+//sets the time at which the timed lock on a key is released
+func (llru *ThreadunsafeLLRU[K, V]) setLockDeadline(key K, deadline time.Time) {
+	llru.lockDeadlines[key] = deadline
+	if llru.nextLockRelease.IsZero() || deadline.Before(llru.nextLockRelease) {
+		llru.nextLockRelease = deadline
+	}
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) lock(key K) (ok bool) {
+	if !llru.moveToLocked(key) {
+		return false
+	}
+	llru.incrementLockCount(key)
+	return true
+}
+
+//moves an unlocked entry to the locked entries, without changing its lock count. Returns `true` if the entry is now
+//locked, `false` if it does not exist or the maximum number of locked entries is reached
+func (llru *ThreadunsafeLLRU[K, V]) moveToLocked(key K) (ok bool) {
+	value, exists := llru.unlocked.Peek(key)
+	if !exists {
+		_, exists = llru.locked.Get(key)
+		return exists
+	}
+	if !llru.hasLockRoom() {
+		return false
+	}
+	llru.lockedPositions[key] = llru.positionBeforeLock(key)
+	llru.unlocked.Remove(key)
+	llru.setLocked(key, value)
+	llru.notifyLocked(key, value)
+
+	//resize unlocked
+	resizeUnderlyingUnlocked(llru.unlocked, llru.unlockedSize())
+
+	return true
+}
+
+// Unlocks a locked value in the cache. 
+// If the key exists and is locked more than once, its lock count is decremented and `true` is returned. It stays locked
+// If the key exists and is locked once, it is unlocked, making it the most recently used item, and `true` is returned
+// If the key exists and is unlocked, it becomes the most recently used item, and `true` is returned
+// When SetKeepPositionOnUnlock is enabled, entries keep their pre-lock position instead of becoming the most recently used
+// If the key does not exist, returns `false`
+func (llru *ThreadunsafeLLRU[K, V]) Unlock(key K) (ok bool) {
+	defer llru.observe(MetricsOpUnlock)()
+	llru.releaseExpired()
+	return llru.unlock(key)
+}
+
+// UnlockIf unlocks a locked value in the cache, like Unlock, only if `predicate` returns true for its value
+// If the key exists, is locked and `predicate` returns true, it is unlocked and `true` is returned
+// If the key exists, is locked and `predicate` returns false, it stays locked and `false` is returned
+// If the key exists and is unlocked, or does not exist, `predicate` is not called and `false` is returned
+func (llru *ThreadunsafeLLRU[K, V]) UnlockIf(key K, predicate func(value V) bool) (ok bool) {
+	defer llru.observe(MetricsOpUnlock)()
+	llru.releaseExpired()
+
+	value, locked := llru.locked.Get(key)
+	if !locked || !predicate(value) {
+		return false
+	}
+	return llru.unlock(key)
+}
+
+// UnlockMany unlocks each of the given keys, as Unlock does, and returns whether each key was found
+func (llru *ThreadunsafeLLRU[K, V]) UnlockMany(keys []K) (ok []bool) {
+	defer llru.observe(MetricsOpUnlock)()
+	llru.releaseExpired()
+
+	ok = make([]bool, len(keys))
+	for i, key := range keys {
+		ok[i] = llru.unlock(key)
+	}
+	return ok
+}
+
+// UnlockAll unlocks every locked entry, regardless of its lock count or pins. The entries become the most recently used items,
+// keeping the order in which they were locked. Returns the number of entries that were unlocked
+func (llru *ThreadunsafeLLRU[K, V]) UnlockAll() int {
+	defer llru.observe(MetricsOpUnlock)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	entries := collectEntriesFromUnderlyingLocked(llru.locked)
+	lockedPositions := llru.lockedPositions
+
+	llru.locked = gmap.New[K,V]()
+	llru.lockedWeight = 0
+	llru.lockedPositions = make(map[K]unlockedPosition)
+	clear(llru.lockCounts)
+	clear(llru.lockDeadlines)
+	clear(llru.owners)
+	clear(llru.readPins)
+	clear(llru.writePins)
+
+	//grow unlocked to make room for every previously locked entry
+	resizeUnderlyingUnlocked(llru.unlocked, llru.unlockedSize())
+	for _, entry := range entries {
+		llru.addUnlockedAfterLock(entry.Key, entry.Value, lockedPositions[entry.Key])
+		llru.notifyUnlocked(entry.Key, entry.Value)
+	}
+
+	return len(entries)
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) unlock(key K) (ok bool) {
+	value, exists := llru.locked.Get(key)
+	if !exists {
+		if llru.unlocked.Contains(key) {
+			llru.reportMisuse(key, "unlock of an entry which is not locked")
+		}
+		if llru.keepPositionOnUnlock {
+			return llru.unlocked.Contains(key)
+		}
+		_, exists = llru.unlocked.Get(key)
+		return exists
+	}
+	if llru.countLocks && llru.lockCounts[key] > 1 {
+		llru.lockCounts[key]--
+		return true
+	}
+	delete(llru.lockCounts, key)
+	delete(llru.lockDeadlines, key)
+	llru.moveToUnlockedIfUnpinned(key, value)
+
+	return true
+}
+
+//moves a locked entry to the unlocked entries, unless it still has a read or write pin
+func (llru *ThreadunsafeLLRU[K, V]) moveToUnlockedIfUnpinned(key K, value V) {
+	if llru.readPins[key] > 0 || llru.writePins[key] {
+		return
+	}
+
+	position := llru.lockedPositions[key]
+	llru.deleteLocked(key)
+	llru.forgetLock(key)
+
+	//grow unlocked to prevent unnecessary eviction prior to adding the new value
+	resizeUnderlyingUnlocked(llru.unlocked, llru.unlockedSize())
+
+	llru.addUnlockedAfterLock(key, value, position)
+	llru.notifyUnlocked(key, value)
+}
+
+// SetLockStates locks or unlocks many keys at once. Keys mapped to `true` are locked, unless they already are. Keys
+// mapped to `false` are unlocked, regardless of their lock count and pins, unless they already are.
+// Returns the keys whose state could not be applied, in no particular order: keys which do not exist, and keys which
+// could not be locked because the maximum number of locked entries is reached
+func (llru *ThreadunsafeLLRU[K, V]) SetLockStates(states map[K]bool) (failed []K) {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+
+	for key, shouldLock := range states {
+		locked, exists := llru.IsLocked(key)
+		switch {
+		case !exists:
+			failed = append(failed, key)
+		case shouldLock && !locked:
+			if !llru.lock(key) {
+				failed = append(failed, key)
+			}
+		case !shouldLock && locked:
+			llru.forceUnlock(key)
+		}
+	}
+	return failed
+}
+
+//unlocks a locked key, regardless of its lock count and pins
+func (llru *ThreadunsafeLLRU[K, V]) forceUnlock(key K) {
+	value, _ := llru.locked.Get(key)
+	delete(llru.lockCounts, key)
+	delete(llru.lockDeadlines, key)
+	delete(llru.owners, key)
+	delete(llru.readPins, key)
+	delete(llru.writePins, key)
+	llru.moveToUnlockedIfUnpinned(key, value)
+}
+
+// RLock adds a read pin to an entry. An entry can have any number of read pins, but not while it has a write pin.
+// Like a lock, a pin prevents the entry from being evicted. Pins are counted separately from locks: an entry stays
+// locked until its lock count reaches zero and it has no read or write pins.
+// If the key exists and has no write pin, a read pin is added, and `nil` is returned
+// If the key exists and has a write pin, `ErrWriteLocked` is returned
+// If the key exists and is unlocked and the maximum number of locked entries is reached, `ErrLockLimitReached` is returned
+// If the key does not exist, `ErrKeyNotFound` is returned
+func (llru *ThreadunsafeLLRU[K, V]) RLock(key K) error {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+
+	err := llru.checkCanPin(key)
+	if err != nil {
+		return err
+	}
+	if llru.writePins[key] {
+		return ErrWriteLocked
+	}
+	llru.moveToLocked(key)
+	llru.readPins[key]++
+	return nil
+}
+
+// RUnlock removes a read pin from an entry. If it was the last pin and the entry has no locks, it is unlocked, as
+// Unlock does.
+// Returns `false` if the key does not exist or has no read pin
+func (llru *ThreadunsafeLLRU[K, V]) RUnlock(key K) (ok bool) {
+	defer llru.observe(MetricsOpUnlock)()
+	llru.releaseExpired()
+
+	if llru.readPins[key] == 0 {
+		if llru.Contains(key) {
+			llru.reportMisuse(key, "read unlock of an entry which has no read pin")
+		}
+		return false
+	}
+	llru.readPins[key]--
+	if llru.readPins[key] == 0 {
+		delete(llru.readPins, key)
+	}
+	llru.unlockIfUnused(key)
+	return true
+}
+
+// WLock adds a write pin to an entry. An entry can only have one write pin, and only while it has no read pins.
+// Like a lock, a pin prevents the entry from being evicted. See RLock.
+// If the key exists and has no read or write pins, a write pin is added, and `nil` is returned
+// If the key exists and has a write pin, `ErrWriteLocked` is returned
+// If the key exists and has a read pin, `ErrReadLocked` is returned
+// If the key exists and is unlocked and the maximum number of locked entries is reached, `ErrLockLimitReached` is returned
+// If the key does not exist, `ErrKeyNotFound` is returned
+func (llru *ThreadunsafeLLRU[K, V]) WLock(key K) error {
+	defer llru.observe(MetricsOpLock)()
+	llru.releaseExpired()
+
+	err := llru.checkCanPin(key)
+	if err != nil {
+		return err
+	}
+	if llru.writePins[key] {
+		return ErrWriteLocked
+	}
+	if llru.readPins[key] > 0 {
+		return ErrReadLocked
+	}
+	llru.moveToLocked(key)
+	llru.writePins[key] = true
+	return nil
+}
+
+// WUnlock removes the write pin from an entry. If the entry has no locks, it is unlocked, as Unlock does.
+// Returns `false` if the key does not exist or has no write pin
+func (llru *ThreadunsafeLLRU[K, V]) WUnlock(key K) (ok bool) {
+	defer llru.observe(MetricsOpUnlock)()
+	llru.releaseExpired()
+
+	if !llru.writePins[key] {
+		if llru.Contains(key) {
+			llru.reportMisuse(key, "write unlock of an entry which has no write pin")
+		}
+		return false
+	}
+	delete(llru.writePins, key)
+	llru.unlockIfUnused(key)
+	return true
+}
+
+//returns the error preventing a key from being pinned, if any
+func (llru *ThreadunsafeLLRU[K, V]) checkCanPin(key K) error {
+	locked, exists := llru.IsLocked(key)
+	if !exists {
+		return ErrKeyNotFound
+	}
+	if !locked && !llru.hasLockRoom() {
+		return ErrLockLimitReached
+	}
+	return nil
+}
+
+//unlocks a locked key which has no locks left
+func (llru *ThreadunsafeLLRU[K, V]) unlockIfUnused(key K) {
+	value, locked := llru.locked.Get(key)
+	if locked && llru.lockCounts[key] == 0 {
+		llru.moveToUnlockedIfUnpinned(key, value)
+	}
+}
+
+// If the key exists and is locked, the value is returned
+// If the key exists and is unlocked, it becomes the most recently used item, and the value is returned
+// If the key does not exist, `nil` is returned
+// If the key does not exist and an overflow handler is set, the value is loaded from it and added back as the most
+// recently used item. If it cannot be added for lack of room, the loaded value is still returned
+// When SetPinOnGet is enabled, the entry is also locked, as GetAndLock does, and `nil` is returned if it cannot be locked
+func (llru *ThreadunsafeLLRU[K, V]) Get(key K) (value *V) {
+	defer llru.observe(MetricsOpGet)()
+	val, ok := llru.Get2(key)
+	if !ok {
+		return nil
+	}
+	return &val
+}
+
+// Get2 is the same as Get, but returns the value itself and whether it was found, instead of a pointer to the value,
+// which avoids an allocation and tells a missing key apart from a zero value.
+// If the key does not exist, the zero value and `false` are returned
+func (llru *ThreadunsafeLLRU[K, V]) Get2(key K) (value V, ok bool) {
+	defer llru.observe(MetricsOpGet)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	contained := llru.Contains(key)
+	if !contained || !llru.pinOnGet {
+		llru.countLookup(key, contained)
+	}
+
+	if llru.overflow != nil && !contained {
+		loaded, ok := llru.overflow.Load(key)
+		if !ok {
+			return value, false
+		}
+		if added, _ := llru.addOrUpdateUnlocked(key, loaded, nil); !added {
+			if llru.pinOnGet {
+				return value, false
+			}
+			return loaded, true
+		}
+	}
+	llru.accessed(key)
+
+	if llru.pinOnGet {
+		value, ok = llru.GetAndLock(key)
+		if contained {
+			llru.countLookup(key, ok) //an entry which cannot be pinned for lack of lock room is not returned, so it is a miss
+		}
+		return value, ok
+	}
+
+	value, ok = llru.locked.Get(key)
+	if ok {
+		return value, true
+	}
+	return llru.unlocked.Get(key)
+}
+
+// GetOrDefault gets the value of a key, as Get2 does, or returns `def` if the key does not exist. `def` is not added
+func (llru *ThreadunsafeLLRU[K, V]) GetOrDefault(key K, def V) V {
+	defer llru.observe(MetricsOpGet)()
+	value, ok := llru.Get2(key)
+	if !ok {
+		return def
+	}
+	return value
+}
+
+// GetMany gets each of the given keys, as Get2 does, and returns their values and whether each key was found
+func (llru *ThreadunsafeLLRU[K, V]) GetMany(keys []K) (values []V, ok []bool) {
+	defer llru.observe(MetricsOpGet)()
+	defer llru.startBatch()()
+
+	values = make([]V, len(keys))
+	ok = make([]bool, len(keys))
+	for i, key := range keys {
+		values[i], ok[i] = llru.Get2(key)
+	}
+	return values, ok
+}
+
+// GetWithExpiry gets a value like Get, along with the time at which it expires, so that callers can refresh it before
+// it does. The time is zero if the entry never expires.
+// If the key does not exist, the zero value, zero time and `false` are returned
+func (llru *ThreadunsafeLLRU[K, V]) GetWithExpiry(key K) (value V, expiresAt time.Time, ok bool) {
+	defer llru.observe(MetricsOpGet)()
+	value, ok = llru.Get2(key)
+	if !ok {
+		return value, expiresAt, false
+	}
+	return value, llru.expiresAt[key], true
+}
+
+// Touch makes an unlocked entry the most recently used item, as Get does, without reading its value, for instance to
+// replay an access log. Like a read, it restarts the lifetime of the entry if SetExpireAfterAccess is enabled
+// If the key exists and is unlocked, `true` is returned
+// If the key exists and is locked, or does not exist, nothing changes and `false` is returned
+func (llru *ThreadunsafeLLRU[K, V]) Touch(key K) (ok bool) {
+	defer llru.observe(MetricsOpGet)()
+	llru.releaseExpired()
+
+	if !llru.unlocked.Contains(key) {
+		return false
+	}
+	llru.accessed(key)
+	_, ok = llru.unlocked.Get(key)
+	return ok
+}
+
+// If the key exists, true is returned. The recentness of the item is unchanged
+// If the key does not exist, false is returned. 
+func (llru *ThreadunsafeLLRU[K, V]) Contains(key K) bool {
+	llru.releaseExpired()
+	return llru.contains(key)
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) contains(key K) bool {
+	inUnlocked := llru.unlocked.Contains(key)
+	if inUnlocked {
+		return inUnlocked
+	}
+	_, inLocked := llru.locked.Get(key)
+
+	return inLocked
+}
+
+// If the key exists and is locked, returns `true, true`
+// If the key exists and is unlocked, returns `false, true`. The recentness of the item is unchanged
+// If the key does not exist, returns `false, false`
+func (llru *ThreadunsafeLLRU[K, V]) IsLocked(key K) (locked bool, exists bool) {
+	llru.releaseExpired()
+	return llru.isLocked(key)
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) isLocked(key K) (locked bool, exists bool) {
+	_, locked = llru.locked.Get(key)
+	if locked {
+		return true, true
+	}
+	return false, llru.unlocked.Contains(key)
+}
+
+// Returns the number of entries
+func (llru *ThreadunsafeLLRU[K, V]) Len() int {
+	llru.releaseExpired()
+
+	return llru.locked.Len() + llru.unlocked.Len()
+}
+
+// Returns the number of locked entries
+func (llru *ThreadunsafeLLRU[K, V]) LenLocked() int {
+	llru.releaseExpired()
+
+	return llru.locked.Len()
+}
+
+// Returns the number of unlocked entries
+func (llru *ThreadunsafeLLRU[K, V]) LenUnlocked() int {
+	llru.releaseExpired()
+
+	return llru.unlocked.Len()
+}
+
+// Returns the number of entries that can be stored unlocked, which is the total size minus the number of locked entries,
+// or the total size when SetUnlimitedLocked is enabled, or math.MaxInt when the cache is unbounded. This includes room already taken by unlocked entries, which can be evicted
+func (llru *ThreadunsafeLLRU[K, V]) EvictableRoom() int {
+	llru.releaseExpired()
+
+	return llru.unlockedSize()
+}
+
+// Room returns the size of the cache, the number of locked and unlocked entries, and the room for unlocked entries, as
+// returned by EvictableRoom, all at once. The size is 0 when the cache is unbounded
+func (llru *ThreadunsafeLLRU[K, V]) Room() (size, lockedLen, unlockedLen, evictableRoom int) {
+	llru.releaseExpired()
+	return llru.room()
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) room() (size, lockedLen, unlockedLen, evictableRoom int) {
+	return llru.size, llru.locked.Len(), llru.unlocked.Len(), llru.unlockedSize()
+}
+
+// Returns an array of every entry, starting with unlocked from oldest to newest, then locked
+func (llru *ThreadunsafeLLRU[K, V]) Entries() []Entry[K,V] {
+	llru.releaseExpired()
+	return llru.entries()
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) entries() []Entry[K,V] {
+	unlockedEntries := collectEntriesFromUnderlyingUnlocked(llru.unlocked)
+	lockedEntries := collectEntriesFromUnderlyingLocked(llru.locked)
+
+	return append(unlockedEntries, lockedEntries...)
+}
+
+// Returns an array of every key, starting with unlocked from oldest to newest, then locked in the order they were locked
+func (llru *ThreadunsafeLLRU[K, V]) Keys() []K {
+	llru.releaseExpired()
+	return llru.keys()
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) keys() []K {
+	unlockedKeys := llru.unlocked.Keys()
+	lockedKeys := collectKeysFromUnderlyingLocked(llru.locked)
+
+	return append(unlockedKeys, lockedKeys...)
+}
+
+// Range calls `fn` with every entry, in the same order as Entries, unlocked from oldest to newest, then locked in the
+// order they were locked, until it returns false, without collecting the entries first or changing their recency.
+// `fn` must not change the cache
+func (llru *ThreadunsafeLLRU[K, V]) Range(fn func(key K, value V, locked bool) bool) {
+	llru.releaseExpired()
+	llru.rangeEntries(false, fn)
+}
+
+// RangeReverse calls `fn` with every entry in the opposite order to Range, locked from the most recently locked, then
+// unlocked from newest to oldest, until it returns false, for instance to list the most recent entries without
+// collecting and reversing every entry. `fn` must not change the cache
+func (llru *ThreadunsafeLLRU[K, V]) RangeReverse(fn func(key K, value V, locked bool) bool) {
+	llru.releaseExpired()
+	llru.rangeEntries(true, fn)
+}
+
+// Snapshot returns a copy of every entry, with its lock state, in the same order as Entries, which does not change when
+// the cache changes. See Snapshot
+func (llru *ThreadunsafeLLRU[K, V]) Snapshot() *Snapshot[K, V] {
+	llru.releaseExpired()
+
+	return newSnapshot(llru)
+}
+
+// Stream returns a channel which receives every entry, in the same order as Entries, until `ctx` is done. The entries are
+// copied before Stream returns, as Snapshot does, so the cache can be changed while they are received. See
+// Snapshot.Stream
+func (llru *ThreadunsafeLLRU[K, V]) Stream(ctx context.Context) <-chan Entry[K, V] {
+	return llru.Snapshot().Stream(ctx)
+}
+
+// List returns up to `limit` entries, skipping the first `offset` ones, in the same order as Entries, along with the
+// total number of entries, so that large caches can be paged through, for instance by an admin UI. Pages taken at
+// different times may overlap or miss entries if the cache changed in between
+// If `offset` is past the last entry, or `limit` is not positive, no entries are returned
+func (llru *ThreadunsafeLLRU[K, V]) List(offset, limit int) (page []EntryInfo[K, V], total int) {
+	llru.releaseExpired()
+	return llru.list(offset, limit)
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) list(offset, limit int) (page []EntryInfo[K, V], total int) {
+	total = llru.locked.Len() + llru.unlocked.Len()
+	offset = max(offset, 0)
+	page = make([]EntryInfo[K, V], 0, max(min(limit, total - offset), 0))
+	i := 0
+	llru.rangeEntries(false, func(key K, value V, locked bool) bool {
+		if len(page) >= limit {
+			return false
+		}
+		if i >= offset {
+			page = append(page, EntryInfo[K, V]{Key: key, Value: value, Locked: locked, ExpiresAt: llru.expiresAt[key]})
+		}
+		i++
+		return true
+	})
+	return page, total
+}
+
+// RangeWhere calls `fn` with every entry for which `predicate` returns true, in the same order as Range, until `fn`
+// returns false. Neither function may change the cache
+func (llru *ThreadunsafeLLRU[K, V]) RangeWhere(predicate func(key K, value V) bool, fn func(key K, value V, locked bool) bool) {
+	llru.releaseExpired()
+	llru.rangeWhere(predicate, fn)
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) rangeWhere(predicate func(key K, value V) bool, fn func(key K, value V, locked bool) bool) {
+	llru.rangeEntries(false, func(key K, value V, locked bool) bool {
+		if !predicate(key, value) {
+			return true
+		}
+		return fn(key, value, locked)
+	})
+}
+
+// Find returns every entry for which `predicate` returns true, in the same order as Entries, without changing their
+// recency. `predicate` must not change the cache
+func (llru *ThreadunsafeLLRU[K, V]) Find(predicate func(key K, value V) bool) (found []Entry[K, V]) {
+	llru.releaseExpired()
+	return llru.find(predicate)
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) find(predicate func(key K, value V) bool) (found []Entry[K, V]) {
+	llru.rangeWhere(predicate, func(key K, value V, locked bool) bool {
+		found = append(found, Entry[K, V]{Key: key, Value: value})
+		return true
+	})
+	return found
+}
+
+//calls fn with every entry, in the order of Range, or in the reverse order if reverse is set, until it returns false
+func (llru *ThreadunsafeLLRU[K, V]) rangeEntries(reverse bool, fn func(key K, value V, locked bool) bool) {
+	more := true
+	rangeUnlocked := func() {
+		llru.unlocked.walk(reverse, func(pair *gmap.Pair[K, V]) bool {
+			more = fn(pair.Key, pair.Value, false)
+			return more
+		})
+	}
+
+	if !reverse {
+		rangeUnlocked()
+		for pair := llru.locked.Oldest(); more && pair != nil; pair = pair.Next() {
+			more = fn(pair.Key, pair.Value, true)
+		}
+		return
+	}
+	for pair := llru.locked.Newest(); more && pair != nil; pair = pair.Prev() {
+		more = fn(pair.Key, pair.Value, true)
+	}
+	if more {
+		rangeUnlocked()
+	}
+}
+
+// KeysByRecency returns up to `limit` unlocked keys, from the most recently used if `newestFirst` is true, otherwise from
+// the least recently used, for instance to report the hottest keys. Only the returned keys are collected, and the
+// recency of the entries is unchanged. Locked keys are never returned
+func (llru *ThreadunsafeLLRU[K, V]) KeysByRecency(limit int, newestFirst bool) []K {
+	llru.releaseExpired()
+	return llru.keysByRecency(limit, newestFirst)
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) keysByRecency(limit int, newestFirst bool) []K {
+	keys := make([]K, 0, max(min(limit, llru.unlocked.Len()), 0))
+	llru.unlocked.walk(newestFirst, func(pair *gmap.Pair[K, V]) bool {
+		if len(keys) >= limit {
+			return false
+		}
+		keys = append(keys, pair.Key)
+		return true
+	})
+	return keys
+}
+
+// Returns an array of every value, starting with unlocked from oldest to newest, then locked
+func (llru *ThreadunsafeLLRU[K, V]) Values() []V {
+	llru.releaseExpired()
+	return llru.values()
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) values() []V {
+	unlockedValues := llru.unlocked.Values()
+	lockedValues := collectValuesFromUnderlyingLocked(llru.locked)
+
+	return append(unlockedValues, lockedValues...)
+}
+
+// Returns an array of the values of the locked entries, in the order they were locked
+func (llru *ThreadunsafeLLRU[K, V]) ValuesLocked() []V {
+	llru.releaseExpired()
+	return llru.valuesLocked()
+}
+
+func (llru *ThreadunsafeLLRU[K, V]) valuesLocked() []V {
+	return collectValuesFromUnderlyingLocked(llru.locked)
+}
+
+// Returns an array of the values of the unlocked entries, from oldest to newest
+func (llru *ThreadunsafeLLRU[K, V]) ValuesUnlocked() []V {
+	llru.releaseExpired()
+
+	return llru.unlocked.Values()
+}
+
+// Freeze prevents entries from being evicted or removed until Thaw is called, for instance while taking a snapshot.
+// While the cache is frozen, adding a new key fails if the cache is full, and Remove, Pop, RemoveOldest, RemoveOldestN,
+// EvictN, ReplaceOldestKey, ReplaceOldestValue, ForceRemove, Purge and PurgeUnlocked fail. Existing entries can still be
+// updated, locked and unlocked.
+func (llru *ThreadunsafeLLRU[K, V]) Freeze() {
+	llru.frozen = true
+}
+
+// Thaw ends a Freeze, allowing entries to be evicted and removed again
+func (llru *ThreadunsafeLLRU[K, V]) Thaw() {
+	llru.frozen = false
+}
+
+// Returns whether the cache is frozen
+func (llru *ThreadunsafeLLRU[K, V]) IsFrozen() bool {
+	return llru.frozen
+}
+
+//returns whether adding the key must be refused because it would evict an entry while the cache is frozen
+func (llru *ThreadunsafeLLRU[K, V]) wouldEvictToAdd(key K) bool {
+	return llru.frozen && !llru.Contains(key) && llru.unlocked.Len() >= llru.unlockedSize()
+}
+
+// ForceRemove removes an entry from the cache, even if it is locked, regardless of its lock count and pins. The eviction
+// callbacks are called with EvictionReasonForced. It is meant as an escape hatch, for instance when the resource backing
+// a locked entry is known to be gone: whoever locked the entry will find it missing.
+// If the key exists, it is removed and `true` is returned
+// If the key does not exist, or the cache is frozen, `false` is returned
+func (llru *ThreadunsafeLLRU[K, V]) ForceRemove(key K) (ok bool) {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	ok, _ = llru.remove(key, EvictionReasonForced)
+	return ok
+}
+
+// Remove removes an entry from the cache, whether it is locked or unlocked. The eviction callbacks are called with
+// EvictionReasonRemoved.
+// If the key exists and is locked, it is removed, regardless of its lock count and pins, and `true, true` is returned
+// If the key exists and is unlocked, it is removed and `true, false` is returned
+// If the key does not exist, or the cache is frozen, `false, false` is returned
+func (llru *ThreadunsafeLLRU[K, V]) Remove(key K) (present bool, wasLocked bool) {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	return llru.remove(key, EvictionReasonRemoved)
+}
+
+// Pop returns the value of a key and removes it, as Remove does, for instance to consume cached items like a work
+// queue. Locked entries are only removed when `force` is true.
+// If the key exists, and is unlocked or `force` is true, it is removed, and its value and `true` are returned
+// If the key does not exist, it is locked and `force` is false, or the cache is frozen, the zero value and `false` are returned
+func (llru *ThreadunsafeLLRU[K, V]) Pop(key K, force bool) (value V, ok bool) {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	if _, locked := llru.locked.Get(key); locked && !force {
+		return value, false
+	}
+	found := llru.peek(key)
+	if found == nil {
+		return value, false
+	}
+	if removed, _ := llru.remove(key, EvictionReasonRemoved); !removed {
+		return value, false
+	}
+	return *found, true
+}
+
+// RemoveMany removes each of the given keys, as Remove does, and returns whether each key was found
+func (llru *ThreadunsafeLLRU[K, V]) RemoveMany(keys []K) (present []bool) {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+
+	present = make([]bool, len(keys))
+	for i, key := range keys {
+		present[i], _ = llru.Remove(key)
+	}
+	return present
+}
+
+//removes an entry, whether it is locked or unlocked, and calls the eviction callbacks with the given reason. Returns
+//whether it existed and whether it was locked. Nothing is removed if the cache is frozen
+func (llru *ThreadunsafeLLRU[K, V]) remove(key K, reason EvictionReason) (present bool, wasLocked bool) {
+	if llru.frozen {
+		return false, false
+	}
+
+	value, locked := llru.deleteLocked(key)
+	if locked {
+		llru.forgetLock(key)
+		resizeUnderlyingUnlocked(llru.unlocked, llru.unlockedSize())
+	} else {
+		var ok bool
+		value, ok = llru.unlocked.Peek(key)
+		if !ok {
+			return false, false
+		}
+		llru.unlocked.Remove(key)
+	}
+
+	llru.evicted(key, value, reason)
+	return true, locked
+}
+
+// Removes the least recently used unlocked entry and returns it
+// If there are no unlocked entries, or the cache is frozen, returns `nil`
+func (llru *ThreadunsafeLLRU[K, V]) RemoveOldest() *Entry[K, V] {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	if llru.frozen {
+		return nil
+	}
+
+	oldestKey, oldestValue, ok := llru.unlocked.RemoveOldest()
 
 	if ok {
 		return &Entry[K, V]{
@@ -273,10 +2984,161 @@ func (llru *ThreadunsafeLLRU[K, V]) RemoveOldest() *Entry[K, V] {
 	return nil
 }
 
+// RemoveOldestN removes up to `n` of the least recently used unlocked entries, as RemoveOldest does, and returns them from
+// oldest to newest
+// If there are fewer than `n` unlocked entries, every unlocked entry is removed
+// If the cache is frozen, returns `nil`
+func (llru *ThreadunsafeLLRU[K, V]) RemoveOldestN(n int) (removed []Entry[K, V]) {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	if llru.frozen {
+		return nil
+	}
+
+	for ; n > 0; n-- {
+		oldestKey, oldestValue, ok := llru.unlocked.RemoveOldest()
+		if !ok {
+			break
+		}
+		removed = append(removed, Entry[K, V]{Key: oldestKey, Value: oldestValue})
+	}
+	return removed
+}
+
+// Resize changes the size of the cache, evicting the unlocked entries which no longer fit, and returns them in the order
+// they were evicted. Locked entries are never evicted.
+// A size of 0 makes the cache unbounded, see NewUnsafeWithPolicy
+// If the size is negative, an error is returned and nothing changes
+// If the size is smaller than the number of locked entries and the room reserved with Reserve, ErrTooManyLocked is
+// returned and nothing changes, unless SetUnlimitedLocked is enabled
+// If the cache is frozen and shrinking it would evict entries, ErrFrozen is returned and nothing changes
+func (llru *ThreadunsafeLLRU[K, V]) Resize(size int) (evicted []Entry[K, V], err error) {
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	if size < 0 {
+		return nil, errors.New("must not provide a negative size")
+	}
+	if size > 0 && !llru.unlimitedLocked && size < llru.locked.Len() + llru.reserved {
+		return nil, ErrTooManyLocked
+	}
+	previousSize := llru.size
+	llru.size = size
+	if llru.frozen && llru.unlocked.Len() > llru.unlockedSize() {
+		llru.size = previousSize
+		return nil, ErrFrozen
+	}
+
+	llru.changed()
+	return llru.unlocked.Resize(llru.unlockedSize()), nil
+}
+
+// SetSizeBounds sets the smallest and largest sizes between which ApplyMemoryPressure resizes the cache. The current
+// size is left unchanged until ApplyMemoryPressure is called.
+// If minSize is not positive or is larger than maxSize, an error is returned and nothing changes
+func (llru *ThreadunsafeLLRU[K, V]) SetSizeBounds(minSize, maxSize int) error {
+	if minSize <= 0 || minSize > maxSize {
+		return errors.New("invalid size bounds")
+	}
+
+	llru.minSize = minSize
+	llru.maxSize = maxSize
+	return nil
+}
+
+// ApplyMemoryPressure resizes the cache between the bounds set with SetSizeBounds, according to `pressure`, from 0 for
+// no memory pressure, which grows the cache to the largest size, to 1 for the highest pressure, which shrinks it to the
+// smallest size. Values outside of this range are clamped. The size never goes below the number of locked entries, unless
+// SetUnlimitedLocked is enabled. Returns the evicted unlocked entries, in the order they were evicted
+// If the size bounds are not set, an error is returned and nothing changes
+// If the cache is frozen and shrinking it would evict entries, ErrFrozen is returned and nothing changes
+func (llru *ThreadunsafeLLRU[K, V]) ApplyMemoryPressure(pressure float64) (evicted []Entry[K, V], err error) {
+	if llru.maxSize <= 0 {
+		return nil, errors.New("size bounds are not set")
+	}
+
+	pressure = min(max(pressure, 0.0), 1.0)
+	size := llru.maxSize - int(pressure * float64(llru.maxSize - llru.minSize))
+	if !llru.unlimitedLocked {
+		size = max(size, llru.locked.Len() + llru.reserved)
+	}
+	return llru.Resize(size)
+}
+
+// Purge removes every entry, locked ones included, regardless of their lock counts and pins. The eviction callbacks are
+// called with EvictionReasonPurged, for the unlocked entries from oldest to newest, then for the locked entries in the
+// order they were locked. Settings and callbacks are kept. Returns the number of entries removed
+// If the cache is frozen, nothing is removed and `0` is returned
+func (llru *ThreadunsafeLLRU[K, V]) Purge() int {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+
+	if llru.frozen {
+		return 0
+	}
+
+	entries := collectEntriesFromUnderlyingLocked(llru.locked)
+	llru.locked = gmap.New[K,V]()
+	llru.lockedWeight = 0
+	llru.lockedPositions = make(map[K]unlockedPosition)
+	clear(llru.lockCounts)
+	clear(llru.lockDeadlines)
+	clear(llru.owners)
+	clear(llru.readPins)
+	clear(llru.writePins)
+
+	removed := llru.unlocked.Purge(EvictionReasonPurged)
+	resizeUnderlyingUnlocked(llru.unlocked, llru.unlockedSize())
+	for _, entry := range entries {
+		llru.evicted(entry.Key, entry.Value, EvictionReasonPurged)
+	}
+	return removed + len(entries)
+}
+
+// PurgeUnlocked removes every unlocked entry, from oldest to newest, calling the eviction callbacks with
+// EvictionReasonPurged. Locked entries are kept. Returns the number of entries removed
+// If the cache is frozen, nothing is removed and `0` is returned
+func (llru *ThreadunsafeLLRU[K, V]) PurgeUnlocked() int {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	if llru.frozen {
+		return 0
+	}
+	return llru.unlocked.Purge(EvictionReasonPurged)
+}
+
+// EvictN evicts up to n unlocked entries, the least recently used first, or in the order the policy evicts them to make
+// room, and returns them in the order they were evicted. Locked entries are never evicted. It is meant to shed entries
+// proactively, for instance under memory pressure. The eviction callbacks are called with EvictionReasonRemoved.
+// If the cache is frozen, or n is not positive, nothing is evicted and `nil` is returned
+func (llru *ThreadunsafeLLRU[K, V]) EvictN(n int) []Entry[K, V] {
+	defer llru.observe(MetricsOpRemove)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	if llru.frozen {
+		return nil
+	}
+	return llru.unlocked.EvictN(n, EvictionReasonRemoved)
+}
+
 //If `newKey` does not exist, and there is at least one unlocked entry, replaces the key in the oldest entry with `newKey` and returns the oldest entry's value, the old key, and `true`
 //If `newKey` does not exist, and there are no unlocked entries, returns `nil, nil, false`
 //If `newKey` exists, returns `nil, nil, false`
+//If the cache is frozen, returns `nil, nil, false`
 func (llru *ThreadunsafeLLRU[K, V]) ReplaceOldestKey(newKey K) (value *V, oldKey *K, ok bool) {
+	defer llru.observe(MetricsOpAdd)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	if llru.frozen {
+		return nil, nil, false
+	}
+
 	contains := llru.Contains(newKey)
 	
 	if !contains { //error if key exists
@@ -293,7 +3155,16 @@ func (llru *ThreadunsafeLLRU[K, V]) ReplaceOldestKey(newKey K) (value *V, oldKey
 
 //If there is at least one unlocked entry, replaces the value in the oldest entry with `newValue` and returns the oldest entry's old value, the key, and `true`
 //If there are no unlocked entries, returns `nil, nil, false`
+//If the cache is frozen, returns `nil, nil, false`
 func (llru *ThreadunsafeLLRU[K, V]) ReplaceOldestValue(newValue V) (oldValue *V, key *K, ok bool) {
+	defer llru.observe(MetricsOpAdd)()
+	defer llru.startBatch()()
+	llru.releaseExpired()
+
+	if llru.frozen {
+		return nil, nil, false
+	}
+
 	oldestKey, oldestValue, ok := llru.unlocked.RemoveOldest()
 
 	if ok {
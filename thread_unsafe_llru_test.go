@@ -1,9 +1,11 @@
 package lockable_lru
 
 import (
+	"errors"
 	"slices"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func buildNewEmpty(t *testing.T, size int) *ThreadunsafeLLRU[string, string] {
@@ -159,6 +161,25 @@ func TestAddOrUpdateUnlockedCase2(t *testing.T) {
 	}
 }
 
+// When SetForbidImplicitUnlock is enabled and the key exists and is locked, it is left unchanged and `false, nil` is returned.
+func TestAddOrUpdateUnlockedForbidImplicitUnlock(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+	llru.SetForbidImplicitUnlock(true)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+
+	ok, evicted := llru.AddOrUpdateUnlocked("new key1", "x")
+	if ok || evicted != nil {
+		t.Errorf("expected `false, nil` but got %v, %v", ok, evicted)
+	}
+
+	locked, _ := llru.IsLocked("new key1")
+	value := llru.Get("new key1")
+	if !locked || *value != "1" {
+		t.Errorf("expected `new key1` to be unchanged but got %v, %v", locked, *value)
+	}
+}
+
 // If the key does not exist and there is room, it is added, making it the most recently used item. If an entry was evicted, `true, entry` is returned, otherwise `true, nil` is returned.
 // If an entry was evicted, `true, entry` is returned
 func TestAddOrUpdateUnlockedCase3Part1(t *testing.T) {
@@ -213,6 +234,111 @@ func TestAddOrUpdateUnlockedCase4(t *testing.T) {
 
 }
 
+func TestEvictsLowestPriorityFirst(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	_, _ = llru.AddOrUpdateUnlockedWithPriority("new key1", "1", 2)
+	_, _ = llru.AddOrUpdateUnlockedWithPriority("new key2", "2", 1)
+	_, _ = llru.AddOrUpdateUnlockedWithPriority("new key3", "3", 2)
+
+	//"new key2" has the lowest priority, even though "new key1" is older
+	ok, evicted := llru.AddOrUpdateUnlockedWithPriority("new key4", "4", 2)
+	if !ok || evicted == nil || evicted.Key != "new key2" || evicted.Value != "2" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key2\", Value: \"2\"}` evicted but got %v, %v", ok, evicted)
+	}
+
+	//all remaining entries have the same priority, so the oldest is evicted
+	ok, evicted = llru.AddOrUpdateUnlockedWithPriority("new key5", "5", 2)
+	if !ok || evicted == nil || evicted.Key != "new key1" || evicted.Value != "1" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key1\", Value: \"1\"}` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+func TestEvictsLowestPriorityFirstAcrossSegments(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](3, SLRUPolicy(), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlockedWithPriority("new key2", "2", 1)
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_ = llru.Get("new key1")
+
+	//"new key1" and "new key3" have the lowest priority, and "new key3" is still in the probationary segment
+	ok, evicted := llru.AddOrUpdateUnlockedWithPriority("new key4", "4", 1)
+	if !ok || evicted == nil || evicted.Key != "new key3" {
+		t.Errorf("expected `true` and `new key3` evicted but got %v, %v", ok, evicted)
+	}
+
+	//the clone keeps the priorities, so "new key1" is the only entry left with the lowest priority
+	clone := llru.Clone(nil)
+	ok, evicted = clone.AddOrUpdateUnlockedWithPriority("new key5", "5", 1)
+	if !ok || evicted == nil || evicted.Key != "new key1" {
+		t.Errorf("expected `true` and `new key1` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+func TestPriorityIsKeptWhileLocked(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlockedWithPriority("new key1", "1", 1)
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.Lock("new key1")
+	_ = llru.Unlock("new key1")
+	_ = llru.Get("new key2")
+
+	//"new key1" is older but has a higher priority
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if !ok || evicted == nil || evicted.Key != "new key2" || evicted.Value != "2" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key2\", Value: \"2\"}` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+func TestAddUnlockedIfAbsent(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+
+	current, added, evicted := llru.AddUnlockedIfAbsent("new key1", "x")
+	if current == nil || *current != "1" || added || evicted != nil {
+		t.Errorf("expected `1, false, nil` but got %v, %v, %v", current, added, evicted)
+	}
+
+	//existing entry should still be locked
+	locked, _ := llru.IsLocked("new key1")
+	if !locked {
+		t.Errorf("expected key to still be locked")
+	}
+
+	current, added, evicted = llru.AddUnlockedIfAbsent("new key2", "2")
+	if current != nil || !added || evicted != nil {
+		t.Errorf("expected `nil, true, nil` but got %v, %v, %v", current, added, evicted)
+	}
+}
+
+func TestAddLockedIfAbsent(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+
+	current, added, evicted := llru.AddLockedIfAbsent("new key1", "x")
+	if current == nil || *current != "1" || added || evicted != nil {
+		t.Errorf("expected `1, false, nil` but got %v, %v, %v", current, added, evicted)
+	}
+
+	//existing entry should still be unlocked
+	locked, _ := llru.IsLocked("new key1")
+	if locked {
+		t.Errorf("expected key to still be unlocked")
+	}
+
+	current, added, evicted = llru.AddLockedIfAbsent("new key2", "2")
+	if current != nil || !added || evicted != nil {
+		t.Errorf("expected `nil, true, nil` but got %v, %v, %v", current, added, evicted)
+	}
+}
+
 // If the key exists and is locked, its value is updated, and `true, nil` is returned.
 func TestAddOrUpdateLockedCase1(t *testing.T) {
 	llru := buildNewEmpty(t, 1)
@@ -373,6 +499,113 @@ func TestUnlockCase2(t *testing.T) {
 	}
 }
 
+func TestUnlockKeepsPosition(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+	llru.SetKeepPositionOnUnlock(true)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+
+	_ = llru.Lock("new key1")
+	_ = llru.Lock("new key2")
+	_ = llru.Unlock("new key2")
+	_ = llru.Unlock("new key1")
+
+	keys := llru.Keys()
+	if !slices.Equal(keys, []string{"new key1", "new key2", "new key3"}) {
+		t.Errorf("expected keys to keep their order but got %v", keys)
+	}
+
+	//"new key1" should still be the oldest and the next evicted
+	ok, evicted := llru.AddOrUpdateUnlocked("new key4", "4")
+	if !ok || evicted == nil || evicted.Key != "new key1" || evicted.Value != "1" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key1\", Value: \"1\"}` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+func TestLockAndUnlockCallbacks(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	events := []string{}
+	llru.SetOnLock(func(key string, value string) {
+		events = append(events, "lock "+key)
+	})
+	llru.SetOnUnlock(func(key string, value string) {
+		events = append(events, "unlock "+key)
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	_ = llru.Lock("new key1")
+	_ = llru.Lock("new key1") //already locked, no callback
+	_ = llru.Unlock("new key1") //still locked, no callback
+	_ = llru.Unlock("new key1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	expected := []string{"lock new key2", "lock new key1", "unlock new key1", "unlock new key2"}
+	if !slices.Equal(events, expected) {
+		t.Errorf("expected %v but got %v", expected, events)
+	}
+}
+
+func TestLockDoesNotCallEvictionCallback(t *testing.T) {
+	evictedKeys := []string{}
+	llru, err := NewUnsafeWithEvict(2, func(key string, value string) {
+		evictedKeys = append(evictedKeys, key)
+	})
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_ = llru.Lock("new key1")
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+
+	if len(evictedKeys) != 0 {
+		t.Errorf("expected no evictions but got %v", evictedKeys)
+	}
+}
+
+func TestUnlockMisuseIsReported(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	misuses := []Misuse[string]{}
+	llru.SetOnMisuse(func(misuse Misuse[string]) {
+		misuses = append(misuses, misuse)
+	})
+
+	_, _ = llru.AddOrUpdateLocked("new key", "x")
+	_ = llru.Unlock("new key")
+	_ = llru.Unlock("new key")
+	_ = llru.RUnlock("new key")
+
+	if len(misuses) != 2 || misuses[0].Key != "new key" || len(misuses[0].Stack) == 0 {
+		t.Errorf("expected 2 misuses of `new key` with stack traces but got %v", misuses)
+	}
+}
+
+func TestUnlockIf(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateLocked("new key", "busy")
+
+	ok := llru.UnlockIf("new key", func(value string) bool { return value == "idle" })
+	locked, _ := llru.IsLocked("new key")
+	if ok || !locked {
+		t.Errorf("expected key to stay locked but got %v, %v", ok, locked)
+	}
+
+	_, _ = llru.AddOrUpdateLocked("new key", "idle")
+	_ = llru.Unlock("new key")
+
+	ok = llru.UnlockIf("new key", func(value string) bool { return value == "idle" })
+	locked, _ = llru.IsLocked("new key")
+	if !ok || locked {
+		t.Errorf("expected key to be unlocked but got %v, %v", ok, locked)
+	}
+}
+
 // If the key does not exist, returns `false`
 func TestUnlockCase3(t *testing.T) {
 	llru := buildNewEmpty(t, 1)
@@ -426,6 +659,55 @@ func TestGetCase3(t *testing.T) {
 	}
 }
 
+func TestGetWithPinOnGet(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+	llru.SetPinOnGet(true)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+
+	value := llru.Get("new key")
+	if value == nil || *value != "x" {
+		t.Errorf("expected `x` but got %v", value)
+	}
+
+	//key should now be locked, try adding another to confirm lock
+	ok, evicted := llru.AddOrUpdateUnlocked("new key1", "1")
+	if ok || evicted != nil {
+		t.Errorf("expected `false, nil` but got %v, %v", ok, evicted)
+	}
+
+	_ = llru.Unlock("new key")
+	locked, _ := llru.IsLocked("new key")
+	if locked {
+		t.Errorf("expected key to be unlocked")
+	}
+}
+
+// A key which cannot be pinned for lack of lock room is not returned, and counts as a miss
+func TestGetWithPinOnGetCountsFailedPinAsMiss(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+	llru.SetPinOnGet(true)
+	llru.SetMaxLocked(1)
+	var hits, misses []string
+	llru.SetOnHit(func(key string) {
+		hits = append(hits, key)
+	})
+	llru.SetOnMiss(func(key string) {
+		misses = append(misses, key)
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.Get("new key1")
+
+	if value, ok := llru.Get2("new key2"); ok {
+		t.Errorf("expected `false` but got %v, %v", value, ok)
+	}
+	if !slices.Equal(hits, []string{"new key1"}) || !slices.Equal(misses, []string{"new key2"}) {
+		t.Errorf("expected a hit on `new key1` and a miss on `new key2` but got %v, %v", hits, misses)
+	}
+}
+
 // If the key exists, true is returned. The recentness of the item is unchanged
 func TestContainsReturnsTrueWhenExistsInLocked(t *testing.T) {
 	llru := buildNewEmpty(t, 2)
@@ -489,6 +771,22 @@ func TestLen(t *testing.T) {
 	}
 }
 
+func TestLenLockedAndLenUnlocked(t *testing.T) {
+	lockedLen := 3
+	unlockedLen := 2
+	llru := buildPartiallyLocked(t, lockedLen, unlockedLen)
+
+	if llru.LenLocked() != lockedLen {
+		t.Errorf("expected `%v` but got %v", lockedLen, llru.LenLocked())
+	}
+	if llru.LenUnlocked() != unlockedLen {
+		t.Errorf("expected `%v` but got %v", unlockedLen, llru.LenUnlocked())
+	}
+	if llru.EvictableRoom() != unlockedLen {
+		t.Errorf("expected `%v` but got %v", unlockedLen, llru.EvictableRoom())
+	}
+}
+
 func TestEntriesLenEqualsLen(t *testing.T) {
 	lockedLen := 3
 	unlockedLen := 3
@@ -649,6 +947,64 @@ func TestValuesAreOrdered(t *testing.T) {
 	}
 }
 
+func TestFreezeAndThaw(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	llru.Freeze()
+
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if ok || evicted != nil {
+		t.Errorf("expected `false, nil` but got %v, %v", ok, evicted)
+	}
+	if oldest := llru.RemoveOldest(); oldest != nil {
+		t.Errorf("expected `nil` but got %v", oldest)
+	}
+
+	//existing entries can still be updated
+	ok, evicted = llru.AddOrUpdateUnlocked("new key1", "x")
+	if !ok || evicted != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", ok, evicted)
+	}
+
+	llru.Thaw()
+
+	ok, evicted = llru.AddOrUpdateUnlocked("new key3", "3")
+	if !ok || evicted == nil || evicted.Key != "new key2" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key2\", Value: \"2\"}` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+func TestForceRemove(t *testing.T) {
+	reasons := map[string]EvictionReason{}
+	llru := buildNewEmpty(t, 2)
+	llru.SetOnEvictedWithReason(func(key string, value string, reason EvictionReason) {
+		reasons[key] = reason
+	})
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_ = llru.Lock("new key1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	ok := llru.ForceRemove("new key1")
+	if !ok || llru.Contains("new key1") || reasons["new key1"] != EvictionReasonForced {
+		t.Errorf("expected `new key1` to be force removed but got %v, %v", ok, reasons)
+	}
+
+	ok = llru.ForceRemove("missing")
+	if ok {
+		t.Errorf("expected `false` but got %v", ok)
+	}
+
+	//the room used by the locked entry should be available again
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if !ok || evicted != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", ok, evicted)
+	}
+}
+
 func TestRemoveOldest(t *testing.T) {
 	llru := buildNewEmpty(t, 4)
 
@@ -731,3 +1087,2486 @@ func TestReplaceOldestValueCase2(t *testing.T) {
 		t.Errorf("expected `nil, nil, false` but got %v, %v, %v", oldValue, key, ok)
 	}
 }
+
+// If the key exists and is unlocked, it is locked, and `nil` is returned
+func TestTryLockCase1(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+	err := llru.TryLock("new key")
+	if err != nil {
+		t.Errorf("expected `nil` but got %v", err)
+	}
+
+	locked, _ := llru.IsLocked("new key")
+	if !locked {
+		t.Errorf("expected key to be locked")
+	}
+}
+
+// If the key exists and is locked, `ErrAlreadyLocked` is returned. Its lock count is unchanged
+func TestTryLockCase2(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+
+	_, _ = llru.AddOrUpdateLocked("new key", "x")
+	err := llru.TryLock("new key")
+	if !errors.Is(err, ErrAlreadyLocked) {
+		t.Errorf("expected `ErrAlreadyLocked` but got %v", err)
+	}
+
+	//a single unlock should be enough
+	_ = llru.Unlock("new key")
+	locked, _ := llru.IsLocked("new key")
+	if locked {
+		t.Errorf("expected key to be unlocked")
+	}
+}
+
+// If the key does not exist, `ErrKeyNotFound` is returned
+func TestTryLockCase3(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+
+	err := llru.TryLock("new key")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected `ErrKeyNotFound` but got %v", err)
+	}
+}
+
+func TestMaxLocked(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+	llru.SetMaxLocked(2)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+
+	ok := llru.Lock("new key2")
+	if !ok {
+		t.Errorf("expected `true` but got %v", ok)
+	}
+
+	ok = llru.Lock("new key3")
+	if ok {
+		t.Errorf("expected `false` but got %v", ok)
+	}
+
+	err := llru.TryLock("new key3")
+	if !errors.Is(err, ErrLockLimitReached) {
+		t.Errorf("expected `ErrLockLimitReached` but got %v", err)
+	}
+
+	ok, evicted := llru.AddOrUpdateLocked("new key4", "4")
+	if ok || evicted != nil {
+		t.Errorf("expected `false, nil` but got %v, %v", ok, evicted)
+	}
+
+	//updating an entry which is already locked is still allowed
+	ok, evicted = llru.AddOrUpdateLocked("new key1", "1")
+	if !ok || evicted != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", ok, evicted)
+	}
+}
+
+func TestGetAndLock(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+
+	value, ok := llru.GetAndLock("new key1")
+	if !ok || value != "1" {
+		t.Errorf("expected `1, true` but got %v, %v", value, ok)
+	}
+
+	locked, _ := llru.IsLocked("new key1")
+	if !locked {
+		t.Errorf("expected key to be locked")
+	}
+
+	value, ok = llru.GetAndLock("new key2")
+	if ok || value != "" {
+		t.Errorf("expected `\"\", false` but got %v, %v", value, ok)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+	_ = llru.Lock("new key")
+
+	lease, ok := llru.Acquire("new key")
+	if !ok || lease.Value() != "x" {
+		t.Fatalf("expected a lease on `x` but got %v, %v", lease, ok)
+	}
+
+	//closing twice should only release the lease's own lock
+	lease.Close()
+	lease.Close()
+
+	locked, _ := llru.IsLocked("new key")
+	if !locked {
+		t.Errorf("expected key to still be locked")
+	}
+
+	lease, ok = llru.Acquire("missing")
+	if ok || lease != nil {
+		t.Errorf("expected `nil, false` but got %v, %v", lease, ok)
+	}
+}
+
+func TestWithLockedUnlocksAfterPanic(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+
+	func() {
+		defer func() {
+			_ = recover()
+		}()
+		llru.WithLocked("new key", func(value string) {
+			locked, _ := llru.IsLocked("new key")
+			if !locked || value != "x" {
+				t.Errorf("expected `x` to be locked but got %v, %v", value, locked)
+			}
+			panic("failure")
+		})
+	}()
+
+	locked, _ := llru.IsLocked("new key")
+	if locked {
+		t.Errorf("expected key to be unlocked")
+	}
+
+	ok := llru.WithLocked("missing", func(value string) {
+		t.Errorf("expected function not to be called")
+	})
+	if ok {
+		t.Errorf("expected `false` but got %v", ok)
+	}
+}
+
+func TestLockOldestAndLockNewest(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+
+	oldest := llru.LockOldest()
+	if oldest == nil || oldest.Key != "new key1" || oldest.Value != "1" {
+		t.Errorf("expected `Entry{Key: \"new key1\", Value: \"1\"}` but got %v", oldest)
+	}
+
+	newest := llru.LockNewest()
+	if newest == nil || newest.Key != "new key3" || newest.Value != "3" {
+		t.Errorf("expected `Entry{Key: \"new key3\", Value: \"3\"}` but got %v", newest)
+	}
+
+	oldest = llru.LockOldest()
+	if oldest == nil || oldest.Key != "new key2" {
+		t.Errorf("expected `Entry{Key: \"new key2\", Value: \"2\"}` but got %v", oldest)
+	}
+
+	//no unlocked entries left
+	oldest = llru.LockOldest()
+	if oldest != nil {
+		t.Errorf("expected `nil` but got %v", oldest)
+	}
+}
+
+func TestSetLockStates(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	_ = llru.Lock("new key2")
+
+	failed := llru.SetLockStates(map[string]bool{
+		"new key1": true,
+		"new key2": false,
+		"missing":  true,
+	})
+	if !slices.Equal(failed, []string{"missing"}) {
+		t.Errorf("expected `[missing]` but got %v", failed)
+	}
+
+	locked, _ := llru.IsLocked("new key1")
+	if !locked {
+		t.Errorf("expected `new key1` to be locked")
+	}
+	locked, _ = llru.IsLocked("new key2")
+	if locked {
+		t.Errorf("expected `new key2` to be unlocked")
+	}
+}
+
+func TestReadPins(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+
+	if err := llru.RLock("new key"); err != nil {
+		t.Errorf("expected `nil` but got %v", err)
+	}
+	if err := llru.RLock("new key"); err != nil {
+		t.Errorf("expected `nil` but got %v", err)
+	}
+	if err := llru.WLock("new key"); !errors.Is(err, ErrReadLocked) {
+		t.Errorf("expected `ErrReadLocked` but got %v", err)
+	}
+
+	_ = llru.RUnlock("new key")
+	locked, _ := llru.IsLocked("new key")
+	if !locked {
+		t.Errorf("expected key to still be locked")
+	}
+
+	_ = llru.RUnlock("new key")
+	locked, _ = llru.IsLocked("new key")
+	if locked {
+		t.Errorf("expected key to be unlocked")
+	}
+
+	if ok := llru.RUnlock("new key"); ok {
+		t.Errorf("expected `false` but got %v", ok)
+	}
+}
+
+func TestWritePins(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+
+	if err := llru.WLock("new key"); err != nil {
+		t.Errorf("expected `nil` but got %v", err)
+	}
+	if err := llru.WLock("new key"); !errors.Is(err, ErrWriteLocked) {
+		t.Errorf("expected `ErrWriteLocked` but got %v", err)
+	}
+	if err := llru.RLock("new key"); !errors.Is(err, ErrWriteLocked) {
+		t.Errorf("expected `ErrWriteLocked` but got %v", err)
+	}
+	if err := llru.WLock("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected `ErrKeyNotFound` but got %v", err)
+	}
+
+	//a plain lock and unlock should not release the write pin
+	_ = llru.Lock("new key")
+	_ = llru.Unlock("new key")
+	locked, _ := llru.IsLocked("new key")
+	if !locked {
+		t.Errorf("expected key to still be locked")
+	}
+
+	_ = llru.WUnlock("new key")
+	locked, _ = llru.IsLocked("new key")
+	if locked {
+		t.Errorf("expected key to be unlocked")
+	}
+}
+
+func TestOwners(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+	_ = llru.LockAs("new key", "worker1")
+	_ = llru.LockAs("new key", "worker2")
+	_ = llru.Lock("new key")
+
+	owners := llru.Owners("new key")
+	if !slices.Equal(owners, []string{"worker1", "worker2"}) {
+		t.Errorf("expected `[worker1 worker2]` but got %v", owners)
+	}
+
+	ok := llru.UnlockAs("new key", "worker3")
+	if ok {
+		t.Errorf("expected `false` but got %v", ok)
+	}
+
+	ok = llru.UnlockAs("new key", "worker1")
+	owners = llru.Owners("new key")
+	if !ok || !slices.Equal(owners, []string{"worker2"}) {
+		t.Errorf("expected `true, [worker2]` but got %v, %v", ok, owners)
+	}
+}
+
+func TestLockManyAndUnlockMany(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	ok := llru.LockMany([]string{"new key1", "missing", "new key2"})
+	if !slices.Equal(ok, []bool{true, false, true}) {
+		t.Errorf("expected `[true false true]` but got %v", ok)
+	}
+	if llru.LenLocked() != 2 {
+		t.Errorf("expected `2` locked entries but got %v", llru.LenLocked())
+	}
+
+	ok = llru.UnlockMany([]string{"new key2", "missing", "new key1"})
+	if !slices.Equal(ok, []bool{true, false, true}) {
+		t.Errorf("expected `[true false true]` but got %v", ok)
+	}
+	if llru.LenLocked() != 0 {
+		t.Errorf("expected `0` locked entries but got %v", llru.LenLocked())
+	}
+}
+
+func TestUnlockAll(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateLocked("new key3", "3")
+	_ = llru.Lock("new key3")
+
+	count := llru.UnlockAll()
+	if count != 2 {
+		t.Errorf("expected `2` but got %v", count)
+	}
+
+	keys := llru.Keys()
+	if !slices.Equal(keys, []string{"new key2", "new key1", "new key3"}) {
+		t.Errorf("expected previously locked keys to be newest, in order, but got %v", keys)
+	}
+	if llru.LenLocked() != 0 {
+		t.Errorf("expected `0` locked entries but got %v", llru.LenLocked())
+	}
+}
+
+// UnlockAll first removes the expired entries, together, and does not count them
+func TestUnlockAllExpired(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru.SetClock(clock)
+	llru.SetExpireLocked(true)
+	var batches [][]Entry[string, string]
+	llru.SetOnEvictedBatch(func(entries []Entry[string, string]) {
+		batches = append(batches, entries)
+	})
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	for _, key := range []string{"new key1", "new key2", "new key3"} {
+		_ = llru.ResetTTL(key, time.Minute)
+	}
+	_, _ = llru.AddOrUpdateLocked("new key4", "4")
+	clock.now = clock.now.Add(time.Hour)
+
+	if count := llru.UnlockAll(); count != 1 {
+		t.Errorf("expected `1` but got %v", count)
+	}
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Errorf("expected the 3 expired entries in a single batch but got %v", batches)
+	}
+}
+
+// If the key exists, it is locked until the deadline, and `true` is returned
+func TestLockForCase1(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+	ok := llru.LockFor("new key", 10*time.Millisecond)
+	if !ok {
+		t.Errorf("expected `true` but got %v", ok)
+	}
+
+	//key should be locked until the deadline
+	ok, evicted := llru.AddOrUpdateUnlocked("new key1", "1")
+	if ok || evicted != nil {
+		t.Errorf("expected `false, nil` but got %v, %v", ok, evicted)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	//lock should have been released
+	ok, evicted = llru.AddOrUpdateUnlocked("new key1", "1")
+	if !ok || evicted == nil || evicted.Key != "new key" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key\", Value: \"x\"}` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+func TestOnAutoUnlock(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	autoUnlocked := []string{}
+	llru.SetOnAutoUnlock(func(key string, value string) {
+		autoUnlocked = append(autoUnlocked, key)
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.LockFor("new key1", time.Millisecond)
+	_ = llru.LockFor("new key2", time.Minute)
+
+	time.Sleep(5 * time.Millisecond)
+
+	released := llru.ReleaseExpiredLocks()
+	if released != 1 || !slices.Equal(autoUnlocked, []string{"new key1"}) {
+		t.Errorf("expected `new key1` to be auto-unlocked but got %v, %v", released, autoUnlocked)
+	}
+}
+
+// If the key does not exist, returns `false`
+// Timed locks are only looked at once the earliest deadline has passed, and later deadlines are still honoured after
+// the earliest timed lock was unlocked by hand
+func TestLockForEarliestDeadline(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru.SetClock(clock)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.LockFor("new key1", time.Minute)
+	_ = llru.LockFor("new key2", time.Hour)
+	if !llru.nextLockRelease.Equal(clock.now.Add(time.Minute)) {
+		t.Errorf("expected the next release in a minute but got %v", llru.nextLockRelease)
+	}
+
+	_ = llru.Unlock("new key1")
+	clock.now = clock.now.Add(2 * time.Minute)
+	if released := llru.ReleaseExpiredLocks(); released != 0 {
+		t.Errorf("expected no lock to be released but got %v", released)
+	}
+	if !llru.nextLockRelease.Equal(time.Unix(0, 0).Add(time.Hour)) {
+		t.Errorf("expected the next release in an hour but got %v", llru.nextLockRelease)
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	if locked, _ := llru.IsLocked("new key2"); locked {
+		t.Errorf("expected `new key2` to be unlocked")
+	}
+}
+
+func TestLockForCase2(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+
+	ok := llru.LockFor("new key", time.Minute)
+	if ok {
+		t.Errorf("expected `false` but got %v", ok)
+	}
+}
+
+func TestLockForOnlyReleasesTimedLock(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+
+	_, _ = llru.AddOrUpdateLocked("new key", "x")
+	_ = llru.LockFor("new key", time.Millisecond)
+
+	time.Sleep(5 * time.Millisecond)
+
+	locked, _ := llru.IsLocked("new key")
+	if !locked {
+		t.Errorf("expected key to still be locked")
+	}
+}
+
+// If the key exists and is locked more than once, its lock count is decremented and `true` is returned. It stays locked
+func TestUnlockCountedLocks(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+	_ = llru.Lock("new key")
+	_ = llru.Lock("new key")
+
+	ok := llru.Unlock("new key")
+	if !ok {
+		t.Errorf("expected `true` but got %v", ok)
+	}
+
+	//key should still be locked, try adding another to confirm lock
+	ok, evicted := llru.AddOrUpdateUnlocked("new key1", "1")
+	if ok || evicted != nil {
+		t.Errorf("expected `false, nil` but got %v, %v", ok, evicted)
+	}
+
+	_ = llru.Unlock("new key")
+
+	//key should now be unlocked and evictable
+	ok, evicted = llru.AddOrUpdateUnlocked("new key1", "1")
+	if !ok || evicted == nil || evicted.Key != "new key" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key\", Value: \"x\"}` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+func TestUnlockWithoutLockCounting(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+	llru.SetLockCounting(false)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+	_ = llru.Lock("new key")
+	_ = llru.Lock("new key")
+	_ = llru.Unlock("new key")
+
+	//a single unlock should be enough
+	ok, evicted := llru.AddOrUpdateUnlocked("new key1", "1")
+	if !ok || evicted == nil || evicted.Key != "new key" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key\", Value: \"x\"}` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+func TestIsLocked(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	locked, exists := llru.IsLocked("new key1")
+	if !locked || !exists {
+		t.Errorf("expected `true, true` but got %v, %v", locked, exists)
+	}
+
+	locked, exists = llru.IsLocked("new key2")
+	if locked || !exists {
+		t.Errorf("expected `false, true` but got %v, %v", locked, exists)
+	}
+
+	locked, exists = llru.IsLocked("new key3")
+	if locked || exists {
+		t.Errorf("expected `false, false` but got %v, %v", locked, exists)
+	}
+}
+
+// With the ARC policy, entries used more than once survive a scan of entries used only once
+func TestARCPolicyResistsScans(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](3, ARCPolicy(), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_ = llru.Get("new key1")
+	_ = llru.Get("new key2")
+
+	for _, key := range []string{"scan1", "scan2", "scan3"} {
+		_, _ = llru.AddOrUpdateUnlocked(key, key)
+	}
+
+	if !llru.Contains("new key1") || !llru.Contains("new key2") {
+		t.Errorf("expected `new key1` and `new key2` to survive the scan but got keys %v", llru.Keys())
+	}
+}
+
+// With the ARC policy, an entry used before it was locked is still considered frequently used once unlocked
+func TestARCPolicyRemembersUseAcrossLock(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](2, ARCPolicy(), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_ = llru.Get("new key1")
+	_ = llru.Lock("new key1")
+	_ = llru.Unlock("new key1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if !ok || evicted == nil || evicted.Key != "new key2" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key2\", Value: \"2\"}` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+// With the 2Q policy, entries used more than once survive a scan of entries used only once
+func TestTwoQueuePolicyResistsScans(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](4, TwoQueuePolicy(), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.Get("new key1")
+	_ = llru.Get("new key2")
+
+	for _, key := range []string{"scan1", "scan2", "scan3", "scan4"} {
+		_, _ = llru.AddOrUpdateUnlocked(key, key)
+	}
+
+	if !llru.Contains("new key1") || !llru.Contains("new key2") {
+		t.Errorf("expected `new key1` and `new key2` to survive the scan but got keys %v", llru.Keys())
+	}
+}
+
+// If a 2Q ratio is out of range, an error is returned
+func TestTwoQueuePolicyInvalidRatio(t *testing.T) {
+	_, err := NewUnsafeWithPolicy[string, string](4, TwoQueuePolicyWithParams(1.5, 0.5), nil)
+	if err == nil {
+		t.Errorf("expected an error but got %v", err)
+	}
+
+	_, err = NewUnsafeWithPolicy[string, string](4, TwoQueuePolicyWithParams(0.5, -1), nil)
+	if err == nil {
+		t.Errorf("expected an error but got %v", err)
+	}
+}
+
+// With the SLRU policy, entries used more than once survive a scan of entries used only once
+func TestSLRUPolicyResistsScans(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](5, SLRUPolicy(), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.Get("new key1")
+	_ = llru.Get("new key2")
+
+	for _, key := range []string{"scan1", "scan2", "scan3", "scan4", "scan5"} {
+		_, _ = llru.AddOrUpdateUnlocked(key, key)
+	}
+
+	if !llru.Contains("new key1") || !llru.Contains("new key2") {
+		t.Errorf("expected `new key1` and `new key2` to survive the scan but got keys %v", llru.Keys())
+	}
+}
+
+// With the SLRU policy, when the protected segment is full, its oldest entry is demoted and evicted first
+func TestSLRUPolicyDemotesProtectedOverflow(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](2, SLRUPolicyWithParams(0.5), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.Get("new key1")
+	_ = llru.Get("new key2")
+
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if !ok || evicted == nil || evicted.Key != "new key1" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key1\", Value: \"1\"}` evicted but got %v, %v", ok, evicted)
+	}
+
+	_, err = NewUnsafeWithPolicy[string, string](2, SLRUPolicyWithParams(2), nil)
+	if err == nil {
+		t.Errorf("expected an error but got %v", err)
+	}
+}
+
+// With the ARC policy and no size, the ghost lists hold no more keys than the cache holds entries
+func TestARCPolicyUnbounded(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](0, ARCPolicy(), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+	llru.SetWeigher(func(key string, value string) int { return 1 }, 10)
+
+	for i := 0; i < 100; i++ {
+		_, _ = llru.AddOrUpdateUnlocked(strconv.Itoa(i), "x")
+		_ = llru.Get(strconv.Itoa(i / 2))
+	}
+
+	arc := llru.unlocked.policy.(*arcPolicy[string, string])
+	if l := llru.Len(); l != 10 {
+		t.Errorf("expected `10` but got %v", l)
+	}
+	if arc.recentEvict.Len() > 10 || arc.frequentEvict.Len() > 10 {
+		t.Errorf("expected at most 10 keys per ghost list but got %v and %v", arc.recentEvict.Len(), arc.frequentEvict.Len())
+	}
+}
+
+// With the 2Q policy and no size, a ghost list as large as the cache does not overflow
+func TestTwoQueuePolicyUnbounded(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](0, TwoQueuePolicyWithParams(0.25, 1.0), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	llru.SetWeigher(func(key string, value string) int { return 1 }, 10)
+	for i := 0; i < 100; i++ {
+		_, _ = llru.AddOrUpdateUnlocked(strconv.Itoa(i), "x")
+	}
+
+	twoQueue := llru.unlocked.policy.(*twoQueuePolicy[string, string])
+	if l := llru.Len(); l != 10 {
+		t.Errorf("expected `10` but got %v", l)
+	}
+	if twoQueue.recentEvict.Len() > 10 {
+		t.Errorf("expected at most 10 ghost keys but got %v", twoQueue.recentEvict.Len())
+	}
+}
+
+// With the SLRU policy and no size, a protected segment as large as the cache does not overflow
+func TestSLRUPolicyUnbounded(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](0, SLRUPolicyWithParams(1.0), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		_, _ = llru.AddOrUpdateUnlocked(strconv.Itoa(i), "x")
+		_ = llru.Get(strconv.Itoa(i))
+	}
+
+	if l := llru.Len(); l != 100 {
+		t.Errorf("expected `100` but got %v", l)
+	}
+}
+
+// If a locked value is added and an entry is evicted, every evicted entry is returned
+func TestAddOrUpdateLockedAll(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	ok, evicted := llru.AddOrUpdateLockedAll("new key3", "3")
+	if !ok || len(evicted) != 1 || evicted[0].Key != "new key1" {
+		t.Errorf("expected `true` and `[Entry{Key: \"new key1\", Value: \"1\"}]` evicted but got %v, %v", ok, evicted)
+	}
+
+	ok, evicted = llru.AddOrUpdateLockedAll("new key3", "3")
+	if !ok || len(evicted) != 0 {
+		t.Errorf("expected `true` and no evicted entries but got %v, %v", ok, evicted)
+	}
+}
+
+// EvictN evicts up to n of the oldest unlocked entries, never locked ones
+func TestEvictN(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_, _ = llru.AddOrUpdateUnlocked("new key4", "4")
+
+	evicted := llru.EvictN(2)
+	if len(evicted) != 2 || evicted[0].Key != "new key2" || evicted[1].Key != "new key3" {
+		t.Errorf("expected `new key2` and `new key3` evicted but got %v", evicted)
+	}
+
+	evicted = llru.EvictN(5)
+	if len(evicted) != 1 || evicted[0].Key != "new key4" {
+		t.Errorf("expected `new key4` evicted but got %v", evicted)
+	}
+	if !llru.Contains("new key1") {
+		t.Errorf("expected locked `new key1` to remain")
+	}
+}
+
+// Purge removes every entry, locked ones included, and calls the eviction callback for each
+func TestPurge(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+	var purged []string
+	llru.SetOnEvictedWithReason(func(key string, value string, reason EvictionReason) {
+		if reason == EvictionReasonPurged {
+			purged = append(purged, key)
+		}
+	})
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+
+	removed := llru.Purge()
+	if removed != 3 || !slices.Equal(purged, []string{"new key2", "new key3", "new key1"}) {
+		t.Errorf("expected `3` and `[new key2 new key3 new key1]` purged but got %v, %v", removed, purged)
+	}
+	if llru.Len() != 0 || llru.Contains("new key1") {
+		t.Errorf("expected an empty cache but got keys %v", llru.Keys())
+	}
+
+	//the whole size is available again
+	for _, key := range []string{"a", "b", "c"} {
+		if ok, evicted := llru.AddOrUpdateUnlocked(key, key); !ok || evicted != nil {
+			t.Errorf("expected `true, nil` but got %v, %v", ok, evicted)
+		}
+	}
+}
+
+// PurgeUnlocked removes every unlocked entry and keeps locked ones
+func TestPurgeUnlocked(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+
+	removed := llru.PurgeUnlocked()
+	if removed != 2 || !slices.Equal(llru.Keys(), []string{"new key1"}) {
+		t.Errorf("expected `2` and keys `[new key1]` but got %v, %v", removed, llru.Keys())
+	}
+}
+
+//overflow handler backed by a map
+type mapOverflow map[string]string
+
+func (m mapOverflow) Store(key string, value string) {
+	m[key] = value
+}
+
+func (m mapOverflow) Load(key string) (string, bool) {
+	value, ok := m[key]
+	delete(m, key)
+	return value, ok
+}
+
+// Entries evicted for lack of room go to the overflow handler, and Get loads them back on a miss
+func TestOverflowHandler(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+	overflow := mapOverflow{}
+	llru.SetOverflowHandler(overflow)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	if overflow["new key1"] != "1" {
+		t.Errorf("expected `new key1` to be stored in the overflow handler but got %v", overflow)
+	}
+
+	value := llru.Get("new key1")
+	if value == nil || *value != "1" {
+		t.Errorf("expected `1` but got %v", value)
+	}
+	if !llru.Contains("new key1") || overflow["new key2"] != "2" {
+		t.Errorf("expected `new key1` back in the cache and `new key2` in the overflow handler but got %v, %v", llru.Keys(), overflow)
+	}
+
+	if value = llru.Get("new key3"); value != nil {
+		t.Errorf("expected `nil` but got %v", *value)
+	}
+}
+
+// With the MRU policy, the most recently used unlocked entry is evicted to make room
+func TestMRUPolicy(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](2, MRUPolicy(), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.Get("new key1")
+
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if !ok || evicted == nil || evicted.Key != "new key1" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key1\", Value: \"1\"}` evicted but got %v, %v", ok, evicted)
+	}
+	if !llru.Contains("new key3") {
+		t.Errorf("expected `new key3` to be added but got keys %v", llru.Keys())
+	}
+}
+
+// With a victim score, the unlocked entry with the lowest score is evicted
+func TestVictimScore(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+	llru.SetVictimScore(func(key string, value string, age time.Duration) float64 {
+		cost, _ := strconv.Atoi(value)
+		return float64(cost)
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "30")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "10")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "20")
+
+	ok, evicted := llru.AddOrUpdateUnlocked("new key4", "40")
+	if !ok || evicted == nil || evicted.Key != "new key2" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key2\", Value: \"10\"}` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+// With a minimum residency, recently added entries are only evicted if there are no other candidates
+func TestMinResidency(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru.SetClock(clock)
+	llru.SetMinResidency(time.Minute)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	clock.now = clock.now.Add(time.Minute)
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.Get("new key1")
+
+	//`new key2` is the least recently used, but has not been resident long enough
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if !ok || evicted == nil || evicted.Key != "new key1" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key1\", Value: \"1\"}` evicted but got %v, %v", ok, evicted)
+	}
+
+	//every entry is within its grace period, so the least recently used is evicted
+	ok, evicted = llru.AddOrUpdateUnlocked("new key4", "4")
+	if !ok || evicted == nil || evicted.Key != "new key2" {
+		t.Errorf("expected `true` and `Entry{Key: \"new key2\", Value: \"2\"}` evicted but got %v, %v", ok, evicted)
+	}
+}
+
+// The batch eviction callback is called once per operation with every entry it evicted
+func TestOnEvictedBatch(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+	var batches [][]Entry[string, string]
+	llru.SetOnEvictedBatch(func(entries []Entry[string, string]) {
+		batches = append(batches, entries)
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	if len(batches) != 0 {
+		t.Errorf("expected no batches but got %v", batches)
+	}
+
+	_ = llru.EvictN(2)
+	_, _ = llru.AddOrUpdateLocked("new key4", "4")
+	_ = llru.Purge()
+
+	expected := [][]Entry[string, string]{
+		{{Key: "new key1", Value: "1"}, {Key: "new key2", Value: "2"}},
+		{{Key: "new key3", Value: "3"}, {Key: "new key4", Value: "4"}},
+	}
+	if len(batches) != len(expected) || !slices.Equal(batches[0], expected[0]) || !slices.Equal(batches[1], expected[1]) {
+		t.Errorf("expected %v but got %v", expected, batches)
+	}
+}
+
+// With a default TTL, unlocked entries expire once it has elapsed, unless given another lifetime, and locked entries
+// only expire once unlocked
+func TestDefaultTTL(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+	llru.SetDefaultTTL(20 * time.Millisecond)
+	var expired []string
+	llru.SetOnEvictedWithReason(func(key string, value string, reason EvictionReason) {
+		if reason == EvictionReasonExpired {
+			expired = append(expired, key)
+		}
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlockedWithTTL("new key2", "2", 0)
+	_, _ = llru.AddOrUpdateLocked("new key3", "3")
+	time.Sleep(30 * time.Millisecond)
+
+	if llru.Contains("new key1") || !llru.Contains("new key2") || !llru.Contains("new key3") {
+		t.Errorf("expected only `new key1` to expire but got keys %v", llru.Keys())
+	}
+
+	_ = llru.Unlock("new key3")
+	if llru.Contains("new key3") {
+		t.Errorf("expected `new key3` to expire once unlocked but got keys %v", llru.Keys())
+	}
+	if !slices.Equal(expired, []string{"new key1", "new key3"}) {
+		t.Errorf("expected `[new key1 new key3]` expired but got %v", expired)
+	}
+}
+
+// With expire-after-access, reading an entry restarts its lifetime
+func TestExpireAfterAccess(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+	llru.SetDefaultTTL(40 * time.Millisecond)
+	llru.SetExpireAfterAccess(true)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	time.Sleep(25 * time.Millisecond)
+	_ = llru.Get("new key1")
+	time.Sleep(25 * time.Millisecond)
+
+	if !llru.Contains("new key1") || llru.Contains("new key2") {
+		t.Errorf("expected only `new key2` to expire but got keys %v", llru.Keys())
+	}
+}
+
+// GetWithExpiry returns the value along with the time at which it expires, zero if it never does
+func TestGetWithExpiry(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	before := time.Now()
+	_, _ = llru.AddOrUpdateUnlockedWithTTL("new key1", "1", time.Minute)
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	value, expiresAt, ok := llru.GetWithExpiry("new key1")
+	if !ok || value != "1" || expiresAt.Before(before.Add(time.Minute)) || expiresAt.After(time.Now().Add(time.Minute)) {
+		t.Errorf("expected `1`, a minute from now and `true` but got %v, %v, %v", value, expiresAt, ok)
+	}
+
+	value, expiresAt, ok = llru.GetWithExpiry("new key2")
+	if !ok || value != "2" || !expiresAt.IsZero() {
+		t.Errorf("expected `2`, zero time and `true` but got %v, %v, %v", value, expiresAt, ok)
+	}
+
+	_, _, ok = llru.GetWithExpiry("new key3")
+	if ok {
+		t.Errorf("expected `false` but got %v", ok)
+	}
+}
+
+// ResetTTL and ExtendTTL change when an entry expires without changing its recency
+func TestResetAndExtendTTL(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+	llru.SetDefaultTTL(20 * time.Millisecond)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	if !llru.ResetTTL("new key1", 0) || !llru.ExtendTTL("new key2", time.Minute) {
+		t.Errorf("expected `true` for existing keys")
+	}
+	if llru.ResetTTL("new key3", time.Minute) || llru.ExtendTTL("new key3", time.Minute) {
+		t.Errorf("expected `false` for a missing key")
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if !slices.Equal(llru.Keys(), []string{"new key1", "new key2"}) {
+		t.Errorf("expected keys `[new key1 new key2]` but got %v", llru.Keys())
+	}
+}
+
+// With SetExpireLocked, locked entries expire like unlocked ones, and their room becomes available
+func TestExpireLocked(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+	llru.SetDefaultTTL(20 * time.Millisecond)
+	llru.SetExpireLocked(true)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_ = llru.Lock("new key1")
+	llru.SetDefaultTTL(0)
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	time.Sleep(30 * time.Millisecond)
+
+	if llru.Contains("new key1") || !llru.Contains("new key2") {
+		t.Errorf("expected only `new key1` to expire but got keys %v", llru.Keys())
+	}
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if !ok || evicted != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", ok, evicted)
+	}
+}
+
+// RemoveExpired removes and returns every expired entry, earliest deadline first
+func TestRemoveExpired(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	_, _ = llru.AddOrUpdateUnlockedWithTTL("new key1", "1", 20 * time.Millisecond)
+	_, _ = llru.AddOrUpdateUnlockedWithTTL("new key2", "2", 10 * time.Millisecond)
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	time.Sleep(30 * time.Millisecond)
+
+	removed := llru.RemoveExpired()
+	expected := []Entry[string, string]{{Key: "new key2", Value: "2"}, {Key: "new key1", Value: "1"}}
+	if !slices.Equal(removed, expected) {
+		t.Errorf("expected %v but got %v", expected, removed)
+	}
+	if removed = llru.RemoveExpired(); len(removed) != 0 {
+		t.Errorf("expected no entries but got %v", removed)
+	}
+}
+
+// When an expiration callback is set, expired entries are passed to it instead of the eviction callbacks
+func TestOnExpired(t *testing.T) {
+	var evicted, expired []string
+	llru, err := NewUnsafeWithEvict[string, string](1, func(key string, value string) {
+		evicted = append(evicted, key)
+	})
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+	llru.SetOnExpired(func(key string, value string) {
+		expired = append(expired, key)
+	})
+
+	_, _ = llru.AddOrUpdateUnlockedWithTTL("new key1", "1", 10 * time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+
+	if !slices.Equal(expired, []string{"new key1"}) || !slices.Equal(evicted, []string{"new key2"}) {
+		t.Errorf("expected `[new key1]` expired and `[new key2]` evicted but got %v, %v", expired, evicted)
+	}
+}
+
+//clock which only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// Lifetimes and timed locks follow the clock set with SetClock
+func TestSetClock(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru.SetClock(clock)
+
+	_, _ = llru.AddOrUpdateUnlockedWithTTL("new key1", "1", time.Hour)
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.LockFor("new key2", time.Minute)
+
+	clock.now = clock.now.Add(time.Minute)
+	if locked, _ := llru.IsLocked("new key2"); locked || !llru.Contains("new key1") {
+		t.Errorf("expected `new key2` unlocked and `new key1` present but got %v, %v", locked, llru.Keys())
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	if llru.Contains("new key1") {
+		t.Errorf("expected `new key1` to expire but got keys %v", llru.Keys())
+	}
+}
+
+// With a TTL jitter, lifetimes vary within the jitter
+func TestTTLJitter(t *testing.T) {
+	llru := buildNewEmpty(t, 100)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru.SetClock(clock)
+	llru.SetTTLJitter(0.1)
+
+	deadlines := map[time.Time]bool{}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		_, _ = llru.AddOrUpdateUnlockedWithTTL(key, key, 10 * time.Minute)
+		_, expiresAt, _ := llru.GetWithExpiry(key)
+		if expiresAt.Before(clock.now.Add(9 * time.Minute)) || expiresAt.After(clock.now.Add(11 * time.Minute)) {
+			t.Errorf("expected a deadline between 9 and 11 minutes from now but got %v", expiresAt.Sub(clock.now))
+		}
+		deadlines[expiresAt] = true
+	}
+	if len(deadlines) < 2 {
+		t.Errorf("expected varying deadlines but got %v", deadlines)
+	}
+}
+
+// With a weigher, unlocked entries are evicted to keep the total cost within the maximum, and entries which cannot fit
+// are refused
+func TestWeigher(t *testing.T) {
+	llru := buildNewEmpty(t, 10)
+	llru.SetWeigher(func(key string, value string) int {
+		return len(value)
+	}, 10)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "12345")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "1234")
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "123")
+	if !ok || evicted == nil || evicted.Key != "new key1" || llru.Weight() != 7 {
+		t.Errorf("expected `true`, `new key1` evicted and a weight of 7 but got %v, %v, %v", ok, evicted, llru.Weight())
+	}
+
+	_ = llru.Lock("new key2")
+	ok, _ = llru.AddOrUpdateLocked("new key4", "1234567")
+	if ok {
+		t.Errorf("expected `false` but got %v", ok)
+	}
+	ok, _ = llru.AddOrUpdateUnlocked("new key5", "12345678901")
+	if ok {
+		t.Errorf("expected `false` but got %v", ok)
+	}
+	if !slices.Equal(llru.Keys(), []string{"new key3", "new key2"}) {
+		t.Errorf("expected keys `[new key3 new key2]` but got %v", llru.Keys())
+	}
+}
+
+// The cost of the locked entries follows the entries being locked, unlocked, updated and removed
+func TestWeigherLockedWeight(t *testing.T) {
+	llru := buildNewEmpty(t, 10)
+	llru.SetWeigher(func(key string, value string) int {
+		return len(value)
+	}, 10)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1234")
+	_, _ = llru.AddOrUpdateLocked("new key2", "123")
+	_ = llru.Unlock("new key2")
+	_, _ = llru.Update("new key1", func(old string, exists bool) (string, bool) {
+		return "123456", true
+	})
+	if ok, _ := llru.AddOrUpdateLocked("new key3", "12345"); ok {
+		t.Errorf("expected `false` but got %v", ok)
+	}
+	if ok, evicted := llru.AddOrUpdateUnlocked("new key3", "1234"); !ok || evicted == nil || evicted.Key != "new key2" {
+		t.Errorf("expected `true` and `new key2` evicted but got %v, %v", ok, evicted)
+	}
+
+	_, _ = llru.Remove("new key1")
+	if ok, _ := llru.AddOrUpdateLocked("new key4", "123456"); !ok {
+		t.Errorf("expected `true` but got %v", ok)
+	}
+	_ = llru.UnlockAll()
+	if llru.lockedWeight != 0 {
+		t.Errorf("expected a locked weight of 0 but got %v", llru.lockedWeight)
+	}
+}
+
+// ByteSizeWeigher caps the total length of the values, which LenBytes reports
+func TestByteSizeWeigher(t *testing.T) {
+	llru, err := NewUnsafe[string, []byte](10)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+	llru.SetWeigher(ByteSizeWeigher[string, []byte], 8)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", []byte("1234"))
+	_, _ = llru.AddOrUpdateUnlocked("new key2", []byte("123"))
+	if llru.LenBytes() != 7 {
+		t.Errorf("expected 7 but got %v", llru.LenBytes())
+	}
+
+	_, evicted := llru.AddOrUpdateUnlocked("new key3", []byte("12"))
+	if evicted == nil || evicted.Key != "new key1" || llru.LenBytes() != 5 {
+		t.Errorf("expected `new key1` evicted and 5 bytes but got %v, %v", evicted, llru.LenBytes())
+	}
+}
+
+// LenBytes counts named string and byte slice types, as ByteSizeWeigher does
+func TestLenBytesNamedTypes(t *testing.T) {
+	type blob []byte
+	blobs, err := NewUnsafe[string, blob](10)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+	_, _ = blobs.AddOrUpdateUnlocked("new key1", blob("1234"))
+	_, _ = blobs.AddOrUpdateLocked("new key2", blob("123"))
+	if blobs.LenBytes() != 7 {
+		t.Errorf("expected 7 but got %v", blobs.LenBytes())
+	}
+
+	type text string
+	texts, err := NewUnsafe[string, any](10)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+	_, _ = texts.AddOrUpdateUnlocked("new key1", text("12"))
+	_, _ = texts.AddOrUpdateUnlocked("new key2", "123")
+	_, _ = texts.AddOrUpdateUnlocked("new key3", 1234)
+	_, _ = texts.AddOrUpdateUnlocked("new key4", nil)
+	if texts.LenBytes() != 5 {
+		t.Errorf("expected 5 but got %v", texts.LenBytes())
+	}
+}
+
+// Resize evicts the unlocked entries which no longer fit, and never shrinks below the number of locked entries
+func TestResize(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_, _ = llru.AddOrUpdateUnlocked("new key4", "4")
+
+	evicted, err := llru.Resize(1)
+	if !errors.Is(err, ErrTooManyLocked) || evicted != nil {
+		t.Errorf("expected `nil, ErrTooManyLocked` but got %v, %v", evicted, err)
+	}
+
+	evicted, err = llru.Resize(3)
+	if err != nil || len(evicted) != 1 || evicted[0].Key != "new key3" {
+		t.Errorf("expected `new key3` evicted but got %v, %v", evicted, err)
+	}
+
+	evicted, err = llru.Resize(5)
+	if err != nil || len(evicted) != 0 {
+		t.Errorf("expected no evicted entries but got %v, %v", evicted, err)
+	}
+	ok, evictedEntry := llru.AddOrUpdateUnlocked("new key5", "5")
+	_, _ = llru.AddOrUpdateUnlocked("new key6", "6")
+	if !ok || evictedEntry != nil || llru.Len() != 5 {
+		t.Errorf("expected 5 entries but got %v", llru.Keys())
+	}
+}
+
+func TestUnlimitedLocked(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+	llru.SetUnlimitedLocked(true)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	ok, evicted := llru.AddOrUpdateLocked("new key3", "3")
+	if !ok || evicted != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", ok, evicted)
+	}
+
+	//locked entries leave the whole size to the unlocked entries
+	_, _ = llru.AddOrUpdateUnlocked("new key4", "4")
+	ok, evicted = llru.AddOrUpdateUnlocked("new key5", "5")
+	if !ok || evicted != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", ok, evicted)
+	}
+	if room := llru.EvictableRoom(); room != 2 {
+		t.Errorf("expected `2` but got %v", room)
+	}
+
+	ok, evicted = llru.AddOrUpdateUnlocked("new key6", "6")
+	if !ok || evicted == nil || evicted.Key != "new key4" {
+		t.Errorf("expected `true, new key4` but got %v, %v", ok, evicted)
+	}
+	if l := llru.Len(); l != 5 {
+		t.Errorf("expected `5` but got %v", l)
+	}
+
+	evictedAll, err := llru.Resize(1)
+	if err != nil || len(evictedAll) != 1 || evictedAll[0].Key != "new key5" {
+		t.Errorf("expected `new key5` evicted but got %v, %v", evictedAll, err)
+	}
+
+	//with three locked entries, no room is left for unlocked entries
+	evictedAll = llru.SetUnlimitedLocked(false)
+	if len(evictedAll) != 1 || evictedAll[0].Key != "new key6" {
+		t.Errorf("expected `new key6` evicted but got %v", evictedAll)
+	}
+	ok, evicted = llru.AddOrUpdateUnlocked("new key7", "7")
+	if ok || evicted != nil {
+		t.Errorf("expected `false, nil` but got %v, %v", ok, evicted)
+	}
+}
+
+func TestApplyMemoryPressure(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	_, err := llru.ApplyMemoryPressure(0.5)
+	if err == nil {
+		t.Errorf("expected an error without size bounds")
+	}
+	if err := llru.SetSizeBounds(3, 2); err == nil {
+		t.Errorf("expected an error for invalid size bounds")
+	}
+	if err := llru.SetSizeBounds(2, 6); err != nil {
+		t.Errorf("expected no error but got %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_, _ = llru.AddOrUpdateUnlocked("new key4", "4")
+
+	evicted, err := llru.ApplyMemoryPressure(0.5)
+	if err != nil || len(evicted) != 0 || llru.EvictableRoom() != 3 {
+		t.Errorf("expected size 4 and no eviction but got %v, %v", evicted, err)
+	}
+
+	evicted, err = llru.ApplyMemoryPressure(2)
+	if err != nil || len(evicted) != 2 || evicted[0].Key != "new key2" || evicted[1].Key != "new key3" {
+		t.Errorf("expected `new key2, new key3` evicted but got %v, %v", evicted, err)
+	}
+
+	evicted, err = llru.ApplyMemoryPressure(0)
+	if err != nil || len(evicted) != 0 || llru.EvictableRoom() != 5 {
+		t.Errorf("expected size 6 and no eviction but got %v, %v", evicted, err)
+	}
+}
+
+// The size never shrinks below the room taken by locked entries and reservations
+func TestApplyMemoryPressureKeepsReservedRoom(t *testing.T) {
+	llru := buildNewEmpty(t, 6)
+	if err := llru.SetSizeBounds(1, 6); err != nil {
+		t.Errorf("expected no error but got %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	if _, err := llru.Reserve(2); err != nil {
+		t.Errorf("expected no error but got %v", err)
+	}
+
+	_, err := llru.ApplyMemoryPressure(1)
+	if size, lockedLen, _, _ := llru.Room(); err != nil || size != 3 || lockedLen != 1 {
+		t.Errorf("expected size 3 but got %v, %v", size, err)
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	if err := llru.SetWatermarks(3, 3); err == nil {
+		t.Errorf("expected an error for invalid watermarks")
+	}
+	if err := llru.SetWatermarks(1, 4); err != nil {
+		t.Errorf("expected no error but got %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if !ok || evicted != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", ok, evicted)
+	}
+
+	ok, evicted = llru.AddOrUpdateUnlocked("new key4", "4")
+	if !ok || evicted == nil || evicted.Key != "new key1" {
+		t.Errorf("expected `true, new key1` but got %v, %v", ok, evicted)
+	}
+	if keys := llru.Keys(); len(keys) != 1 || keys[0] != "new key4" {
+		t.Errorf("expected `[new key4]` but got %v", keys)
+	}
+
+	//locked entries count towards the watermarks, but are never evicted
+	_, _ = llru.AddOrUpdateLocked("new key5", "5")
+	_, _ = llru.AddOrUpdateLocked("new key6", "6")
+	ok, evictedAll := llru.AddOrUpdateLockedAll("new key7", "7")
+	if !ok || len(evictedAll) != 1 || evictedAll[0].Key != "new key4" {
+		t.Errorf("expected `true, [new key4]` but got %v, %v", ok, evictedAll)
+	}
+	if l := llru.Len(); l != 3 {
+		t.Errorf("expected `3` but got %v", l)
+	}
+}
+
+func TestUnbounded(t *testing.T) {
+	llru := buildNewEmpty(t, 0)
+
+	for i := 0; i < 100; i++ {
+		ok, evicted := llru.AddOrUpdateLocked("locked key" + strconv.Itoa(i), "x")
+		if !ok || evicted != nil {
+			t.Fatalf("expected `true, nil` but got %v, %v", ok, evicted)
+		}
+		ok, evicted = llru.AddOrUpdateUnlocked("unlocked key" + strconv.Itoa(i), "x")
+		if !ok || evicted != nil {
+			t.Fatalf("expected `true, nil` but got %v, %v", ok, evicted)
+		}
+	}
+	llru.UnlockAll()
+	if l := llru.Len(); l != 200 {
+		t.Errorf("expected `200` but got %v", l)
+	}
+
+	evicted, err := llru.Resize(150)
+	if err != nil || len(evicted) != 50 {
+		t.Errorf("expected 50 evicted entries but got %v, %v", len(evicted), err)
+	}
+	_, _ = llru.Resize(0)
+	_, _ = llru.AddOrUpdateUnlocked("new key", "x")
+	if l := llru.Len(); l != 151 {
+		t.Errorf("expected `151` but got %v", l)
+	}
+
+	_, err = NewUnsafe[string, string](-1)
+	if err == nil {
+		t.Errorf("expected an error for a negative size")
+	}
+}
+
+func TestCapacityPolicy(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	decision := RejectDecision()
+	var calls [][3]int
+	llru.SetCapacityPolicy(func(lockedLen, unlockedLen, requested int) Decision {
+		calls = append(calls, [3]int{lockedLen, unlockedLen, requested})
+		return decision
+	})
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	if len(calls) != 0 {
+		t.Errorf("expected no call while there is room but got %v", calls)
+	}
+
+	ok, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if ok || evicted != nil {
+		t.Errorf("expected `false, nil` but got %v, %v", ok, evicted)
+	}
+	if len(calls) != 1 || calls[0] != [3]int{1, 1, 3} {
+		t.Errorf("expected `[[1 1 3]]` but got %v", calls)
+	}
+
+	//updating an existing entry never goes over the size
+	ok, _ = llru.AddOrUpdateUnlocked("new key2", "x")
+	if !ok || len(calls) != 1 {
+		t.Errorf("expected `true` and no call but got %v, %v", ok, calls)
+	}
+
+	decision = GrowDecision(1)
+	ok, evicted = llru.AddOrUpdateUnlocked("new key3", "3")
+	if !ok || evicted != nil || llru.Len() != 3 {
+		t.Errorf("expected `true, nil` and 3 entries but got %v, %v, %v", ok, evicted, llru.Len())
+	}
+
+	decision = EvictDecision()
+	ok, evicted = llru.AddOrUpdateLocked("new key4", "4")
+	if !ok || evicted == nil || evicted.Key != "new key2" {
+		t.Errorf("expected `true, new key2` but got %v, %v", ok, evicted)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_, _ = llru.AddOrUpdateUnlocked("new key4", "4")
+
+	evicted, err := llru.Reserve(4)
+	if !errors.Is(err, ErrNotEnoughRoom) || evicted != nil {
+		t.Errorf("expected `nil, ErrNotEnoughRoom` but got %v, %v", evicted, err)
+	}
+
+	evicted, err = llru.Reserve(2)
+	if err != nil || len(evicted) != 2 || evicted[0].Key != "new key2" || evicted[1].Key != "new key3" {
+		t.Errorf("expected `new key2, new key3` evicted but got %v, %v", evicted, err)
+	}
+
+	//unlocked entries cannot take the reserved room
+	ok, evictedEntry := llru.AddOrUpdateUnlocked("new key5", "5")
+	if !ok || evictedEntry == nil || evictedEntry.Key != "new key4" {
+		t.Errorf("expected `true, new key4` but got %v, %v", ok, evictedEntry)
+	}
+
+	ok, evictedEntry = llru.AddOrUpdateLocked("new key6", "6")
+	if !ok || evictedEntry != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", ok, evictedEntry)
+	}
+
+	llru.Release()
+	ok, evictedEntry = llru.AddOrUpdateUnlocked("new key7", "7")
+	if !ok || evictedEntry != nil || llru.Len() != 4 {
+		t.Errorf("expected `true, nil` and 4 entries but got %v, %v, %v", ok, evictedEntry, llru.Len())
+	}
+}
+
+func TestRoom(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	size, lockedLen, unlockedLen, evictableRoom := llru.Room()
+	if size != 4 || lockedLen != 1 || unlockedLen != 1 || evictableRoom != 3 {
+		t.Errorf("expected `4, 1, 1, 3` but got %v, %v, %v, %v", size, lockedLen, unlockedLen, evictableRoom)
+	}
+}
+
+func TestGet2(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+
+	value, ok := llru.Get2("new key1")
+	if !ok || value != "" {
+		t.Errorf("expected `\"\", true` but got %v, %v", value, ok)
+	}
+	value, ok = llru.Get2("new key2")
+	if !ok || value != "2" {
+		t.Errorf("expected `2, true` but got %v, %v", value, ok)
+	}
+	value, ok = llru.Get2("new key3")
+	if ok || value != "" {
+		t.Errorf("expected `\"\", false` but got %v, %v", value, ok)
+	}
+}
+
+func TestGetOrAdd(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	actual, loaded, evicted := llru.GetOrAdd("new key1", "x")
+	if actual != "1" || !loaded || evicted != nil {
+		t.Errorf("expected `1, true, nil` but got %v, %v, %v", actual, loaded, evicted)
+	}
+
+	//new key1 was promoted, so new key2 is evicted
+	actual, loaded, evicted = llru.GetOrAdd("new key3", "3")
+	if actual != "3" || loaded || evicted == nil || evicted.Key != "new key2" {
+		t.Errorf("expected `3, false, new key2` but got %v, %v, %v", actual, loaded, evicted)
+	}
+	if value := llru.Get("new key3"); value == nil || *value != "3" {
+		t.Errorf("expected `3` but got %v", value)
+	}
+}
+
+func TestGetOrCompute(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+
+	value, err := llru.GetOrCompute("new key1", func() (string, error) {
+		t.Errorf("compute should not be called for an existing key")
+		return "x", nil
+	})
+	if value != "1" || err != nil {
+		t.Errorf("expected `1, nil` but got %v, %v", value, err)
+	}
+
+	value, err = llru.GetOrCompute("new key2", func() (string, error) { return "2", nil })
+	if value != "2" || err != nil || !llru.Contains("new key2") {
+		t.Errorf("expected `2, nil` and the value added but got %v, %v", value, err)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	value, ok := llru.Peek("new key1")
+	if value != "1" || !ok {
+		t.Errorf("expected `1, true` but got %v, %v", value, ok)
+	}
+
+	//peeking does not promote new key1, so it is evicted first
+	_, evicted := llru.AddOrUpdateUnlocked("new key3", "3")
+	if evicted == nil || evicted.Key != "new key1" {
+		t.Errorf("expected `new key1` evicted but got %v", evicted)
+	}
+
+	_ = llru.Lock("new key2")
+	value, ok = llru.Peek("new key2")
+	if value != "2" || !ok {
+		t.Errorf("expected `2, true` but got %v, %v", value, ok)
+	}
+	value, ok = llru.Peek("new key1")
+	if value != "" || ok {
+		t.Errorf("expected `\"\", false` but got %v, %v", value, ok)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	var reasons []EvictionReason
+	llru.SetOnEvictedWithReason(func(key string, value string, reason EvictionReason) {
+		reasons = append(reasons, reason)
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+
+	present, wasLocked := llru.Remove("new key1")
+	if !present || wasLocked {
+		t.Errorf("expected `true, false` but got %v, %v", present, wasLocked)
+	}
+	present, wasLocked = llru.Remove("new key2")
+	if !present || !wasLocked {
+		t.Errorf("expected `true, true` but got %v, %v", present, wasLocked)
+	}
+	present, wasLocked = llru.Remove("new key3")
+	if present || wasLocked {
+		t.Errorf("expected `false, false` but got %v, %v", present, wasLocked)
+	}
+
+	if llru.Len() != 0 || !slices.Equal(reasons, []EvictionReason{EvictionReasonRemoved, EvictionReasonRemoved}) {
+		t.Errorf("expected an empty cache and `[removed removed]` but got %v, %v", llru.Len(), reasons)
+	}
+}
+
+func TestAddGetRemoveMany(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	ok, evicted := llru.AddMany([]Entry[string, string]{
+		{Key: "new key1", Value: "1"},
+		{Key: "new key2", Value: "2"},
+		{Key: "new key3", Value: "3"},
+	})
+	if !slices.Equal(ok, []bool{true, true, true}) || evicted[0] != nil || evicted[1] != nil || evicted[2] == nil || evicted[2].Key != "new key1" {
+		t.Errorf("expected every entry added and `new key1` evicted by the last one but got %v, %v", ok, evicted)
+	}
+
+	values, ok := llru.GetMany([]string{"new key1", "new key2", "new key3"})
+	if !slices.Equal(values, []string{"", "2", "3"}) || !slices.Equal(ok, []bool{false, true, true}) {
+		t.Errorf("expected `[ 2 3], [false true true]` but got %v, %v", values, ok)
+	}
+
+	present := llru.RemoveMany([]string{"new key2", "new key4"})
+	if !slices.Equal(present, []bool{true, false}) || llru.Len() != 1 {
+		t.Errorf("expected `[true false]` and one entry left but got %v, %v", present, llru.Len())
+	}
+}
+
+func TestContainsOrAddAndPeekOrAdd(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	contained, evicted := llru.ContainsOrAdd("new key1", "1", false)
+	if contained || evicted != nil {
+		t.Errorf("expected `false, nil` but got %v, %v", contained, evicted)
+	}
+	contained, evicted = llru.ContainsOrAdd("new key1", "x", true)
+	if !contained || evicted != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", contained, evicted)
+	}
+	if locked, _ := llru.IsLocked("new key1"); locked {
+		t.Errorf("expected an existing entry to keep its lock state")
+	}
+
+	previous, ok, evicted := llru.PeekOrAdd("new key2", "2", true)
+	if previous != "" || ok || evicted != nil {
+		t.Errorf("expected `\"\", false, nil` but got %v, %v, %v", previous, ok, evicted)
+	}
+	if locked, _ := llru.IsLocked("new key2"); !locked {
+		t.Errorf("expected the added entry to be locked")
+	}
+
+	previous, ok, evicted = llru.PeekOrAdd("new key1", "x", false)
+	if previous != "1" || !ok || evicted != nil {
+		t.Errorf("expected `1, true, nil` but got %v, %v, %v", previous, ok, evicted)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+
+	appendX := func(old string, exists bool) (string, bool) {
+		return old + "x", true
+	}
+
+	ok, evicted := llru.Update("new key1", appendX)
+	if !ok || evicted != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", ok, evicted)
+	}
+	//the entry stays locked, with both of its locks
+	_ = llru.Unlock("new key1")
+	if value, _ := llru.Peek("new key1"); value != "1x" {
+		t.Errorf("expected `1x` but got %v", value)
+	}
+	if locked, _ := llru.IsLocked("new key1"); !locked {
+		t.Errorf("expected `new key1` to still be locked")
+	}
+
+	ok, _ = llru.Update("new key2", appendX)
+	if !ok || !slices.Equal(llru.Keys(), []string{"new key3", "new key2", "new key1"}) {
+		t.Errorf("expected `new key2` updated and promoted but got %v, %v", ok, llru.Keys())
+	}
+
+	ok, _ = llru.Update("new key3", func(old string, exists bool) (string, bool) {
+		return "", false
+	})
+	if value, _ := llru.Peek("new key3"); ok || value != "3" {
+		t.Errorf("expected `false` and `3` unchanged but got %v, %v", ok, value)
+	}
+
+	var existed bool
+	_, _ = llru.Update("new key4", func(old string, exists bool) (string, bool) {
+		existed = exists
+		return "4", true
+	})
+	if value, _ := llru.Peek("new key4"); existed || value != "4" {
+		t.Errorf("expected a missing key to be added but got %v, %v", existed, value)
+	}
+}
+
+func TestPop(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+
+	value, ok := llru.Pop("new key1", false)
+	if value != "1" || !ok || llru.Contains("new key1") {
+		t.Errorf("expected `1, true` and the entry removed but got %v, %v", value, ok)
+	}
+
+	value, ok = llru.Pop("new key2", false)
+	if value != "" || ok || !llru.Contains("new key2") {
+		t.Errorf("expected `\"\", false` and the locked entry kept but got %v, %v", value, ok)
+	}
+	value, ok = llru.Pop("new key2", true)
+	if value != "2" || !ok || llru.Contains("new key2") {
+		t.Errorf("expected `2, true` and the entry removed but got %v, %v", value, ok)
+	}
+
+	value, ok = llru.Pop("new key3", true)
+	if value != "" || ok {
+		t.Errorf("expected `\"\", false` but got %v, %v", value, ok)
+	}
+}
+
+func TestRemoveOldestN(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_, _ = llru.AddOrUpdateUnlocked("new key4", "4")
+
+	removed := llru.RemoveOldestN(2)
+	if len(removed) != 2 || removed[0].Key != "new key1" || removed[1].Key != "new key3" {
+		t.Errorf("expected `new key1, new key3` but got %v", removed)
+	}
+
+	//locked entries are never removed
+	removed = llru.RemoveOldestN(5)
+	if len(removed) != 1 || removed[0].Key != "new key4" || llru.Len() != 1 {
+		t.Errorf("expected `new key4` and the locked entry kept but got %v, %v", removed, llru.Len())
+	}
+}
+
+func TestTouch(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateLocked("new key3", "3")
+
+	if ok := llru.Touch("new key1"); !ok {
+		t.Errorf("expected `true` but got %v", ok)
+	}
+	if keys := llru.Keys(); !slices.Equal(keys, []string{"new key2", "new key1", "new key3"}) {
+		t.Errorf("expected `new key1` to be the most recent but got %v", keys)
+	}
+
+	if ok := llru.Touch("new key3"); ok {
+		t.Errorf("expected `false` for a locked entry but got %v", ok)
+	}
+	if ok := llru.Touch("new key4"); ok {
+		t.Errorf("expected `false` for a missing entry but got %v", ok)
+	}
+}
+
+func TestGetOldestAndNewest(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	if oldest, newest := llru.GetOldest(), llru.GetNewest(); oldest != nil || newest != nil {
+		t.Errorf("expected `nil, nil` but got %v, %v", oldest, newest)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_, _ = llru.AddOrUpdateLocked("new key4", "4")
+
+	oldest, newest := llru.GetOldest(), llru.GetNewest()
+	if oldest == nil || oldest.Key != "new key1" || newest == nil || newest.Key != "new key4" {
+		t.Errorf("expected `new key1, new key4` but got %v, %v", oldest, newest)
+	}
+	//neither changes the recency of the entries
+	if keys := llru.Keys(); !slices.Equal(keys, []string{"new key1", "new key2", "new key3", "new key4"}) {
+		t.Errorf("expected the order to be unchanged but got %v", keys)
+	}
+
+	//without locked entries, the newest is the most recently used unlocked entry
+	_ = llru.Unlock("new key4")
+	_ = llru.Get("new key2")
+	if newest := llru.GetNewest(); newest == nil || newest.Key != "new key2" {
+		t.Errorf("expected `new key2` but got %v", newest)
+	}
+}
+
+func TestClone(t *testing.T) {
+	llru := buildNewEmpty(t, 4)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_ = llru.Get("new key1")
+
+	clone := llru.Clone(func(value string) string {
+		return value + "'"
+	})
+	if keys := clone.Keys(); !slices.Equal(keys, llru.Keys()) {
+		t.Errorf("expected %v but got %v", llru.Keys(), keys)
+	}
+	if value, _ := clone.Peek("new key3"); value != "3'" {
+		t.Errorf("expected `3'` but got %v", value)
+	}
+	//the lock count is copied
+	_ = clone.Unlock("new key2")
+	if locked, _ := clone.IsLocked("new key2"); !locked {
+		t.Errorf("expected `new key2` to still be locked")
+	}
+
+	//changes to the copy do not affect the original
+	_ = clone.Unlock("new key2")
+	_, _ = clone.AddOrUpdateUnlocked("new key4", "4")
+	_, _ = clone.AddOrUpdateUnlocked("new key5", "5")
+	if keys := llru.Keys(); !slices.Equal(keys, []string{"new key3", "new key1", "new key2"}) {
+		t.Errorf("expected the original to be unchanged but got %v", keys)
+	}
+	if keys := clone.Keys(); !slices.Equal(keys, []string{"new key1", "new key2", "new key4", "new key5"}) {
+		t.Errorf("expected `new key3` to be evicted from the copy but got %v", keys)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru := buildNewEmpty(t, 4)
+	llru.SetClock(clock)
+	other := buildNewEmpty(t, 4)
+	other.SetClock(clock)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	clock.now = clock.now.Add(time.Minute)
+	_, _ = other.AddOrUpdateUnlocked("new key1", "1'")
+	_, _ = other.AddOrUpdateUnlocked("new key2", "2'")
+	_, _ = other.AddOrUpdateLocked("new key3", "3'")
+
+	merged, failed := llru.Merge(other, MergeKeepExisting)
+	if merged != 1 || failed != nil {
+		t.Errorf("expected `1, nil` but got %v, %v", merged, failed)
+	}
+	if value, _ := llru.Peek("new key1"); value != "1" {
+		t.Errorf("expected `1` but got %v", value)
+	}
+	//locked entries stay locked
+	if locked, _ := llru.IsLocked("new key3"); !locked {
+		t.Errorf("expected `new key3` to be locked")
+	}
+
+	merged, _ = llru.Merge(other, MergeOverwrite)
+	if value, _ := llru.Peek("new key2"); merged != 3 || value != "2'" {
+		t.Errorf("expected `3, 2'` but got %v, %v", merged, value)
+	}
+	if locked, _ := llru.IsLocked("new key2"); !locked {
+		t.Errorf("expected `new key2` to stay locked")
+	}
+	if other.Len() != 3 {
+		t.Errorf("expected the other cache to be unchanged but got %v", other.Keys())
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	merged, _ = llru.Merge(other, MergeNewestWins)
+	if value, _ := llru.Peek("new key1"); merged != 0 || value != "1" {
+		t.Errorf("expected `0, 1` but got %v, %v", merged, value)
+	}
+}
+
+func TestMoveTo(t *testing.T) {
+	var evicted []string
+	llru, _ := NewUnsafeWithEvict[string, string](2, func(key string, value string) {
+		evicted = append(evicted, key)
+	})
+	other := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	if ok := llru.MoveTo(other, "new key1"); !ok {
+		t.Errorf("expected `true` but got %v", ok)
+	}
+	if llru.Contains("new key1") || len(evicted) != 0 {
+		t.Errorf("expected `new key1` to be moved without eviction but got %v, %v", llru.Keys(), evicted)
+	}
+	//the lock count moves with the entry
+	_ = other.Unlock("new key1")
+	if locked, _ := other.IsLocked("new key1"); !locked {
+		t.Errorf("expected `new key1` to still be locked")
+	}
+
+	_, _ = other.AddOrUpdateUnlocked("new key2", "2'")
+	if ok := llru.MoveTo(other, "new key2"); ok || !llru.Contains("new key2") {
+		t.Errorf("expected `false` for a key in both caches but got %v", ok)
+	}
+	if ok := llru.MoveTo(other, "new key3"); ok {
+		t.Errorf("expected `false` for a missing key but got %v", ok)
+	}
+}
+
+func TestValuesLockedAndUnlocked(t *testing.T) {
+	llru := buildPartiallyLocked(t, 2, 2)
+
+	if values := llru.ValuesLocked(); !slices.Equal(values, []string{"x0", "x1"}) {
+		t.Errorf("expected `[x0 x1]` but got %v", values)
+	}
+	if values := llru.ValuesUnlocked(); !slices.Equal(values, []string{"x2", "x3"}) {
+		t.Errorf("expected `[x2 x3]` but got %v", values)
+	}
+}
+
+func TestKeysByRecency(t *testing.T) {
+	llru, err := NewUnsafeWithPolicy[string, string](5, SLRUPolicy(), nil)
+	if err != nil {
+		t.Fatalf("could not create llru: %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_, _ = llru.AddOrUpdateLocked("new key4", "4")
+	//moves `new key1` to the protected segment
+	_ = llru.Get("new key1")
+
+	if keys := llru.KeysByRecency(2, true); !slices.Equal(keys, []string{"new key1", "new key3"}) {
+		t.Errorf("expected `[new key1 new key3]` but got %v", keys)
+	}
+	if keys := llru.KeysByRecency(2, false); !slices.Equal(keys, []string{"new key2", "new key3"}) {
+		t.Errorf("expected `[new key2 new key3]` but got %v", keys)
+	}
+	if keys := llru.KeysByRecency(10, false); !slices.Equal(keys, []string{"new key2", "new key3", "new key1"}) {
+		t.Errorf("expected every unlocked key but got %v", keys)
+	}
+	if keys := llru.KeysByRecency(0, true); len(keys) != 0 {
+		t.Errorf("expected no keys but got %v", keys)
+	}
+}
+
+func TestOldestUnlocked(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	if entry := llru.OldestUnlocked(); entry != nil {
+		t.Errorf("expected `nil` but got %v", entry)
+	}
+
+	_, _ = llru.AddOrUpdateUnlockedWithPriority("new key1", "1", 1)
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	//the entry with the lowest priority is evicted first, even though it is newer
+	entry := llru.OldestUnlocked()
+	if entry == nil || entry.Key != "new key2" {
+		t.Fatalf("expected `new key2` but got %v", entry)
+	}
+	_ = llru.Lock(entry.Key)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_, evicted := llru.AddOrUpdateUnlocked("new key4", "4")
+	if evicted == nil || evicted.Key != "new key3" || !llru.Contains("new key2") {
+		t.Errorf("expected `new key3` evicted and `new key2` kept but got %v", evicted)
+	}
+}
+
+func TestAddOrUpdate(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+
+	ok, evicted := llru.AddOrUpdate("new key1", "1'")
+	if !ok || evicted != nil {
+		t.Errorf("expected `true, nil` but got %v, %v", ok, evicted)
+	}
+	if locked, _ := llru.IsLocked("new key1"); !locked {
+		t.Errorf("expected `new key1` to stay locked")
+	}
+
+	_, _ = llru.AddOrUpdate("new key2", "2'")
+	_, _ = llru.AddOrUpdate("new key3", "3")
+	for _, key := range []string{"new key2", "new key3"} {
+		if locked, _ := llru.IsLocked(key); locked {
+			t.Errorf("expected `%v` to be unlocked", key)
+		}
+	}
+	if values := llru.Values(); !slices.Equal(values, []string{"2'", "3", "1'"}) {
+		t.Errorf("expected `[2' 3 1']` but got %v", values)
+	}
+}
+
+func TestGetOrDefault(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+
+	if value := llru.GetOrDefault("new key1", "default"); value != "1" {
+		t.Errorf("expected `1` but got %v", value)
+	}
+	if value := llru.GetOrDefault("new key2", "default"); value != "default" || llru.Contains("new key2") {
+		t.Errorf("expected `default` and nothing added but got %v, %v", value, llru.Keys())
+	}
+}
+
+func TestRange(t *testing.T) {
+	llru := buildPartiallyLocked(t, 1, 2)
+
+	var keys []string
+	var locked []bool
+	llru.Range(func(key string, value string, isLocked bool) bool {
+		keys = append(keys, key)
+		locked = append(locked, isLocked)
+		return true
+	})
+	if !slices.Equal(keys, llru.Keys()) || !slices.Equal(locked, []bool{false, false, true}) {
+		t.Errorf("expected %v, `[false false true]` but got %v, %v", llru.Keys(), keys, locked)
+	}
+
+	calls := 0
+	llru.Range(func(key string, value string, isLocked bool) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("expected iteration to stop after 1 call but got %v", calls)
+	}
+}
+
+func TestRangeReverse(t *testing.T) {
+	llru := buildPartiallyLocked(t, 2, 2)
+
+	var keys []string
+	llru.RangeReverse(func(key string, value string, locked bool) bool {
+		keys = append(keys, key)
+		return true
+	})
+	expected := llru.Keys()
+	slices.Reverse(expected)
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected %v but got %v", expected, keys)
+	}
+
+	//the most recent entries can be listed without going through every entry
+	var newest []string
+	llru.RangeReverse(func(key string, value string, locked bool) bool {
+		if !locked {
+			newest = append(newest, value)
+		}
+		return len(newest) < 1
+	})
+	if !slices.Equal(newest, []string{"x3"}) {
+		t.Errorf("expected `[x3]` but got %v", newest)
+	}
+}
+
+func TestFindAndRangeWhere(t *testing.T) {
+	llru := buildPartiallyLocked(t, 2, 2)
+	odd := func(key string, value string) bool {
+		return value == "x1" || value == "x3"
+	}
+
+	found := llru.Find(odd)
+	if len(found) != 2 || found[0].Value != "x3" || found[1].Value != "x1" {
+		t.Errorf("expected `x3, x1` but got %v", found)
+	}
+
+	var locked []bool
+	llru.RangeWhere(odd, func(key string, value string, isLocked bool) bool {
+		locked = append(locked, isLocked)
+		return false
+	})
+	if !slices.Equal(locked, []bool{false}) {
+		t.Errorf("expected iteration to stop after the first match but got %v", locked)
+	}
+}
+
+func TestList(t *testing.T) {
+	llru := buildPartiallyLocked(t, 2, 3)
+
+	page, total := llru.List(2, 2)
+	if total != 5 || len(page) != 2 || page[0].Value != "x4" || page[0].Locked || page[1].Value != "x0" || !page[1].Locked {
+		t.Errorf("expected `5, [x4 x0]` but got %v, %v", total, page)
+	}
+
+	page, _ = llru.List(4, 10)
+	if len(page) != 1 || page[0].Value != "x1" {
+		t.Errorf("expected `[x1]` but got %v", page)
+	}
+
+	page, total = llru.List(5, 10)
+	if total != 5 || len(page) != 0 {
+		t.Errorf("expected `5` and no entries but got %v, %v", total, page)
+	}
+}
+
+func TestOnAdd(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	var added []string
+	llru.SetOnAdd(func(key string, value string, locked bool) {
+		added = append(added, key+":"+strconv.FormatBool(locked))
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	//updates are not additions, even when they change the lock state
+	_, _ = llru.AddOrUpdateLocked("new key1", "1'")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2'")
+	_, _ = llru.AddOrUpdate("new key3", "3")
+
+	if !slices.Equal(added, []string{"new key1:false", "new key2:true", "new key3:false"}) {
+		t.Errorf("expected `[new key1:false new key2:true new key3:false]` but got %v", added)
+	}
+}
+
+func TestOnHitAndOnMiss(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	var hits, misses []string
+	llru.SetOnHit(func(key string) {
+		hits = append(hits, key)
+	})
+	llru.SetOnMiss(func(key string) {
+		misses = append(misses, key)
+	})
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_ = llru.Get("new key1")
+	_ = llru.Get("new key2")
+	_, _ = llru.GetOrCompute("new key2", func() (string, error) {
+		return "2", nil
+	})
+	_, _ = llru.GetOrCompute("new key2", func() (string, error) {
+		return "2", nil
+	})
+	//Peek and Contains are not gets
+	_, _ = llru.Peek("new key3")
+	_ = llru.Contains("new key3")
+
+	if !slices.Equal(hits, []string{"new key1", "new key2"}) || !slices.Equal(misses, []string{"new key2", "new key2"}) {
+		t.Errorf("expected `[new key1 new key2]` hits and `[new key2 new key2]` misses but got %v, %v", hits, misses)
+	}
+}
+
+func TestOnUpdate(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+
+	var updates []string
+	llru.SetOnUpdate(func(key string, oldValue string, newValue string, wasLocked bool) {
+		updates = append(updates, oldValue+">"+newValue+":"+strconv.FormatBool(wasLocked))
+	})
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key1", "1'")
+	_, _ = llru.AddOrUpdate("new key1", "1''")
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1'''")
+
+	expected := []string{"1>1':false", "1'>1'':true", "1''>1''':true"}
+	if !slices.Equal(updates, expected) {
+		t.Errorf("expected %v but got %v", expected, updates)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	llru := buildNewEmpty(t, 1)
+	llru.SetDefaultTTL(time.Minute)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru.SetClock(clock)
+
+	var all []EventKind
+	all1 := llru.Subscribe(func(event Event[string, string]) {
+		all = append(all, event.Kind)
+	})
+	var removals []Event[string, string]
+	_ = llru.Subscribe(func(event Event[string, string]) {
+		removals = append(removals, event)
+	}, EventEvict, EventRemove)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_ = llru.Lock("new key1")
+	_ = llru.Unlock("new key1")
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1'")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_ = llru.ForceRemove("new key2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	clock.now = clock.now.Add(time.Hour)
+	_ = llru.Len()
+
+	expected := []EventKind{EventAdd, EventLock, EventUnlock, EventUpdate, EventEvict, EventAdd, EventRemove, EventAdd, EventExpire}
+	if !slices.Equal(all, expected) {
+		t.Errorf("expected %v but got %v", expected, all)
+	}
+	if len(removals) != 2 || removals[0].Key != "new key1" || removals[1].Reason != EvictionReasonForced {
+		t.Errorf("expected the eviction of `new key1` and the forced removal of `new key2` but got %v", removals)
+	}
+
+	if ok := llru.Unsubscribe(all1); !ok {
+		t.Errorf("expected `true` but got %v", ok)
+	}
+	if ok := llru.Unsubscribe(all1); ok {
+		t.Errorf("expected `false` for a removed subscription but got %v", ok)
+	}
+	_, _ = llru.AddOrUpdateUnlocked("new key4", "4")
+	if len(all) != len(expected) {
+		t.Errorf("expected no events after Unsubscribe but got %v", all[len(expected):])
+	}
+}
+
+func TestOnEvictedWithHistory(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru.SetClock(clock)
+
+	histories := map[string]LockHistory{}
+	llru.SetOnEvictedWithHistory(func(key string, value string, reason EvictionReason, history LockHistory) {
+		histories[key] = history
+	})
+
+	_, _ = llru.AddOrUpdateLocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	clock.now = clock.now.Add(time.Minute)
+	_ = llru.Unlock("new key1")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_, _ = llru.AddOrUpdateUnlocked("new key4", "4")
+
+	if history := histories["new key2"]; history.EverLocked || !history.LastUnlocked.IsZero() {
+		t.Errorf("expected `new key2` never locked but got %v", history)
+	}
+	if history := histories["new key1"]; !history.EverLocked || !history.LastUnlocked.Equal(clock.now) {
+		t.Errorf("expected `new key1` locked and unlocked at %v but got %v", clock.now, history)
+	}
+}
+
+func TestAddWithFinalizer(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	var finalized []string
+	finalizer := func(key string, value string) {
+		finalized = append(finalized, value)
+	}
+
+	_, _ = llru.AddWithFinalizer("new key1", "1", false, finalizer)
+	_, _ = llru.AddWithFinalizer("new key2", "2", true, finalizer)
+	//replacing a value finalizes it once
+	_, _ = llru.AddOrUpdateLocked("new key2", "2'")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2''")
+	//evicting a value finalizes it
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	//removing a locked value finalizes it
+	_, _ = llru.Remove("new key2")
+
+	if !slices.Equal(finalized, []string{"2", "1"}) {
+		t.Errorf("expected `[2 1]` but got %v", finalized)
+	}
+
+	_, _ = llru.AddWithFinalizer("new key3", "3'", false, finalizer)
+	_ = llru.Purge()
+	if !slices.Equal(finalized, []string{"2", "1", "3'"}) {
+		t.Errorf("expected `[2 1 3']` but got %v", finalized)
+	}
+}
+
+func TestAddWithFinalizerEvictedOnInsert(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+
+	var finalized []string
+	finalizer := func(key string, value string) {
+		finalized = append(finalized, value)
+	}
+
+	_, _ = llru.AddOrUpdateUnlockedWithPriority("new key1", "1", 1)
+	_, _ = llru.AddOrUpdateUnlockedWithPriority("new key2", "2", 1)
+	//every other entry has a higher priority, so the new entry is evicted by its own add
+	ok, evicted := llru.AddWithFinalizer("new key3", "3", false, finalizer)
+	if !ok || evicted == nil || evicted.Key != "new key3" || llru.Contains("new key3") {
+		t.Errorf("expected `new key3` to be evicted on insert but got %v, %v", ok, evicted)
+	}
+
+	//a later value under the same key has no finalizer
+	_, _ = llru.AddOrUpdateLocked("new key3", "3'")
+	_, _ = llru.AddOrUpdateLocked("new key3", "3''")
+	_, _ = llru.Remove("new key3")
+	if len(finalized) != 0 {
+		t.Errorf("expected no finalizer to be called but got %v", finalized)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	var evictions []string
+	llru, err := NewUnsafeWithOptions[string, string](2,
+		WithPolicy[string, string](MRUPolicy()),
+		WithEvict(func(key string, value string) {
+			evictions = append(evictions, key)
+		}),
+		WithClock[string, string](clock),
+		WithTTL[string, string](time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateUnlocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	//the MRU policy evicts the most recently used entry other than the one being added
+	if !slices.Equal(evictions, []string{"new key2"}) {
+		t.Errorf("expected `[new key2]` but got %v", evictions)
+	}
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	if llru.Contains("new key1") {
+		t.Errorf("expected `new key1` to have expired")
+	}
+
+	_, err = NewUnsafeWithOptions[string, string](2, WithWatermarks[string, string](2, 1))
+	if err == nil {
+		t.Errorf("expected an error for invalid watermarks")
+	}
+}
+
+func TestSubscribeChan(t *testing.T) {
+	llru := buildNewEmpty(t, 5)
+
+	newest, newestSubscription := llru.SubscribeChan(2, EventsDropNewest, EventAdd)
+	oldest, oldestSubscription := llru.SubscribeChan(2, EventsDropOldest, EventAdd)
+	for _, key := range []string{"new key1", "new key2", "new key3"} {
+		_, _ = llru.AddOrUpdateUnlocked(key, "value")
+	}
+
+	if llru.DroppedEvents(newestSubscription) != 1 || llru.DroppedEvents(oldestSubscription) != 1 {
+		t.Errorf("expected one dropped event per subscription but got %v and %v", llru.DroppedEvents(newestSubscription), llru.DroppedEvents(oldestSubscription))
+	}
+
+	_ = llru.Unsubscribe(newestSubscription)
+	_ = llru.Unsubscribe(oldestSubscription)
+	var keys []string
+	for event := range newest {
+		keys = append(keys, event.Key)
+	}
+	if !slices.Equal(keys, []string{"new key1", "new key2"}) {
+		t.Errorf("expected `[new key1 new key2]` but got %v", keys)
+	}
+	keys = nil
+	for event := range oldest {
+		keys = append(keys, event.Key)
+	}
+	if !slices.Equal(keys, []string{"new key2", "new key3"}) {
+		t.Errorf("expected `[new key2 new key3]` but got %v", keys)
+	}
+}
+
+type testMetrics struct {
+	hits, misses int
+	evictions []EvictionReason
+	ops []string
+	length int
+}
+
+func (m *testMetrics) IncHit()                                   { m.hits++ }
+func (m *testMetrics) IncMiss()                                  { m.misses++ }
+func (m *testMetrics) IncEviction(reason EvictionReason)         { m.evictions = append(m.evictions, reason) }
+func (m *testMetrics) ObserveLatency(op string, d time.Duration) { m.ops = append(m.ops, op) }
+func (m *testMetrics) SetLen(n int)                              { m.length = n }
+
+func TestSetMetrics(t *testing.T) {
+	llru := buildNewEmpty(t, 2)
+	metrics := &testMetrics{}
+	llru.SetMetrics(metrics)
+
+	_, _ = llru.AddOrUpdateUnlocked("new key1", "1")
+	_, _ = llru.AddOrUpdateLocked("new key2", "2")
+	_, _ = llru.AddOrUpdateUnlocked("new key3", "3")
+	_ = llru.Get("new key2")
+	_ = llru.Get("new key1")
+	_, _ = llru.Remove("new key3")
+
+	if metrics.hits != 1 || metrics.misses != 1 {
+		t.Errorf("expected 1 hit and 1 miss but got %v and %v", metrics.hits, metrics.misses)
+	}
+	if !slices.Equal(metrics.evictions, []EvictionReason{EvictionReasonCapacity, EvictionReasonRemoved}) {
+		t.Errorf("expected `[capacity removed]` but got %v", metrics.evictions)
+	}
+	expectedOps := []string{MetricsOpAdd, MetricsOpAdd, MetricsOpAdd, MetricsOpGet, MetricsOpGet, MetricsOpRemove}
+	if !slices.Equal(metrics.ops, expectedOps) {
+		t.Errorf("expected %v but got %v", expectedOps, metrics.ops)
+	}
+	if metrics.length != 1 {
+		t.Errorf("expected a length of 1 but got %v", metrics.length)
+	}
+}
+
+// Every operation reports its latency once, including those made of other operations, and the length is reported when
+// entries expire outside of an operation which changes them
+func TestSetMetricsEveryOperation(t *testing.T) {
+	llru := buildNewEmpty(t, 3)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	llru.SetClock(clock)
+	metrics := &testMetrics{}
+	llru.SetMetrics(metrics)
+
+	_, _ = llru.AddOrUpdateUnlockedWithTTL("new key1", "1", time.Minute)
+	_, _, _ = llru.GetOrAdd("new key2", "2")
+	_, _ = llru.AddOrUpdate("new key2", "3")
+	_ = llru.Lock("new key2")
+	_ = llru.Unlock("new key2")
+	_, _ = llru.Pop("new key2", false)
+
+	expectedOps := []string{MetricsOpAdd, MetricsOpAdd, MetricsOpAdd, MetricsOpLock, MetricsOpUnlock, MetricsOpRemove}
+	if !slices.Equal(metrics.ops, expectedOps) {
+		t.Errorf("expected %v but got %v", expectedOps, metrics.ops)
+	}
+	if metrics.misses != 1 || metrics.length != 1 {
+		t.Errorf("expected 1 miss and a length of 1 but got %v and %v", metrics.misses, metrics.length)
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	if length := llru.Len(); length != 0 || metrics.length != 0 {
+		t.Errorf("expected a length of 0 to be reported but got %v and %v", length, metrics.length)
+	}
+}
@@ -0,0 +1,85 @@
+package lockable_lru
+
+/*
+ * 2Q policy for the unlocked entries
+ *
+ * Adapted from hashicorp/golang-lru's 2q.go. New entries are added to the recent segment, which acts as a probation
+ * queue, and move to the frequent segment, the main LRU, when they are used again. The keys of entries evicted from the
+ * recent segment are remembered in a ghost list, and an entry whose key is found there is added straight to the
+ * frequent segment.
+ *
+ */
+import (
+	gmap "github.com/wk8/go-ordered-map/v2"
+)
+
+const (
+	twoQueueRecent   = 0
+	twoQueueFrequent = 1
+)
+
+type twoQueuePolicy[K comparable, V any] struct {
+	recentRatio          float64                       //ratio of the size dedicated to the recent segment
+	ghostRatio           float64                       //ratio of the size of the ghost list
+	recentEvict          *gmap.OrderedMap[K, struct{}] //keys recently evicted from the recent segment
+	addedFromRecentEvict bool                          //whether the key being added was found in recentEvict
+}
+
+func newTwoQueuePolicy[K comparable, V any](recentRatio, ghostRatio float64) *twoQueuePolicy[K, V] {
+	return &twoQueuePolicy[K, V]{
+		recentRatio: recentRatio,
+		ghostRatio:  ghostRatio,
+		recentEvict: gmap.New[K, struct{}](),
+	}
+}
+
+func (q *twoQueuePolicy[K, V]) segmentCount() int {
+	return 2
+}
+
+func (q *twoQueuePolicy[K, V]) onAdd(c *unlockedLRU[K, V], key K) int {
+	_, q.addedFromRecentEvict = q.recentEvict.Delete(key)
+	if q.addedFromRecentEvict {
+		return twoQueueFrequent
+	}
+	return twoQueueRecent
+}
+
+func (q *twoQueuePolicy[K, V]) onHit(c *unlockedLRU[K, V], key K, segment int) int {
+	return twoQueueFrequent
+}
+
+func (q *twoQueuePolicy[K, V]) rebalance(c *unlockedLRU[K, V]) {
+	q.trimGhosts(c)
+}
+
+func (q *twoQueuePolicy[K, V]) victimSegment(c *unlockedLRU[K, V]) int {
+	recentLen := c.segments[twoQueueRecent].Len()
+	recentSize := c.share(q.recentRatio)
+	if recentLen > 0 && (recentLen > recentSize || (recentLen == recentSize && !q.addedFromRecentEvict)) {
+		return twoQueueRecent
+	}
+	return twoQueueFrequent
+}
+
+func (q *twoQueuePolicy[K, V]) onEvicted(c *unlockedLRU[K, V], key K, segment int) {
+	q.addedFromRecentEvict = false
+	if segment != twoQueueRecent {
+		return
+	}
+	q.recentEvict.Set(key, struct{}{})
+	q.trimGhosts(c)
+}
+
+//forgets the oldest evicted keys until the ghost list fits its share of the size
+func (q *twoQueuePolicy[K, V]) trimGhosts(c *unlockedLRU[K, V]) {
+	for q.recentEvict.Len() > c.share(q.ghostRatio) {
+		q.recentEvict.Delete(q.recentEvict.Oldest().Key)
+	}
+}
+
+func (q *twoQueuePolicy[K, V]) clone() segmentPolicy[K, V] {
+	clone := *q
+	clone.recentEvict = cloneGhosts(q.recentEvict)
+	return &clone
+}
@@ -0,0 +1,607 @@
+package lockable_lru
+
+/*
+ * A thread-unsafe, fixed size LRU used to store the unlocked entries of a ThreadunsafeLLRU
+ *
+ * Mirrors the API of hashicorp/golang-lru's simplelru, with a few differences: removing an entry with Remove does not
+ * call the eviction callback, since it is used to move entries to the locked store, every entry carries a recency
+ * stamp so that an entry can be put back at a previous position with AddAt, and entries can be given a priority.
+ * Entries with the lowest priority are evicted first, oldest first within a priority. The keys of each priority are
+ * also kept in their own tier, ordered like the segments, so that the next victim is found without looking at the
+ * entries of other priorities.
+ *
+ * Entries are kept in one or more segments, each ordered from oldest to newest. The segmentPolicy decides which
+ * segment an entry goes to when it is added or used, and which segment the next victim is taken from (see policy.go).
+ * The default LRU policy uses a single segment.
+ *
+ */
+import (
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	gmap "github.com/wk8/go-ordered-map/v2"
+)
+
+type unlockedLRU[K comparable, V any] struct {
+	segments  []*gmap.OrderedMap[K, V]  //entries of each segment, from oldest to newest
+	segmentOf map[K]int                 //segment of each entry
+	recency   map[K]uint64              //recency stamp of each entry, higher is more recent
+	clock     uint64                    //last recency stamp handed out
+	priority  map[K]int                 //priority of each entry which has a priority other than 0
+	tiers     map[int][]*gmap.OrderedMap[K, struct{}] //keys of each priority, in each segment, from oldest to newest
+	usedAt    map[K]time.Time           //time each entry was last added, updated or read
+	addedAt   map[K]time.Time           //time each entry was added, kept when it is updated
+	minResidency time.Duration          //time during which a newly added entry is only evicted if there are no other entries
+	now       func() time.Time          //returns the current time
+	score     func(key K, value V, age time.Duration) float64 //when set, the entry with the lowest score is evicted first
+	size      int
+	policy    segmentPolicy[K, V]
+	config    Policy                    //policy the cache was created with, to reset it on Purge
+	mostRecentFirst bool                //when true, the most recently used entries are evicted first
+	onEvict   func(key K, value V, reason EvictionReason)
+}
+
+func newUnlockedLRU[K comparable, V any](size int, policy Policy, onEvict func(key K, value V, reason EvictionReason)) (*unlockedLRU[K, V], error) {
+	if size <= 0 {
+		return nil, errors.New("must provide a positive size")
+	}
+	segmentPolicy, err := newSegmentPolicy[K, V](policy)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]*gmap.OrderedMap[K, V], segmentPolicy.segmentCount())
+	for i := range segments {
+		segments[i] = gmap.New[K, V]()
+	}
+	return &unlockedLRU[K, V]{
+		segments:  segments,
+		segmentOf: make(map[K]int),
+		recency:   make(map[K]uint64),
+		priority:  make(map[K]int),
+		tiers:     make(map[int][]*gmap.OrderedMap[K, struct{}]),
+		usedAt:    make(map[K]time.Time),
+		addedAt:   make(map[K]time.Time),
+		now:       time.Now,
+		size:      size,
+		policy:    segmentPolicy,
+		config:    policy,
+		mostRecentFirst: policy.kind == policyMRU,
+		onEvict:   onEvict,
+	}, nil
+}
+
+//returns `ratio` of the size, for the policies to size their segments and ghost lists. When the cache is unbounded, the
+//size is math.MaxInt, which would overflow, and the number of entries is used instead, so that the segments keep their
+//proportions and the ghost lists do not grow without bound
+func (c *unlockedLRU[K, V]) share(ratio float64) int {
+	if c.size == math.MaxInt {
+		return int(float64(c.Len()) * ratio)
+	}
+	return int(float64(c.size) * ratio)
+}
+
+//returns a new recency stamp, more recent than every stamp handed out before it
+func (c *unlockedLRU[K, V]) tick() uint64 {
+	c.clock++
+	return c.clock
+}
+
+//adds or updates a value, making it the most recent. An existing entry keeps its priority. Returns the evicted entries
+func (c *unlockedLRU[K, V]) Add(key K, value V) (evicted []Entry[K, V]) {
+	return c.AddWithPriority(key, value, c.priority[key])
+}
+
+//adds or updates a value with the given priority, making it the most recent. Updating an entry counts as a use of it.
+//Returns the evicted entries
+func (c *unlockedLRU[K, V]) AddWithPriority(key K, value V, priority int) (evicted []Entry[K, V]) {
+	segment, exists := c.segmentOf[key]
+	if !exists {
+		segment = -1
+	}
+	return c.AddUsed(key, value, priority, segment)
+}
+
+//adds or updates a value with the given priority, making it the most recent, as if it had been used in the given
+//segment. A negative segment adds it as a new entry. Returns the evicted entries
+func (c *unlockedLRU[K, V]) AddUsed(key K, value V, priority int, segment int) (evicted []Entry[K, V]) {
+	if segment < 0 {
+		segment = c.policy.onAdd(c, key)
+	} else {
+		segment = c.policy.onHit(c, key, segment)
+	}
+	return c.AddAt(key, value, c.tick(), priority, segment)
+}
+
+//adds or updates a value with the given priority, placing it among the other entries of the given segment according to
+//the given recency stamp. A negative segment lets the policy choose one, as for a new entry. Returns the evicted entries
+func (c *unlockedLRU[K, V]) AddAt(key K, value V, recency uint64, priority int, segment int) (evicted []Entry[K, V]) {
+	addedAt, exists := c.addedAt[key]
+	if !exists {
+		addedAt = c.now()
+	}
+	c.Remove(key)
+	if c.mostRecentFirst {
+		//make room before adding, otherwise the new entry, being the most recent, would be evicted right away
+		evicted = c.EvictN(c.Len() + 1 - c.size, EvictionReasonCapacity)
+	}
+	if segment < 0 {
+		segment = c.policy.onAdd(c, key)
+	}
+
+	c.setPriority(key, priority)
+	c.place(key, value, recency, segment)
+	c.usedAt[key] = c.now()
+	c.addedAt[key] = addedAt
+
+	c.policy.rebalance(c)
+	return append(evicted, c.evictOverflow()...)
+}
+
+//puts a key which is in no segment among the entries of the given segment, and among the keys of its priority in that
+//segment, according to its recency stamp
+func (c *unlockedLRU[K, V]) place(key K, value V, recency uint64, segment int) {
+	entries := c.segments[segment]
+	entries.Set(key, value)
+	c.segmentOf[key] = segment
+	c.recency[key] = recency
+	orderByRecency(entries, key, c.recency)
+
+	keys := c.tier(c.priority[key], segment)
+	keys.Set(key, struct{}{})
+	orderByRecency(keys, key, c.recency)
+}
+
+//moves a key which was just added to the newest end of an ordered map back to where its recency stamp belongs
+func orderByRecency[K comparable, T any](entries *gmap.OrderedMap[K, T], key K, recency map[K]uint64) {
+	pair := entries.Newest().Prev()
+	for pair != nil && recency[pair.Key] > recency[key] {
+		pair = pair.Prev()
+	}
+	if pair == nil {
+		_ = entries.MoveToFront(key)
+	} else {
+		_ = entries.MoveAfter(key, pair.Key)
+	}
+}
+
+//returns the keys of the given priority in the given segment, creating the tier of that priority if needed
+func (c *unlockedLRU[K, V]) tier(priority int, segment int) *gmap.OrderedMap[K, struct{}] {
+	tier, ok := c.tiers[priority]
+	if !ok {
+		tier = make([]*gmap.OrderedMap[K, struct{}], len(c.segments))
+		for i := range tier {
+			tier[i] = gmap.New[K, struct{}]()
+		}
+		c.tiers[priority] = tier
+	}
+	return tier[segment]
+}
+
+//removes a key from the tier of its priority, and the tier once it has no keys left
+func (c *unlockedLRU[K, V]) leaveTier(key K, segment int) {
+	priority := c.priority[key]
+	tier := c.tiers[priority]
+	tier[segment].Delete(key)
+	for _, keys := range tier {
+		if keys.Len() > 0 {
+			return
+		}
+	}
+	delete(c.tiers, priority)
+}
+
+//moves an entry to another segment, keeping its recency
+func (c *unlockedLRU[K, V]) moveToSegment(key K, segment int) {
+	current := c.segmentOf[key]
+	value, _ := c.segments[current].Delete(key)
+	c.leaveTier(key, current)
+	c.place(key, value, c.recency[key], segment)
+}
+
+func (c *unlockedLRU[K, V]) setPriority(key K, priority int) {
+	if priority == 0 {
+		delete(c.priority, key)
+	} else {
+		c.priority[key] = priority
+	}
+}
+
+//returns the priority of a key, 0 if it has none
+func (c *unlockedLRU[K, V]) Priority(key K) int {
+	return c.priority[key]
+}
+
+//returns the value of a key and makes it the most recent
+func (c *unlockedLRU[K, V]) Get(key K) (value V, ok bool) {
+	segment, ok := c.segmentOf[key]
+	if !ok {
+		return value, false
+	}
+	value, _ = c.segments[segment].Get(key)
+	c.moveToBack(key, value, c.policy.onHit(c, key, segment))
+	c.policy.rebalance(c)
+	return value, true
+}
+
+//makes an entry the most recent one of the given segment, moving it there if needed
+func (c *unlockedLRU[K, V]) moveToBack(key K, value V, segment int) {
+	tier := c.tiers[c.priority[key]]
+	if current := c.segmentOf[key]; current != segment {
+		c.segments[current].Delete(key)
+		c.segments[segment].Set(key, value)
+		c.segmentOf[key] = segment
+		tier[current].Delete(key)
+		tier[segment].Set(key, struct{}{})
+	} else {
+		_ = c.segments[segment].MoveToBack(key)
+		_ = tier[segment].MoveToBack(key)
+	}
+	c.recency[key] = c.tick()
+	c.usedAt[key] = c.now()
+}
+
+//returns the value of a key without changing its recency
+func (c *unlockedLRU[K, V]) Peek(key K) (value V, ok bool) {
+	segment, ok := c.segmentOf[key]
+	if !ok {
+		return value, false
+	}
+	return c.segments[segment].Get(key)
+}
+
+func (c *unlockedLRU[K, V]) Contains(key K) bool {
+	_, ok := c.segmentOf[key]
+	return ok
+}
+
+//returns the recency stamp of a key
+func (c *unlockedLRU[K, V]) Recency(key K) (recency uint64, ok bool) {
+	recency, ok = c.recency[key]
+	return recency, ok
+}
+
+//returns the segment of a key
+func (c *unlockedLRU[K, V]) Segment(key K) (segment int, ok bool) {
+	segment, ok = c.segmentOf[key]
+	return segment, ok
+}
+
+//removes a key without calling the eviction callback
+func (c *unlockedLRU[K, V]) Remove(key K) (present bool) {
+	segment, present := c.segmentOf[key]
+	if !present {
+		return false
+	}
+	c.segments[segment].Delete(key)
+	c.leaveTier(key, segment)
+	delete(c.segmentOf, key)
+	delete(c.recency, key)
+	delete(c.priority, key)
+	delete(c.usedAt, key)
+	delete(c.addedAt, key)
+	return true
+}
+
+//removes the oldest entry and calls the eviction callback
+func (c *unlockedLRU[K, V]) RemoveOldest() (key K, value V, ok bool) {
+	oldest := c.oldest()
+	if oldest == nil {
+		return key, value, false
+	}
+	key, value = oldest.Key, oldest.Value
+	c.evict(key, value, EvictionReasonRemoved)
+	return key, value, true
+}
+
+func (c *unlockedLRU[K, V]) GetOldest() (key K, value V, ok bool) {
+	oldest := c.oldest()
+	if oldest == nil {
+		return key, value, false
+	}
+	return oldest.Key, oldest.Value, true
+}
+
+func (c *unlockedLRU[K, V]) GetNewest() (key K, value V, ok bool) {
+	var newest *gmap.Pair[K, V]
+	for _, entries := range c.segments {
+		pair := entries.Newest()
+		if pair != nil && (newest == nil || c.recency[pair.Key] > c.recency[newest.Key]) {
+			newest = pair
+		}
+	}
+	if newest == nil {
+		return key, value, false
+	}
+	return newest.Key, newest.Value, true
+}
+
+//returns the oldest entry across all segments, nil if there are no entries
+func (c *unlockedLRU[K, V]) oldest() *gmap.Pair[K, V] {
+	var oldest *gmap.Pair[K, V]
+	for _, entries := range c.segments {
+		pair := entries.Oldest()
+		if pair != nil && (oldest == nil || c.recency[pair.Key] < c.recency[oldest.Key]) {
+			oldest = pair
+		}
+	}
+	return oldest
+}
+
+//returns the entries of all segments from oldest to newest
+func (c *unlockedLRU[K, V]) pairs() []*gmap.Pair[K, V] {
+	pairs := make([]*gmap.Pair[K, V], 0, c.Len())
+	for _, entries := range c.segments {
+		for pair := entries.Oldest(); pair != nil; pair = pair.Next() {
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(c.segments) > 1 {
+		slices.SortFunc(pairs, func(a, b *gmap.Pair[K, V]) int {
+			ra, rb := c.recency[a.Key], c.recency[b.Key]
+			if ra < rb {
+				return -1
+			}
+			if ra > rb {
+				return 1
+			}
+			return 0
+		})
+	}
+	return pairs
+}
+
+//calls fn with each entry, from oldest to newest, or from newest to oldest if newestFirst is set, until it returns
+//false, without collecting every entry first
+func (c *unlockedLRU[K, V]) walk(newestFirst bool, fn func(pair *gmap.Pair[K, V]) bool) {
+	cursors := make([]*gmap.Pair[K, V], len(c.segments))
+	for i, entries := range c.segments {
+		if newestFirst {
+			cursors[i] = entries.Newest()
+		} else {
+			cursors[i] = entries.Oldest()
+		}
+	}
+	for {
+		next := -1
+		for i, pair := range cursors {
+			if pair == nil {
+				continue
+			}
+			if next < 0 || (c.recency[pair.Key] > c.recency[cursors[next].Key]) == newestFirst {
+				next = i
+			}
+		}
+		if next < 0 || !fn(cursors[next]) {
+			return
+		}
+		if newestFirst {
+			cursors[next] = cursors[next].Prev()
+		} else {
+			cursors[next] = cursors[next].Next()
+		}
+	}
+}
+
+//returns the keys from oldest to newest
+func (c *unlockedLRU[K, V]) Keys() []K {
+	pairs := c.pairs()
+	keys := make([]K, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
+	}
+	return keys
+}
+
+//returns the values from oldest to newest
+func (c *unlockedLRU[K, V]) Values() []V {
+	pairs := c.pairs()
+	values := make([]V, len(pairs))
+	for i, pair := range pairs {
+		values[i] = pair.Value
+	}
+	return values
+}
+
+func (c *unlockedLRU[K, V]) Len() int {
+	return len(c.segmentOf)
+}
+
+//changes the size, evicting entries if there are too many. Returns the evicted entries
+func (c *unlockedLRU[K, V]) Resize(size int) (evicted []Entry[K, V]) {
+	c.size = size
+	c.policy.rebalance(c)
+	return c.evictOverflow()
+}
+
+//evicts entries until the size is respected. Returns the evicted entries, in the order they were evicted
+func (c *unlockedLRU[K, V]) evictOverflow() (evicted []Entry[K, V]) {
+	return c.EvictN(c.Len() - c.size, EvictionReasonCapacity)
+}
+
+//evicts up to n entries, in the order the policy would evict them to make room, and calls the eviction callback with
+//the given reason. Returns the evicted entries, in the order they were evicted
+func (c *unlockedLRU[K, V]) EvictN(n int, reason EvictionReason) (evicted []Entry[K, V]) {
+	for ; n > 0 && c.Len() > 0; n-- {
+		victim := c.victim()
+		segment := c.segmentOf[victim.Key]
+		c.evict(victim.Key, victim.Value, reason)
+		c.policy.onEvicted(c, victim.Key, segment)
+		evicted = append(evicted, Entry[K, V]{Key: victim.Key, Value: victim.Value})
+	}
+	return evicted
+}
+
+//returns the entry which would be evicted next to make room, without evicting it
+func (c *unlockedLRU[K, V]) PeekVictim() (key K, value V, ok bool) {
+	if c.Len() == 0 {
+		return key, value, false
+	}
+	victim := c.victim()
+	return victim.Key, victim.Value, true
+}
+
+//returns the next entry to evict: the oldest of the entries with the lowest priority, or the newest if mostRecentFirst
+//is set, taken from the segment chosen by the policy when it has one. When a score function is set, the entry with
+//the lowest score among those with the lowest priority is evicted instead. When a minimum residency is set, entries
+//which have not been resident for that long are only evicted if every entry is in that case
+func (c *unlockedLRU[K, V]) victim() *gmap.Pair[K, V] {
+	segment := c.policy.victimSegment(c)
+	if c.segments[segment].Len() == 0 {
+		segment = c.segmentOf[c.oldest().Key]
+	}
+	if len(c.priority) == 0 && c.score == nil && c.minResidency == 0 {
+		if c.mostRecentFirst {
+			return c.segments[segment].Newest()
+		}
+		return c.segments[segment].Oldest()
+	}
+
+	if c.minResidency > 0 {
+		now := c.now()
+		resident := func(key K) bool {
+			return now.Sub(c.addedAt[key]) >= c.minResidency
+		}
+		if victim := c.victimAmong(segment, resident); victim != nil {
+			return victim
+		}
+	}
+	return c.victimAmong(segment, nil)
+}
+
+//returns the next entry to evict among the eligible ones, every entry if `eligible` is nil, or nil if none is eligible.
+//Tiers are tried from the lowest priority, and the keys of a tier are walked in eviction order, stopping at the first
+//eligible one, unless a score function is set, in which case every eligible key of the tier is scored
+func (c *unlockedLRU[K, V]) victimAmong(segment int, eligible func(key K) bool) *gmap.Pair[K, V] {
+	priorities := make([]int, 0, len(c.tiers))
+	for priority := range c.tiers {
+		priorities = append(priorities, priority)
+	}
+	slices.Sort(priorities)
+
+	for _, priority := range priorities {
+		var key K
+		var ok bool
+		if c.score != nil {
+			key, ok = c.lowestScore(c.tiers[priority], eligible)
+		} else {
+			key, ok = c.firstEligible(c.tiers[priority], segment, eligible)
+		}
+		if ok {
+			return c.segments[c.segmentOf[key]].GetPair(key)
+		}
+	}
+	return nil
+}
+
+//returns the first eligible key of a tier in eviction order, from the given segment if it has one
+func (c *unlockedLRU[K, V]) firstEligible(tier []*gmap.OrderedMap[K, struct{}], segment int, eligible func(key K) bool) (key K, ok bool) {
+	if key, ok = c.firstEligibleIn(tier[segment], eligible); ok {
+		return key, true
+	}
+	for i, keys := range tier {
+		if i == segment {
+			continue
+		}
+		if first, found := c.firstEligibleIn(keys, eligible); found && (!ok || c.evictedBefore(first, key)) {
+			key, ok = first, true
+		}
+	}
+	return key, ok
+}
+
+//returns the first eligible key of an ordered map in eviction order: from the oldest, or the newest if mostRecentFirst
+//is set
+func (c *unlockedLRU[K, V]) firstEligibleIn(keys *gmap.OrderedMap[K, struct{}], eligible func(key K) bool) (key K, ok bool) {
+	pair := keys.Oldest()
+	if c.mostRecentFirst {
+		pair = keys.Newest()
+	}
+	for pair != nil {
+		if eligible == nil || eligible(pair.Key) {
+			return pair.Key, true
+		}
+		if c.mostRecentFirst {
+			pair = pair.Prev()
+		} else {
+			pair = pair.Next()
+		}
+	}
+	return key, false
+}
+
+//returns the eligible key of a tier with the lowest score, the first in eviction order among equal scores
+func (c *unlockedLRU[K, V]) lowestScore(tier []*gmap.OrderedMap[K, struct{}], eligible func(key K) bool) (key K, ok bool) {
+	now := c.now()
+	var lowest float64
+	for segment, keys := range tier {
+		for pair := keys.Oldest(); pair != nil; pair = pair.Next() {
+			if eligible != nil && !eligible(pair.Key) {
+				continue
+			}
+			value, _ := c.segments[segment].Get(pair.Key)
+			score := c.score(pair.Key, value, now.Sub(c.usedAt[pair.Key]))
+			if !ok || score < lowest || (score == lowest && c.evictedBefore(pair.Key, key)) {
+				key, lowest, ok = pair.Key, score, true
+			}
+		}
+	}
+	return key, ok
+}
+
+//returns whether key `a` comes before key `b` in eviction order, all else being equal
+func (c *unlockedLRU[K, V]) evictedBefore(a, b K) bool {
+	if c.mostRecentFirst {
+		return c.recency[a] > c.recency[b]
+	}
+	return c.recency[a] < c.recency[b]
+}
+
+//removes every entry, oldest first, calling the eviction callback with the given reason, and forgets the state of the
+//policy. Returns the number of entries removed
+func (c *unlockedLRU[K, V]) Purge(reason EvictionReason) int {
+	pairs := c.pairs()
+	for _, pair := range pairs {
+		c.evict(pair.Key, pair.Value, reason)
+	}
+	c.policy, _ = newSegmentPolicy[K, V](c.config) //cannot fail, the policy was already created once
+	return len(pairs)
+}
+
+//returns an independent copy of the entries, their order and the state of the policy, with each value passed through
+//copyValue, calling onEvict when its entries are evicted
+func (c *unlockedLRU[K, V]) clone(copyValue func(value V) V, onEvict func(key K, value V, reason EvictionReason)) *unlockedLRU[K, V] {
+	clone := *c
+	clone.segments = make([]*gmap.OrderedMap[K, V], len(c.segments))
+	for i, entries := range c.segments {
+		clone.segments[i] = gmap.New[K, V]()
+		for pair := entries.Oldest(); pair != nil; pair = pair.Next() {
+			clone.segments[i].Set(pair.Key, copyValue(pair.Value))
+		}
+	}
+	clone.segmentOf = maps.Clone(c.segmentOf)
+	clone.recency = maps.Clone(c.recency)
+	clone.priority = maps.Clone(c.priority)
+	clone.tiers = make(map[int][]*gmap.OrderedMap[K, struct{}], len(c.tiers))
+	for priority, tier := range c.tiers {
+		clone.tiers[priority] = make([]*gmap.OrderedMap[K, struct{}], len(tier))
+		for i, keys := range tier {
+			clone.tiers[priority][i] = cloneGhosts(keys)
+		}
+	}
+	clone.usedAt = maps.Clone(c.usedAt)
+	clone.addedAt = maps.Clone(c.addedAt)
+	clone.policy = c.policy.clone()
+	clone.onEvict = onEvict
+	return &clone
+}
+
+func (c *unlockedLRU[K, V]) evict(key K, value V, reason EvictionReason) {
+	c.Remove(key)
+	if c.onEvict != nil {
+		c.onEvict(key, value, reason)
+	}
+}